@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPushBandwidthLimit(t *testing.T) {
+	e := newEnv(t)
+	for _, key := range []string{"a.bin", "b.bin", "c.bin"} {
+		e.writeLocal(key, strings.Repeat("x", 64<<10), past)
+	}
+
+	// 192 KiB at 256 KiB/s takes about three quarters of a second, however
+	// the files are spread over the parallel uploads
+	start := time.Now()
+	output := e.mustRun("nv", "push", "--bwlimit", "256KiB/s")
+	assertContains(t, output, "Uploaded:  3 files")
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Errorf("push --bwlimit 256KiB/s of 192 KiB took %v, want at least 500ms", elapsed)
+	}
+}
+
+func TestPullBandwidthLimitFromTimetable(t *testing.T) {
+	e := newEnv(t)
+	e.putRemote("a.bin", strings.Repeat("x", 96<<10), past)
+	e.putRemote("b.bin", strings.Repeat("y", 96<<10), past)
+
+	// The timetable only limits downloads, for the whole day
+	t.Setenv("BWLIMIT", "00:00,off:256KiB/s")
+	start := time.Now()
+	e.mustRun("nv", "pull")
+	if elapsed := time.Since(start); elapsed < 500*time.Millisecond {
+		t.Errorf("pull with BWLIMIT of 256KiB/s for 192 KiB took %v, want at least 500ms", elapsed)
+	}
+	if e.local("b.bin") != strings.Repeat("y", 96<<10) {
+		t.Error("pull with a bandwidth limit corrupted b.bin")
+	}
+}
+
+func TestInvalidBandwidthLimit(t *testing.T) {
+	e := newEnv(t)
+	e.writeLocal("a.txt", "alpha", past)
+
+	if _, err := e.run("nv", "push", "--bwlimit", "fast"); err == nil || !strings.Contains(err.Error(), "invalid --bwlimit") {
+		t.Errorf("push --bwlimit fast error = %v, want invalid --bwlimit", err)
+	}
+
+	t.Setenv("BWLIMIT", "25:00,10MiB/s")
+	if _, err := e.run("nv", "push"); err == nil || !strings.Contains(err.Error(), "invalid BWLIMIT") {
+		t.Errorf("push with BWLIMIT=25:00,10MiB/s error = %v, want invalid BWLIMIT", err)
+	}
+
+	// The flag overrides the configured limit
+	e.mustRun("nv", "push", "--bwlimit", "off")
+	if !e.hasRemote("a.txt") {
+		t.Error("push --bwlimit off did not upload a.txt")
+	}
+}
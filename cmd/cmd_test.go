@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/vngcloud/aiplatform-util/pkg/config"
+	"github.com/vngcloud/aiplatform-util/pkg/s3test"
+)
+
+// env is a fake S3 server and workspace the CLI is run against
+type env struct {
+	t      *testing.T
+	server *s3test.Server
+	cfg    *config.Config
+}
+
+// newEnv starts a fake S3 server and points the CLI configuration at it
+func newEnv(t *testing.T) *env {
+	t.Helper()
+
+	server, cfg := s3test.Start(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", cfg.AccessKeyID)
+	t.Setenv("AWS_SECRET_ACCESS_KEY", cfg.SecretAccessKey)
+	t.Setenv("AWS_ENDPOINT", cfg.Endpoint)
+	t.Setenv("S3_BUCKET", cfg.BucketName)
+	t.Setenv("MOUNT_PATH", cfg.MountPath)
+
+	return &env{t: t, server: server, cfg: cfg}
+}
+
+// run executes the CLI with args like Execute does and returns its output
+func (e *env) run(args ...string) (string, error) {
+	e.t.Helper()
+
+	var buf bytes.Buffer
+	out = &printer{format: outputTable, w: &buf}
+	defer func() { out = &printer{format: outputTable, w: os.Stdout} }()
+
+	resetCommands(rootCmd)
+	rootCmd.SetArgs(args)
+	rootCmd.SetOut(&buf)
+	rootCmd.SetErr(&buf)
+
+	err := rootCmd.ExecuteContext(context.Background())
+	stopTimeout()
+	if err != nil && out.structured() {
+		out.fail(err)
+	}
+	out.flush()
+	return buf.String(), err
+}
+
+// mustRun executes the CLI and fails the test if the command fails
+func (e *env) mustRun(args ...string) string {
+	e.t.Helper()
+
+	output, err := e.run(args...)
+	if err != nil {
+		e.t.Fatalf("%s failed: %v\n%s", strings.Join(args, " "), err, output)
+	}
+	return output
+}
+
+// putRemote stores an object in the bucket
+func (e *env) putRemote(key string, data string, modified time.Time) {
+	e.server.PutObject(e.cfg.BucketName, key, []byte(data), modified)
+}
+
+// remote returns the content of an object, failing the test if it is missing
+func (e *env) remote(key string) string {
+	e.t.Helper()
+
+	data, ok := e.server.GetObject(e.cfg.BucketName, key)
+	if !ok {
+		e.t.Fatalf("remote object %s does not exist", key)
+	}
+	return string(data)
+}
+
+// hasRemote reports whether an object exists in the bucket
+func (e *env) hasRemote(key string) bool {
+	_, ok := e.server.GetObject(e.cfg.BucketName, key)
+	return ok
+}
+
+// writeLocal writes a workspace file with the given modification time
+func (e *env) writeLocal(key string, data string, modified time.Time) {
+	e.t.Helper()
+
+	path := filepath.Join(e.cfg.MountPath, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		e.t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		e.t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		e.t.Fatal(err)
+	}
+}
+
+// local returns the content of a workspace file, failing the test if it is missing
+func (e *env) local(key string) string {
+	e.t.Helper()
+
+	data, err := os.ReadFile(filepath.Join(e.cfg.MountPath, filepath.FromSlash(key)))
+	if err != nil {
+		e.t.Fatalf("local file %s: %v", key, err)
+	}
+	return string(data)
+}
+
+// hasLocal reports whether a workspace file exists
+func (e *env) hasLocal(key string) bool {
+	_, err := os.Stat(filepath.Join(e.cfg.MountPath, filepath.FromSlash(key)))
+	return err == nil
+}
+
+// waitFor polls cond until it holds, failing the test if it does not within
+// a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// resetCommands restores every flag changed by a previous run to its default
+// and drops the context of the previous run, since cobra commands are
+// package-level and keep both
+func resetCommands(c *cobra.Command) {
+	reset := func(f *pflag.Flag) {
+		if !f.Changed {
+			return
+		}
+		if slice, ok := f.Value.(pflag.SliceValue); ok {
+			slice.Replace(nil)
+		} else {
+			f.Value.Set(f.DefValue)
+		}
+		f.Changed = false
+	}
+
+	c.Flags().VisitAll(reset)
+	c.PersistentFlags().VisitAll(reset)
+	c.SetContext(nil)
+	for _, sub := range c.Commands() {
+		resetCommands(sub)
+	}
+}
+
+// assertContains fails the test if output does not contain every substring
+func assertContains(t *testing.T, output string, substrings ...string) {
+	t.Helper()
+
+	for _, s := range substrings {
+		if !strings.Contains(output, s) {
+			t.Errorf("output does not contain %q:\n%s", s, output)
+		}
+	}
+}
@@ -1,13 +1,17 @@
 package cmd
 
 import (
-	"context"
 	"fmt"
+	"path/filepath"
 	"strings"
+	gosync "sync"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/vngcloud/aiplatform-util/pkg/config"
+	"github.com/vngcloud/aiplatform-util/pkg/ignore"
 	"github.com/vngcloud/aiplatform-util/pkg/s3client"
+	"github.com/vngcloud/aiplatform-util/pkg/storage"
 	"github.com/vngcloud/aiplatform-util/pkg/sync"
 )
 
@@ -21,7 +25,12 @@ your local workspace and the S3-compatible network volume.
 Available commands:
   ls    - List files in the network volume
   pull  - Pull files from network volume to local workspace
-  push  - Push files from local workspace to network volume`,
+  push  - Push files from local workspace to network volume
+  sync  - Reconcile changes made on both sides
+  apply - Apply a plan written by pull or push --plan-out
+  rm    - Remove files from the network volume
+  trash   - List, restore and empty soft-deleted files
+  uploads - List and abort incomplete multipart uploads`,
 }
 
 // lsCmd represents the ls command
@@ -33,9 +42,10 @@ var lsCmd = &cobra.Command{
 Examples:
   aiplatform-util nv ls
   aiplatform-util nv ls --prefix models/
-  aiplatform-util nv ls --prefix data/ --recursive`,
+  aiplatform-util nv ls --prefix data/ --recursive
+  aiplatform-util nv ls --prefix runs/ --include "*.json"`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		ctx := context.Background()
+		ctx := cmd.Context()
 
 		// Load configuration
 		cfg, err := config.Load()
@@ -44,9 +54,9 @@ Examples:
 		}
 
 		// Create S3 client
-		client, err := s3client.New(cfg)
+		client, err := newClient(cmd, cfg)
 		if err != nil {
-			return fmt.Errorf("failed to create S3 client: %w", err)
+			return err
 		}
 
 		// If no bucket specified, list available buckets
@@ -55,9 +65,10 @@ Examples:
 			if err != nil {
 				return fmt.Errorf("failed to list buckets: %w", err)
 			}
-			fmt.Println("Available buckets (set S3_BUCKET via /etc/config-nv/S3_BUCKET file or environment variable to select one):")
+			infoln("Available buckets (set S3_BUCKET via /etc/config-nv/S3_BUCKET file or environment variable to select one):")
 			for _, bucket := range buckets {
-				fmt.Printf("  - %s (created: %s)\n", bucket.Name, bucket.CreationDate.Format("2006-01-02 15:04:05"))
+				out.emit(bucketRecord{Type: "bucket", Name: bucket.Name, Created: bucket.CreationDate})
+				infof("  - %s (created: %s)\n", bucket.Name, bucket.CreationDate.Format("2006-01-02 15:04:05"))
 			}
 			return nil
 		}
@@ -65,29 +76,46 @@ Examples:
 		// Get flags
 		prefix, _ := cmd.Flags().GetString("prefix")
 		recursive, _ := cmd.Flags().GetBool("recursive")
+		include, _ := cmd.Flags().GetStringSlice("include")
+		exclude, _ := cmd.Flags().GetStringSlice("exclude")
 
-		// List objects
-		objects, err := client.ListObjects(ctx, prefix, recursive)
+		retry, err := retryPolicy(cmd)
 		if err != nil {
-			return fmt.Errorf("failed to list objects: %w", err)
+			return err
 		}
 
-		if len(objects) == 0 {
-			fmt.Println("No objects found")
-			return nil
-		}
+		// Objects are printed as they are listed, applying include/exclude
+		// patterns relative to the prefix
+		filter := ignore.NewFilter(prefix, include, exclude)
+		count := 0
+		err = storage.WithRetry(client, retry).ListEach(ctx, prefix, recursive, "", func(obj storage.Object) error {
+			if !filter.Selected(obj.Key, strings.HasSuffix(obj.Key, "/")) {
+				return nil
+			}
+			count++
 
-		// Print header
-		fmt.Printf("Listing objects in bucket: %s\n", cfg.BucketName)
-		if prefix != "" {
-			fmt.Printf("Prefix: %s\n", prefix)
-		}
-		fmt.Println()
-		fmt.Printf("%-60s %15s %25s\n", "KEY", "SIZE", "LAST MODIFIED")
-		fmt.Println("─────────────────────────────────────────────────────────────────────────────────────────────────────")
+			if out.structured() {
+				record := objectRecord{Type: "object", Key: obj.Key, Size: obj.Size, ETag: obj.ETag}
+				if obj.LastModified.IsZero() {
+					record.Dir = strings.HasSuffix(obj.Key, "/")
+				} else {
+					record.LastModified = &obj.LastModified
+				}
+				out.emit(record)
+				return nil
+			}
+
+			// Print header
+			if count == 1 {
+				infof("Listing objects in bucket: %s\n", cfg.BucketName)
+				if prefix != "" {
+					infof("Prefix: %s\n", prefix)
+				}
+				infoln()
+				infof("%-60s %15s %25s\n", "KEY", "SIZE", "LAST MODIFIED")
+				infoln("─────────────────────────────────────────────────────────────────────────────────────────────────────")
+			}
 
-		// Print objects
-		for _, obj := range objects {
 			sizeStr := formatSize(obj.Size)
 			modifiedStr := ""
 			if !obj.LastModified.IsZero() {
@@ -97,13 +125,24 @@ Examples:
 			// Mark directories with trailing /
 			key := obj.Key
 			if obj.Size == 0 && obj.ETag == "" && obj.LastModified.IsZero() {
-				fmt.Printf("%-60s %15s %25s\n", key, "<DIR>", "")
+				infof("%-60s %15s %25s\n", key, "<DIR>", "")
 			} else {
-				fmt.Printf("%-60s %15s %25s\n", key, sizeStr, modifiedStr)
+				infof("%-60s %15s %25s\n", key, sizeStr, modifiedStr)
 			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list objects: %w", err)
 		}
 
-		fmt.Printf("\nTotal: %d objects\n", len(objects))
+		if out.structured() {
+			return nil
+		}
+		if count == 0 {
+			infoln("No objects found")
+			return nil
+		}
+		infof("\nTotal: %d objects\n", count)
 		return nil
 	},
 }
@@ -113,15 +152,39 @@ var pullCmd = &cobra.Command{
 	Use:   "pull",
 	Short: "Pull files from network volume to local workspace",
 	Long: `Download files from the network volume (S3 bucket) to your local workspace.
-Only downloads new or modified files by comparing timestamps and sizes.
+Only downloads new or modified files by comparing timestamps and sizes,
+or file content with --checksum.
+
+With --delete, local files missing from the remote are deleted. Deletions are
+counted before anything is downloaded and the run aborts if they exceed
+--max-delete or --max-delete-percent. Deleting every local file because the
+remote prefix is empty (usually a typo or the wrong bucket) requires --force.
+With --trash, deleted files are moved to .aiplatform/trash/ in the workspace
+instead, see "nv trash".
+
+With --plan-out, nothing is changed and the planned actions are written to a
+plan file that "nv apply" executes exactly as reviewed.
+
+Throttling, server and network errors are retried with exponential backoff.
+Files that still fail make the command exit with a non-zero status, and with
+--failed-out their keys are written to a file that --retry-from accepts.
 
 Examples:
   aiplatform-util nv pull
   aiplatform-util nv pull --prefix models/
   aiplatform-util nv pull --dry-run
-  aiplatform-util nv pull --delete`,
+  aiplatform-util nv pull --delete
+  aiplatform-util nv pull --delete --max-delete 100
+  aiplatform-util nv pull --delete --trash
+  aiplatform-util nv pull --prefix runs/42/ --include "*.json"
+  aiplatform-util nv pull --parallel 16
+  aiplatform-util nv pull --checksum
+  aiplatform-util nv pull --delete --plan-out plan.json
+  aiplatform-util nv pull --prefix checkpoints/ --download-threads 16 --chunk-size 128MiB
+  aiplatform-util nv pull --retries 8 --failed-out failed.txt
+  aiplatform-util nv pull --retry-from failed.txt`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		ctx := context.Background()
+		ctx := cmd.Context()
 
 		// Load configuration
 		cfg, err := config.Load()
@@ -135,56 +198,117 @@ Examples:
 		}
 
 		// Create S3 client
-		client, err := s3client.New(cfg)
+		client, err := newClient(cmd, cfg)
+		if err != nil {
+			return err
+		}
+
+		downloadOpts, err := downloadOptions(cmd)
 		if err != nil {
-			return fmt.Errorf("failed to create S3 client: %w", err)
+			return err
+		}
+		client.SetDownloadOptions(downloadOpts)
+
+		retry, err := retryPolicy(cmd)
+		if err != nil {
+			return err
 		}
 
 		// Get flags
 		prefix, _ := cmd.Flags().GetString("prefix")
 		dryRun, _ := cmd.Flags().GetBool("dry-run")
 		deleteLocal, _ := cmd.Flags().GetBool("delete")
+		parallel, _ := cmd.Flags().GetInt("parallel")
+		checksum, _ := cmd.Flags().GetBool("checksum")
+		limits, err := deleteLimits(cmd)
+		if err != nil {
+			return err
+		}
+		include, _ := cmd.Flags().GetStringSlice("include")
+		exclude, _ := cmd.Flags().GetStringSlice("exclude")
+		trash, _ := cmd.Flags().GetBool("trash")
+		planOut, _ := cmd.Flags().GetString("plan-out")
+		keys, err := retryKeys(cmd)
+		if err != nil {
+			return err
+		}
+
+		// Writing a plan never changes anything
+		var plan *sync.Plan
+		if planOut != "" {
+			plan = sync.NewPlan("pull", cfg.BucketName, cfg.MountPath, prefix)
+			dryRun = true
+		}
 
 		// Print operation info
-		fmt.Printf("Pulling from bucket: %s to %s\n", cfg.BucketName, cfg.MountPath)
+		infof("Pulling from bucket: %s to %s\n", cfg.BucketName, cfg.MountPath)
 		if prefix != "" {
-			fmt.Printf("Prefix: %s\n", prefix)
+			infof("Prefix: %s\n", prefix)
+		}
+		if len(include) > 0 {
+			infof("Include patterns: %v\n", include)
+		}
+		if len(exclude) > 0 {
+			infof("Exclude patterns: %v\n", exclude)
+		}
+		if keys != nil {
+			infof("Retrying %d failed files\n", len(keys))
+		}
+		if trash {
+			infoln("Deleted files will be moved to the trash")
 		}
 		if dryRun {
-			fmt.Println("DRY RUN - no changes will be made")
+			infoln("DRY RUN - no changes will be made")
 		}
-		fmt.Println()
+		infoln()
 
 		// Perform pull
-		stats, err := sync.Pull(ctx, client, sync.PullOptions{
-			Prefix:    prefix,
-			DryRun:    dryRun,
-			Delete:    deleteLocal,
-			MountPath: cfg.MountPath,
+		stats, err := sync.Pull(ctx, storage.WithRetry(client, retry), sync.PullOptions{
+			Prefix:       prefix,
+			DryRun:       dryRun,
+			Delete:       deleteLocal,
+			IncludeGlobs: include,
+			ExcludeGlobs: exclude,
+			MountPath:    cfg.MountPath,
+			Parallel:     parallel,
+			Checksum:     checksum,
+			Limits:       limits,
+			Trash:        trash,
+			Plan:         plan,
+			Keys:         keys,
+			Observer:     out.observer(dryRun),
 		})
 		if err != nil {
-			return fmt.Errorf("pull failed: %w", err)
+			err = fmt.Errorf("pull failed: %w", err)
+			if stats == nil {
+				return err
+			}
 		}
+		out.stats("pull", dryRun, stats)
 
-		// Print summary
-		fmt.Println()
-		fmt.Println("─────────────────────────────────────")
-		if dryRun {
-			fmt.Println("Summary (dry run):")
-		} else {
-			fmt.Println("Summary:")
+		if plan != nil && err == nil {
+			if err := plan.Save(planOut); err != nil {
+				return err
+			}
+			infof("\nPlan with %d actions written to %s, run \"aiplatform-util nv apply %s\" to apply it\n", len(plan.Steps), planOut, planOut)
 		}
-		fmt.Printf("  Downloaded: %d files\n", stats.Downloaded)
-		fmt.Printf("  Skipped:    %d files (already up to date)\n", stats.Skipped)
+
+		// Print summary
+		infoln()
+		infoln("─────────────────────────────────────")
+		summaryTitle(dryRun, err)
+		infof("  Downloaded: %d files\n", stats.Downloaded)
+		infof("  Skipped:    %d files (already up to date)\n", stats.Skipped)
 		if deleteLocal {
-			fmt.Printf("  Deleted:    %d files\n", stats.Deleted)
+			infof("  Deleted:    %d files\n", stats.Deleted)
 		}
 		if stats.Failed > 0 {
-			fmt.Printf("  Failed:     %d files\n", stats.Failed)
+			infof("  Failed:     %d files\n", stats.Failed)
+			printKeys(stats.FailedKeys)
 		}
-		fmt.Println("─────────────────────────────────────")
+		infoln("─────────────────────────────────────")
 
-		return nil
+		return reportFailures(cmd, stats.FailedKeys, err)
 	},
 }
 
@@ -193,16 +317,62 @@ var pushCmd = &cobra.Command{
 	Use:   "push",
 	Short: "Push files from local workspace to network volume",
 	Long: `Upload files from your local workspace to the network volume (S3 bucket).
-Only uploads new or modified files by comparing timestamps and sizes.
+Only uploads new or modified files by comparing timestamps and sizes,
+or file content with --checksum.
+
+Files matching --exclude patterns or patterns in .nvignore files (gitignore
+syntax, at any level of the workspace) are skipped.
+
+With --delete, remote files missing locally are deleted. Deletions are
+counted before anything is uploaded and the run aborts if they exceed
+--max-delete or --max-delete-percent. Deleting every remote file because the
+local directory is empty requires --force.
+With --trash, deleted objects are moved to the .trash/ prefix of the bucket
+instead, see "nv trash".
+
+With --plan-out, nothing is changed and the planned actions are written to a
+plan file that "nv apply" executes exactly as reviewed.
+
+Throttling, server and network errors are retried with exponential backoff.
+Files that still fail make the command exit with a non-zero status, and with
+--failed-out their keys are written to a file that --retry-from accepts.
+
+Large files are uploaded in parts, and an interrupted upload leaves its parts
+in the bucket. With --abort-stale-uploads, incomplete uploads of a file
+started longer ago than the given duration are aborted before the file is
+uploaded again, see "nv uploads".
+
+Symbolic links are skipped with a warning unless --symlinks is "follow",
+which pushes the files they point to, or "preserve", which pushes the links
+themselves for pull to recreate. Links that point into a directory containing
+them are never followed. FIFOs, sockets and devices are always skipped.
+Empty directories are only pushed with --keep-empty-dirs, as "dir/" markers
+that pull recreates.
+
+With --watch, everything is pushed once and the command keeps running,
+pushing files as they are created, modified or deleted. A file is pushed
+once it has stayed unchanged for --debounce, so that a checkpoint being
+written is uploaded when it is complete. Failed pushes are tried again and a
+status line is printed every --status-interval. Press Ctrl-C to stop.
 
 Examples:
   aiplatform-util nv push
   aiplatform-util nv push --prefix models/
   aiplatform-util nv push --dry-run
   aiplatform-util nv push --delete
-  aiplatform-util nv push --exclude "*.tmp" --exclude ".git/*"`,
+  aiplatform-util nv push --delete --max-delete-percent 10
+  aiplatform-util nv push --delete --trash
+  aiplatform-util nv push --exclude "*.tmp" --exclude ".git/" --exclude "**/__pycache__/"
+  aiplatform-util nv push --parallel 16
+  aiplatform-util nv push --checksum
+  aiplatform-util nv push --delete --plan-out plan.json
+  aiplatform-util nv push --retries 8 --failed-out failed.txt
+  aiplatform-util nv push --retry-from failed.txt
+  aiplatform-util nv push --abort-stale-uploads 24h
+  aiplatform-util nv push --symlinks preserve --keep-empty-dirs
+  aiplatform-util nv push --watch --exclude "*.tmp"`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		ctx := context.Background()
+		ctx := cmd.Context()
 
 		// Load configuration
 		cfg, err := config.Load()
@@ -216,61 +386,459 @@ Examples:
 		}
 
 		// Create S3 client
-		client, err := s3client.New(cfg)
+		client, err := newClient(cmd, cfg)
 		if err != nil {
-			return fmt.Errorf("failed to create S3 client: %w", err)
+			return err
+		}
+
+		retry, err := retryPolicy(cmd)
+		if err != nil {
+			return err
 		}
 
 		// Get flags
 		prefix, _ := cmd.Flags().GetString("prefix")
 		dryRun, _ := cmd.Flags().GetBool("dry-run")
 		deleteRemote, _ := cmd.Flags().GetBool("delete")
+		include, _ := cmd.Flags().GetStringSlice("include")
 		exclude, _ := cmd.Flags().GetStringSlice("exclude")
+		parallel, _ := cmd.Flags().GetInt("parallel")
+		checksum, _ := cmd.Flags().GetBool("checksum")
+		limits, err := deleteLimits(cmd)
+		if err != nil {
+			return err
+		}
+		trash, _ := cmd.Flags().GetBool("trash")
+		planOut, _ := cmd.Flags().GetString("plan-out")
+		keys, err := retryKeys(cmd)
+		if err != nil {
+			return err
+		}
+		staleStr, _ := cmd.Flags().GetString("abort-stale-uploads")
+		staleUploads, err := config.ParseDuration(staleStr)
+		if err != nil {
+			return fmt.Errorf("invalid --abort-stale-uploads: %w", err)
+		}
+		symlinksName, _ := cmd.Flags().GetString("symlinks")
+		symlinks, err := sync.ParseSymlinkPolicy(symlinksName)
+		if err != nil {
+			return err
+		}
+		keepEmptyDirs, _ := cmd.Flags().GetBool("keep-empty-dirs")
+		watch, _ := cmd.Flags().GetBool("watch")
+		if watch && (dryRun || planOut != "") {
+			return fmt.Errorf("--watch cannot be used with --dry-run or --plan-out")
+		}
+
+		// Writing a plan never changes anything
+		var plan *sync.Plan
+		if planOut != "" {
+			plan = sync.NewPlan("push", cfg.BucketName, cfg.MountPath, prefix)
+			dryRun = true
+		}
 
 		// Print operation info
-		fmt.Printf("Pushing from %s to bucket: %s\n", cfg.MountPath, cfg.BucketName)
+		infof("Pushing from %s to bucket: %s\n", cfg.MountPath, cfg.BucketName)
 		if prefix != "" {
-			fmt.Printf("Prefix: %s\n", prefix)
+			infof("Prefix: %s\n", prefix)
+		}
+		if len(include) > 0 {
+			infof("Include patterns: %v\n", include)
 		}
 		if len(exclude) > 0 {
-			fmt.Printf("Exclude patterns: %v\n", exclude)
+			infof("Exclude patterns: %v\n", exclude)
+		}
+		if keys != nil {
+			infof("Retrying %d failed files\n", len(keys))
+		}
+		if trash {
+			infoln("Deleted files will be moved to the trash")
 		}
 		if dryRun {
-			fmt.Println("DRY RUN - no changes will be made")
+			infoln("DRY RUN - no changes will be made")
+		}
+		infoln()
+
+		opts := sync.PushOptions{
+			Prefix:        prefix,
+			DryRun:        dryRun,
+			Delete:        deleteRemote,
+			IncludeGlobs:  include,
+			ExcludeGlobs:  exclude,
+			MountPath:     cfg.MountPath,
+			Parallel:      parallel,
+			Checksum:      checksum,
+			Limits:        limits,
+			Trash:         trash,
+			Plan:          plan,
+			Keys:          keys,
+			StaleUploads:  staleUploads,
+			Symlinks:      symlinks,
+			KeepEmptyDirs: keepEmptyDirs,
+			Observer:      out.observer(dryRun),
+		}
+		if watch {
+			return watchPush(cmd, storage.WithRetry(client, retry), opts)
 		}
-		fmt.Println()
 
 		// Perform push
-		stats, err := sync.Push(ctx, client, sync.PushOptions{
+		stats, err := sync.Push(ctx, storage.WithRetry(client, retry), opts)
+		if err != nil {
+			err = fmt.Errorf("push failed: %w", err)
+			if stats == nil {
+				return err
+			}
+		}
+		out.stats("push", dryRun, stats)
+
+		if plan != nil && err == nil {
+			if err := plan.Save(planOut); err != nil {
+				return err
+			}
+			infof("\nPlan with %d actions written to %s, run \"aiplatform-util nv apply %s\" to apply it\n", len(plan.Steps), planOut, planOut)
+		}
+
+		// Print summary
+		infoln()
+		infoln("─────────────────────────────────────")
+		summaryTitle(dryRun, err)
+		infof("  Uploaded:  %d files\n", stats.Uploaded)
+		infof("  Skipped:   %d files (already up to date)\n", stats.Skipped)
+		if deleteRemote {
+			infof("  Deleted:   %d files\n", stats.Deleted)
+		}
+		if stats.AbortedUploads > 0 {
+			infof("  Aborted:   %d stale uploads\n", stats.AbortedUploads)
+		}
+		if stats.Failed > 0 {
+			infof("  Failed:    %d files\n", stats.Failed)
+			printKeys(stats.FailedKeys)
+		}
+		infoln("─────────────────────────────────────")
+
+		return reportFailures(cmd, stats.FailedKeys, err)
+	},
+}
+
+// watchPush pushes the changes made to the workspace until the command is
+// interrupted, printing a status line every --status-interval
+func watchPush(cmd *cobra.Command, client storage.Storage, opts sync.PushOptions) error {
+	debounceStr, _ := cmd.Flags().GetString("debounce")
+	intervalStr, _ := cmd.Flags().GetString("status-interval")
+
+	debounce, err := config.ParseDuration(debounceStr)
+	if err != nil {
+		return fmt.Errorf("invalid --debounce: %w", err)
+	}
+	interval, err := config.ParseDuration(intervalStr)
+	if err != nil {
+		return fmt.Errorf("invalid --status-interval: %w", err)
+	}
+
+	infof("Watching %s for changes (press Ctrl-C to stop)\n\n", filepath.Join(opts.MountPath, opts.Prefix))
+
+	stats, err := sync.Watch(cmd.Context(), client, sync.WatchOptions{
+		Push:           opts,
+		Debounce:       debounce,
+		StatusInterval: interval,
+		Status: func(status sync.WatchStats) {
+			infof("[%s] Uploaded %d, deleted %d, failed %d, pending %d files",
+				time.Now().Format("15:04:05"), status.Uploaded, status.Deleted, status.Failed, status.Pending)
+			if status.LastError != "" {
+				infof(" (last push failed: %s)", status.LastError)
+			}
+			infoln()
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("watch failed: %w", err)
+	}
+	out.stats("push", false, stats)
+
+	// Print summary
+	infoln()
+	infoln("─────────────────────────────────────")
+	infoln("Summary:")
+	infof("  Pushes:     %d\n", stats.Pushes)
+	infof("  Uploaded:   %d files\n", stats.Uploaded)
+	if opts.Delete {
+		infof("  Deleted:    %d files\n", stats.Deleted)
+	}
+	if stats.Failed > 0 {
+		infof("  Failed:     %d files (tried again)\n", stats.Failed)
+	}
+	if stats.Pending > 0 {
+		infof("  Not pushed: %d files\n", stats.Pending)
+		printKeys(stats.PendingKeys)
+	}
+	infoln("─────────────────────────────────────")
+
+	var runErr error
+	if stats.Pending > 0 {
+		cmd.SilenceUsage = true
+		runErr = fmt.Errorf("%d changed files were not pushed", stats.Pending)
+	}
+	return reportFailures(cmd, stats.PendingKeys, runErr)
+}
+
+// syncCmd represents the sync command
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "Reconcile local workspace and network volume in both directions",
+	Long: `Reconcile a workspace edited both locally and remotely (e.g. by a training job
+writing to the same bucket). Changes are detected against the state recorded by the
+last pull, push or sync: files changed on one side are copied to the other, files
+deleted on one side are deleted on the other, and files changed on both sides are
+reported as conflicts and resolved according to --prefer.
+
+Conflict policies:
+  local   - keep the local version
+  remote  - keep the remote version
+  newer   - keep whichever version was modified last
+  both    - keep the remote version and save the local one as <name>.conflict-<timestamp>
+
+Deletions on each side are checked against --max-delete and --max-delete-percent
+before any change is made, and deleting everything on one side because the other
+side is empty requires --force. With --trash, deleted files are moved to the
+trash on their side instead, see "nv trash".
+
+Examples:
+  aiplatform-util nv sync
+  aiplatform-util nv sync --prefix outputs/
+  aiplatform-util nv sync --dry-run
+  aiplatform-util nv sync --prefer newer
+  aiplatform-util nv sync --max-delete 50
+  aiplatform-util nv sync --trash`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		// Load configuration
+		cfg, err := config.Load()
+		if err != nil {
+			return fmt.Errorf("failed to load configuration: %w", err)
+		}
+
+		// Check bucket name is set
+		if cfg.BucketName == "" {
+			return fmt.Errorf("S3_BUCKET is required for sync operations (set via /etc/config-nv/S3_BUCKET file or environment variable)")
+		}
+
+		// Get flags
+		prefix, _ := cmd.Flags().GetString("prefix")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		include, _ := cmd.Flags().GetStringSlice("include")
+		exclude, _ := cmd.Flags().GetStringSlice("exclude")
+		parallel, _ := cmd.Flags().GetInt("parallel")
+		checksum, _ := cmd.Flags().GetBool("checksum")
+		limits, err := deleteLimits(cmd)
+		if err != nil {
+			return err
+		}
+		preferName, _ := cmd.Flags().GetString("prefer")
+		trash, _ := cmd.Flags().GetBool("trash")
+
+		prefer, err := sync.ParseConflictPolicy(preferName)
+		if err != nil {
+			return err
+		}
+		symlinksName, _ := cmd.Flags().GetString("symlinks")
+		symlinks, err := sync.ParseSymlinkPolicy(symlinksName)
+		if err != nil {
+			return err
+		}
+		keys, err := retryKeys(cmd)
+		if err != nil {
+			return err
+		}
+
+		downloadOpts, err := downloadOptions(cmd)
+		if err != nil {
+			return err
+		}
+		retry, err := retryPolicy(cmd)
+		if err != nil {
+			return err
+		}
+
+		// Create S3 client
+		client, err := newClient(cmd, cfg)
+		if err != nil {
+			return err
+		}
+		client.SetDownloadOptions(downloadOpts)
+
+		// Print operation info
+		infof("Syncing %s with bucket: %s\n", cfg.MountPath, cfg.BucketName)
+		if prefix != "" {
+			infof("Prefix: %s\n", prefix)
+		}
+		if len(include) > 0 {
+			infof("Include patterns: %v\n", include)
+		}
+		if len(exclude) > 0 {
+			infof("Exclude patterns: %v\n", exclude)
+		}
+		if keys != nil {
+			infof("Retrying %d failed files\n", len(keys))
+		}
+		infof("Conflict policy: %s\n", prefer)
+		if trash {
+			infoln("Deleted files will be moved to the trash")
+		}
+		if dryRun {
+			infoln("DRY RUN - no changes will be made")
+		}
+		infoln()
+
+		// Perform sync
+		stats, err := sync.Bidirectional(ctx, storage.WithRetry(client, retry), sync.SyncOptions{
 			Prefix:       prefix,
 			DryRun:       dryRun,
-			Delete:       deleteRemote,
+			IncludeGlobs: include,
 			ExcludeGlobs: exclude,
 			MountPath:    cfg.MountPath,
+			Parallel:     parallel,
+			Checksum:     checksum,
+			Limits:       limits,
+			Prefer:       prefer,
+			Trash:        trash,
+			Keys:         keys,
+			Symlinks:     symlinks,
+			Observer:     out.observer(dryRun),
 		})
 		if err != nil {
-			return fmt.Errorf("push failed: %w", err)
+			err = fmt.Errorf("sync failed: %w", err)
+			if stats == nil {
+				return err
+			}
 		}
+		out.stats("sync", dryRun, stats)
 
 		// Print summary
-		fmt.Println()
-		fmt.Println("─────────────────────────────────────")
-		if dryRun {
-			fmt.Println("Summary (dry run):")
-		} else {
-			fmt.Println("Summary:")
+		infoln()
+		infoln("─────────────────────────────────────")
+		summaryTitle(dryRun, err)
+		infof("  Uploaded:       %d files\n", stats.Uploaded)
+		infof("  Downloaded:     %d files\n", stats.Downloaded)
+		infof("  Deleted local:  %d files\n", stats.DeletedLocal)
+		infof("  Deleted remote: %d files\n", stats.DeletedRemote)
+		infof("  Skipped:        %d files (already in sync)\n", stats.Skipped)
+		if stats.Failed > 0 {
+			infof("  Failed:         %d files\n", stats.Failed)
+			printKeys(stats.FailedKeys)
 		}
-		fmt.Printf("  Uploaded:  %d files\n", stats.Uploaded)
-		fmt.Printf("  Skipped:   %d files (already up to date)\n", stats.Skipped)
-		if deleteRemote {
-			fmt.Printf("  Deleted:   %d files\n", stats.Deleted)
+		if len(stats.Conflicts) > 0 {
+			infof("  Conflicts:      %d files\n", len(stats.Conflicts))
+			for _, conflict := range stats.Conflicts {
+				infof("    %s (%s, %s)\n", conflict.Key, conflict.Reason, conflict.Resolution)
+			}
+		}
+		infoln("─────────────────────────────────────")
+
+		return reportFailures(cmd, stats.FailedKeys, err)
+	},
+}
+
+// applyCmd represents the apply command
+var applyCmd = &cobra.Command{
+	Use:   "apply <plan.json>",
+	Short: "Apply a plan written by pull or push --plan-out",
+	Long: `Execute exactly the actions of a plan written by "nv pull --plan-out" or
+"nv push --plan-out". Nothing is recomputed: before each action, the local file
+and remote object are checked against the ETag, size and modification time
+recorded in the plan, and actions whose preconditions changed are refused.
+
+Examples:
+  aiplatform-util nv push --delete --plan-out plan.json
+  aiplatform-util nv apply plan.json
+  aiplatform-util nv apply plan.json --trash`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		// Load configuration
+		cfg, err := config.Load()
+		if err != nil {
+			return fmt.Errorf("failed to load configuration: %w", err)
+		}
+
+		// Check bucket name is set
+		if cfg.BucketName == "" {
+			return fmt.Errorf("S3_BUCKET is required for apply operations (set via /etc/config-nv/S3_BUCKET file or environment variable)")
+		}
+
+		plan, err := sync.LoadPlan(args[0])
+		if err != nil {
+			return err
+		}
+		if plan.Bucket != cfg.BucketName {
+			return fmt.Errorf("plan was made for bucket %s, not %s", plan.Bucket, cfg.BucketName)
+		}
+
+		// Get flags
+		parallel, _ := cmd.Flags().GetInt("parallel")
+		trash, _ := cmd.Flags().GetBool("trash")
+
+		downloadOpts, err := downloadOptions(cmd)
+		if err != nil {
+			return err
+		}
+		retry, err := retryPolicy(cmd)
+		if err != nil {
+			return err
+		}
+
+		// Create S3 client
+		client, err := newClient(cmd, cfg)
+		if err != nil {
+			return err
+		}
+		client.SetDownloadOptions(downloadOpts)
+
+		// Print operation info
+		infof("Applying %s plan from %s (created %s)\n", plan.Command, args[0], plan.Created.Local().Format("2006-01-02 15:04:05"))
+		infof("Workspace: %s, bucket: %s\n", plan.MountPath, plan.Bucket)
+		if plan.Prefix != "" {
+			infof("Prefix: %s\n", plan.Prefix)
+		}
+		if trash {
+			infoln("Deleted files will be moved to the trash")
+		}
+		infoln()
+
+		// Apply plan
+		stats, err := sync.Apply(ctx, storage.WithRetry(client, retry), plan, sync.ApplyOptions{
+			MountPath: cfg.MountPath,
+			Parallel:  parallel,
+			Trash:     trash,
+			Observer:  out.observer(false),
+		})
+		if err != nil {
+			err = fmt.Errorf("apply failed: %w", err)
+			if stats == nil {
+				return err
+			}
+		}
+		out.stats("apply", false, stats)
+
+		// Print summary
+		infoln()
+		infoln("─────────────────────────────────────")
+		summaryTitle(false, err)
+		infof("  Uploaded:       %d files\n", stats.Uploaded)
+		infof("  Downloaded:     %d files\n", stats.Downloaded)
+		infof("  Deleted local:  %d files\n", stats.DeletedLocal)
+		infof("  Deleted remote: %d files\n", stats.DeletedRemote)
+		if stats.Refused > 0 {
+			infof("  Refused:        %d files (changed since the plan was made)\n", stats.Refused)
 		}
 		if stats.Failed > 0 {
-			fmt.Printf("  Failed:    %d files\n", stats.Failed)
+			infof("  Failed:         %d files\n", stats.Failed)
+			printKeys(stats.FailedKeys)
 		}
-		fmt.Println("─────────────────────────────────────")
+		infoln("─────────────────────────────────────")
 
-		return nil
+		return reportFailures(cmd, stats.FailedKeys, err)
 	},
 }
 
@@ -279,15 +847,21 @@ var rmCmd = &cobra.Command{
 	Use:   "rm [key...]",
 	Short: "Remove files from network volume",
 	Long: `Remove one or more files from the network volume (S3 bucket).
+With --trash, objects are moved to the .trash/ prefix of the bucket instead of
+being removed permanently, see "nv trash".
 
 Examples:
   aiplatform-util nv rm myfile.txt
   aiplatform-util nv rm file1.txt file2.txt
   aiplatform-util nv rm models/model.pth
   aiplatform-util nv rm --prefix data/  # Remove all files under data/
-  aiplatform-util nv rm --prefix data/ --dry-run  # Preview what would be deleted`,
+  aiplatform-util nv rm --prefix data/ --dry-run  # Preview what would be deleted
+  aiplatform-util nv rm --prefix data/ --trash  # Keep a recoverable copy in the trash
+  aiplatform-util nv rm --prefix runs/ --include "*.tmp"  # Remove only temporary files
+  aiplatform-util nv rm --prefix data/ --max-delete 100  # Abort if more than 100 files match
+  aiplatform-util nv rm --prefix "" --force  # Remove everything in the bucket`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		ctx := context.Background()
+		ctx := cmd.Context()
 
 		// Load configuration
 		cfg, err := config.Load()
@@ -301,84 +875,373 @@ Examples:
 		}
 
 		// Create S3 client
-		client, err := s3client.New(cfg)
+		client, err := newClient(cmd, cfg)
+		if err != nil {
+			return err
+		}
+
+		retry, err := retryPolicy(cmd)
 		if err != nil {
-			return fmt.Errorf("failed to create S3 client: %w", err)
+			return err
 		}
+		store := storage.WithRetry(client, retry)
 
 		// Get flags
 		prefix, _ := cmd.Flags().GetString("prefix")
 		dryRun, _ := cmd.Flags().GetBool("dry-run")
 		recursive, _ := cmd.Flags().GetBool("recursive")
+		include, _ := cmd.Flags().GetStringSlice("include")
+		exclude, _ := cmd.Flags().GetStringSlice("exclude")
+		useTrash, _ := cmd.Flags().GetBool("trash")
 
-		var keysToDelete []string
+		// Include/exclude patterns are relative to the prefix
+		filter := ignore.NewFilter(prefix, include, exclude)
 
-		// If prefix is provided, list and delete all files under that prefix
-		if prefix != "" {
-			objects, err := client.ListObjects(ctx, prefix, recursive)
-			if err != nil {
-				return fmt.Errorf("failed to list objects: %w", err)
+		// Delete files as they are selected, printing the operation info
+		// before the first one
+		stats := rmStats{}
+		trash := sync.NewTrash(store, cfg.MountPath, time.Now())
+		selected := 0
+		deleteKey := func(key string) error {
+			if err := ctx.Err(); err != nil {
+				return fmt.Errorf("rm interrupted: %w", err)
 			}
 
-			for _, obj := range objects {
-				// Skip directories
-				if !strings.HasSuffix(obj.Key, "/") {
-					keysToDelete = append(keysToDelete, obj.Key)
+			selected++
+			if selected == 1 {
+				infof("Removing from bucket: %s\n", cfg.BucketName)
+				if useTrash {
+					infoln("Deleted files will be moved to the trash")
 				}
+				if dryRun {
+					infoln("DRY RUN - no changes will be made")
+				}
+				infoln()
 			}
-		} else if len(args) == 0 {
-			return fmt.Errorf("either provide file keys as arguments or use --prefix flag")
-		} else {
-			// Use provided arguments as keys
-			keysToDelete = args
-		}
 
-		if len(keysToDelete) == 0 {
-			fmt.Println("No files to delete")
+			infof("Deleting: %s\n", key)
+			event := sync.FileEvent{Action: sync.EventDeleteRemote, Key: key}
+			if dryRun {
+				out.file(event, statusDryRun, nil)
+				return nil
+			}
+			remove := store.Delete
+			if useTrash {
+				remove = trash.RemoveObject
+			}
+			if err := remove(ctx, key); err != nil {
+				infof("  Failed: %v\n", err)
+				stats.Failed++
+				stats.FailedKeys = append(stats.FailedKeys, key)
+				out.file(event, statusFailed, err)
+			} else {
+				stats.Deleted++
+				out.file(event, statusDone, nil)
+			}
 			return nil
 		}
 
-		// Print operation info
-		fmt.Printf("Removing from bucket: %s\n", cfg.BucketName)
-		if dryRun {
-			fmt.Println("DRY RUN - no changes will be made")
-		}
-		fmt.Println()
+		var interrupted error
 
-		// Delete files
-		deleted := 0
-		failed := 0
+		// If prefix is provided, delete all files under that prefix. An
+		// empty prefix selects the whole bucket and requires --force.
+		if cmd.Flags().Changed("prefix") {
+			force, _ := cmd.Flags().GetBool("force")
+			if strings.Trim(prefix, "/") == "" && !force {
+				return fmt.Errorf("refusing to delete every object in bucket %s without --force", cfg.BucketName)
+			}
+			limits, err := deleteLimits(cmd)
+			if err != nil {
+				return err
+			}
 
-		for _, key := range keysToDelete {
-			fmt.Printf("Deleting: %s\n", key)
-			if !dryRun {
-				if err := client.DeleteObject(ctx, key); err != nil {
-					fmt.Printf("  Failed: %v\n", err)
-					failed++
-				} else {
-					deleted++
+			// Skip directories and files not selected by the filters.
+			// Trashed objects are never moved into the trash again.
+			selectedObject := func(obj storage.Object) bool {
+				if useTrash && sync.IsTrashKey(obj.Key) {
+					return false
+				}
+				return !strings.HasSuffix(obj.Key, "/") && filter.Selected(obj.Key, false)
+			}
+
+			// Count the deletions with a full listing before deleting
+			// anything, so a failed listing or exceeded limit leaves the
+			// prefix untouched
+			count, total := 0, 0
+			err = store.ListEach(ctx, prefix, recursive, "", func(obj storage.Object) error {
+				if !strings.HasSuffix(obj.Key, "/") {
+					total++
+				}
+				if selectedObject(obj) {
+					count++
+				}
+				return nil
+			})
+			if err != nil {
+				return fmt.Errorf("failed to list objects: %w", err)
+			}
+			if err := limits.Check(count, total); err != nil {
+				return err
+			}
+
+			// Objects added since they were counted are left alone
+			var deleteErr error
+			err = store.ListEach(ctx, prefix, recursive, "", func(obj storage.Object) error {
+				if !selectedObject(obj) {
+					return nil
+				}
+				if selected == count {
+					infof("Skipping: %s (not counted when the delete limits were checked)\n", obj.Key)
+					return nil
 				}
+				deleteErr = deleteKey(obj.Key)
+				return deleteErr
+			})
+			switch {
+			case deleteErr != nil:
+				interrupted = deleteErr
+			case err != nil && selected == 0:
+				return fmt.Errorf("failed to list objects: %w", err)
+			case err != nil:
+				// Report the files already deleted before failing
+				interrupted = fmt.Errorf("failed to list objects: %w", err)
 			}
+		} else {
+			keys, err := retryKeys(cmd)
+			if err != nil {
+				return err
+			}
+			if keys == nil {
+				if len(args) == 0 {
+					return fmt.Errorf("either provide file keys as arguments or use --prefix or --retry-from flag")
+				}
+				// Use provided arguments as keys
+				keys = args
+			}
+			for _, key := range keys {
+				if !filter.Selected(key, false) {
+					continue
+				}
+				if interrupted = deleteKey(key); interrupted != nil {
+					break
+				}
+			}
+		}
+
+		if selected == 0 && interrupted == nil {
+			infoln("No files to delete")
+			out.stats("rm", dryRun, rmStats{})
+			return nil
 		}
+		out.stats("rm", dryRun, stats)
 
 		// Print summary
-		fmt.Println()
-		fmt.Println("─────────────────────────────────────")
+		infoln()
+		infoln("─────────────────────────────────────")
 		if dryRun {
-			fmt.Printf("Summary (dry run): %d files would be deleted\n", len(keysToDelete))
+			infof("Summary (dry run): %d files would be deleted\n", selected)
 		} else {
-			fmt.Println("Summary:")
-			fmt.Printf("  Deleted: %d files\n", deleted)
-			if failed > 0 {
-				fmt.Printf("  Failed:  %d files\n", failed)
+			summaryTitle(false, interrupted)
+			infof("  Deleted: %d files\n", stats.Deleted)
+			if stats.Failed > 0 {
+				infof("  Failed:  %d files\n", stats.Failed)
+				printKeys(stats.FailedKeys)
 			}
 		}
-		fmt.Println("─────────────────────────────────────")
+		infoln("─────────────────────────────────────")
 
-		return nil
+		return reportFailures(cmd, stats.FailedKeys, interrupted)
 	},
 }
 
+// rmStats contains statistics about an rm operation
+type rmStats struct {
+	Deleted    int      `json:"deleted"`
+	Failed     int      `json:"failed"`
+	FailedKeys []string `json:"failed_keys,omitempty"`
+}
+
+// deleteLimits reads the deletion safety flags of a command
+func deleteLimits(cmd *cobra.Command) (sync.DeleteLimits, error) {
+	maxDelete, _ := cmd.Flags().GetInt("max-delete")
+	maxDeletePercent, _ := cmd.Flags().GetFloat64("max-delete-percent")
+	force, _ := cmd.Flags().GetBool("force")
+
+	if maxDelete < 0 {
+		return sync.DeleteLimits{}, fmt.Errorf("--max-delete must not be negative")
+	}
+	if maxDeletePercent < 0 || maxDeletePercent > 100 {
+		return sync.DeleteLimits{}, fmt.Errorf("--max-delete-percent must be between 0 and 100")
+	}
+
+	return sync.DeleteLimits{
+		MaxDelete:        maxDelete,
+		MaxDeletePercent: maxDeletePercent,
+		Force:            force,
+	}, nil
+}
+
+// downloadOptions reads the download tuning flags of a command
+func downloadOptions(cmd *cobra.Command) (s3client.DownloadOptions, error) {
+	threads, _ := cmd.Flags().GetInt("download-threads")
+	chunkSizeStr, _ := cmd.Flags().GetString("chunk-size")
+	owner, _ := cmd.Flags().GetBool("preserve-owner")
+
+	chunkSize, err := config.ParseSize(chunkSizeStr)
+	if err != nil {
+		return s3client.DownloadOptions{}, fmt.Errorf("invalid --chunk-size: %w", err)
+	}
+	if chunkSize < 1024*1024 {
+		return s3client.DownloadOptions{}, fmt.Errorf("--chunk-size must be at least 1MiB")
+	}
+
+	return s3client.DownloadOptions{
+		Threads:   threads,
+		ChunkSize: chunkSize,
+		Owner:     owner,
+	}, nil
+}
+
+// retryPolicy reads the retry flags of a command. Retries are reported on
+// stderr, where they never interleave with records written to stdout.
+func retryPolicy(cmd *cobra.Command) (storage.RetryPolicy, error) {
+	retries, _ := cmd.Flags().GetInt("retries")
+	delayStr, _ := cmd.Flags().GetString("retry-delay")
+
+	if retries < 0 {
+		return storage.RetryPolicy{}, fmt.Errorf("--retries must not be negative")
+	}
+	delay, err := config.ParseDuration(delayStr)
+	if err != nil {
+		return storage.RetryPolicy{}, fmt.Errorf("invalid --retry-delay: %w", err)
+	}
+
+	var mu gosync.Mutex
+	w := cmd.ErrOrStderr()
+	policy := storage.DefaultRetryPolicy
+	policy.Retries = retries
+	policy.BaseDelay = delay
+	policy.OnRetry = func(op string, key string, attempt int, err error, wait time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		if key != "" {
+			op += " " + key
+		}
+		fmt.Fprintf(w, "Retrying %s in %s (attempt %d of %d): %v\n", op, wait.Round(time.Millisecond), attempt+1, retries+1, err)
+	}
+	return policy, nil
+}
+
+// remoteClient loads the configuration and creates the S3 client used by
+// the commands managing the bucket such as trash and uploads, retrying
+// failed operations as set by the retry flags
+func remoteClient(cmd *cobra.Command, operation string) (*config.Config, storage.Storage, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	if cfg.BucketName == "" {
+		return nil, nil, fmt.Errorf("S3_BUCKET is required for %s operations (set via /etc/config-nv/S3_BUCKET file or environment variable)", operation)
+	}
+
+	client, err := newClient(cmd, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	retry, err := retryPolicy(cmd)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cfg, storage.WithRetry(client, retry), nil
+}
+
+// newClient creates the S3 client of a command, logging to the command
+// output with transfers limited by --bwlimit or the BWLIMIT setting
+func newClient(cmd *cobra.Command, cfg *config.Config) (*s3client.Client, error) {
+	limit := cfg.BandwidthLimit
+	source := "BWLIMIT"
+	if cmd.Flags().Changed("bwlimit") {
+		limit, _ = cmd.Flags().GetString("bwlimit")
+		source = "--bwlimit"
+	}
+	schedule, err := config.ParseBandwidthSchedule(limit)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", source, err)
+	}
+
+	client, err := s3client.New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+	client.SetLogOutput(out.info())
+
+	// One limiter per direction is shared by all transfers of the command
+	if len(schedule) > 0 {
+		client.SetBandwidthLimit(
+			storage.NewLimiter(func(t time.Time) int64 { return schedule.At(t).Upload }),
+			storage.NewLimiter(func(t time.Time) int64 { return schedule.At(t).Download }),
+		)
+	}
+	return client, nil
+}
+
+// retryKeys reads the keys listed in the --retry-from file, nil if the flag
+// is not set
+func retryKeys(cmd *cobra.Command) ([]string, error) {
+	retryFrom, _ := cmd.Flags().GetString("retry-from")
+	if retryFrom == "" {
+		return nil, nil
+	}
+	return sync.ReadKeys(retryFrom)
+}
+
+// printKeys lists keys under a summary line
+func printKeys(keys []string) {
+	for _, key := range keys {
+		infof("    %s\n", key)
+	}
+}
+
+// summaryTitle prints the title of a command summary, err is the error that
+// interrupted the command
+func summaryTitle(dryRun bool, err error) {
+	switch {
+	case err != nil:
+		infoln("Summary (interrupted):")
+	case dryRun:
+		infoln("Summary (dry run):")
+	default:
+		infoln("Summary:")
+	}
+}
+
+// reportFailures writes the keys that failed to the --failed-out file so
+// they can be retried with --retry-from. It returns runErr, the error that
+// interrupted the command, or an error if any key failed so that the
+// command exits with a non-zero status.
+func reportFailures(cmd *cobra.Command, keys []string, runErr error) error {
+	if failedOut, _ := cmd.Flags().GetString("failed-out"); failedOut != "" {
+		if err := sync.WriteKeys(failedOut, keys); err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			infof("\nFailed keys written to %s, run again with --retry-from %s to retry them\n", failedOut, failedOut)
+		}
+	}
+	if runErr != nil {
+		return runErr
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	// Every failure was already reported, usage would only bury them
+	cmd.SilenceUsage = true
+	return fmt.Errorf("%d files failed", len(keys))
+}
+
 // formatSize formats bytes as human-readable string
 func formatSize(bytes int64) string {
 	const (
@@ -414,25 +1277,99 @@ func init() {
 	nvCmd.AddCommand(lsCmd)
 	nvCmd.AddCommand(pullCmd)
 	nvCmd.AddCommand(pushCmd)
+	nvCmd.AddCommand(syncCmd)
+	nvCmd.AddCommand(applyCmd)
 	nvCmd.AddCommand(rmCmd)
 
+	// Flags for all nv commands
+	nvCmd.PersistentFlags().Int("retries", storage.DefaultRetryPolicy.Retries, "Retry operations failing with throttling, server or network errors this many times (0 = no retries)")
+	nvCmd.PersistentFlags().String("retry-delay", storage.DefaultRetryPolicy.BaseDelay.String(), "Delay before the first retry, doubled with random jitter on every further retry")
+	nvCmd.PersistentFlags().String("bwlimit", "", "Limit the combined transfer rate, e.g. 50MiB/s, 10MiB/s:100MiB/s for separate upload:download limits, or a timetable such as \"08:00,10MiB/s 18:00,off\" (default from BWLIMIT)")
+
 	// Flags for ls command
 	lsCmd.Flags().String("prefix", "", "Filter by prefix/directory")
 	lsCmd.Flags().Bool("recursive", true, "List recursively")
+	lsCmd.Flags().StringSlice("include", []string{}, "Only list keys matching gitignore-style patterns relative to --prefix (can be repeated)")
+	lsCmd.Flags().StringSlice("exclude", []string{}, "Exclude gitignore-style patterns relative to --prefix (can be repeated)")
 
 	// Flags for pull command
 	pullCmd.Flags().String("prefix", "", "Pull only specific prefix")
 	pullCmd.Flags().Bool("dry-run", false, "Preview without executing")
 	pullCmd.Flags().Bool("delete", false, "Delete local files not in remote")
+	pullCmd.Flags().StringSlice("include", []string{}, "Only pull keys matching gitignore-style patterns relative to --prefix (can be repeated)")
+	pullCmd.Flags().StringSlice("exclude", []string{}, "Exclude gitignore-style patterns relative to --prefix (can be repeated)")
+	pullCmd.Flags().Int("parallel", 4, "Number of files to download concurrently")
+	pullCmd.Flags().Bool("checksum", false, "Compare file content (MD5/ETag) instead of modification times")
+	pullCmd.Flags().Int("download-threads", 8, "Concurrent range requests per large file")
+	pullCmd.Flags().String("chunk-size", "64MiB", "Range request size, larger files are downloaded in parallel chunks")
+	pullCmd.Flags().Bool("preserve-owner", false, "Restore the owner and group recorded at upload (usually requires root)")
+	pullCmd.Flags().Int("max-delete", 0, "Abort before deleting if more than this many local files would be deleted (0 = no limit)")
+	pullCmd.Flags().Float64("max-delete-percent", 0, "Abort before deleting if more than this percentage of local files would be deleted (0 = no limit)")
+	pullCmd.Flags().Bool("force", false, "Allow deleting every file when the source side is empty")
+	pullCmd.Flags().Bool("trash", false, "Move deleted files to the trash instead of removing them permanently")
+	pullCmd.Flags().String("plan-out", "", "Write the planned actions to this file for \"nv apply\" instead of pulling")
+	pullCmd.Flags().String("failed-out", "", "Write the keys that failed to this file, one per line")
+	pullCmd.Flags().String("retry-from", "", "Only pull the keys listed in this file, e.g. written by --failed-out")
 
 	// Flags for push command
 	pushCmd.Flags().String("prefix", "", "Push only specific prefix")
 	pushCmd.Flags().Bool("dry-run", false, "Preview without executing")
 	pushCmd.Flags().Bool("delete", false, "Delete remote files not in local")
-	pushCmd.Flags().StringSlice("exclude", []string{}, "Exclude patterns (can be repeated)")
+	pushCmd.Flags().StringSlice("include", []string{}, "Only push files matching gitignore-style patterns relative to --prefix (can be repeated)")
+	pushCmd.Flags().StringSlice("exclude", []string{}, "Exclude gitignore-style patterns relative to --prefix (can be repeated)")
+	pushCmd.Flags().Int("parallel", 4, "Number of files to upload concurrently")
+	pushCmd.Flags().Bool("checksum", false, "Compare file content (MD5/ETag) instead of modification times")
+	pushCmd.Flags().Int("max-delete", 0, "Abort before deleting if more than this many remote files would be deleted (0 = no limit)")
+	pushCmd.Flags().Float64("max-delete-percent", 0, "Abort before deleting if more than this percentage of remote files would be deleted (0 = no limit)")
+	pushCmd.Flags().Bool("force", false, "Allow deleting every file when the source side is empty")
+	pushCmd.Flags().Bool("trash", false, "Move deleted files to the trash instead of removing them permanently")
+	pushCmd.Flags().String("plan-out", "", "Write the planned actions to this file for \"nv apply\" instead of pushing")
+	pushCmd.Flags().String("failed-out", "", "Write the keys that failed to this file, one per line")
+	pushCmd.Flags().String("retry-from", "", "Only push the keys listed in this file, e.g. written by --failed-out")
+	pushCmd.Flags().Bool("watch", false, "Keep running and push files as they change until interrupted")
+	pushCmd.Flags().String("debounce", "2s", "With --watch, push a file once it has stayed unchanged this long")
+	pushCmd.Flags().String("status-interval", "1m", "With --watch, print a status line this often (0 = never)")
+	pushCmd.Flags().String("abort-stale-uploads", "0", "Abort incomplete uploads of pushed files started longer ago than this (e.g. 24h, 0 = keep them)")
+	pushCmd.Flags().String("symlinks", "skip", "How to push symbolic links: skip, follow or preserve")
+	pushCmd.Flags().Bool("keep-empty-dirs", false, "Push empty directories as \"dir/\" marker objects that pull recreates")
+
+	// Flags for sync command
+	syncCmd.Flags().String("prefix", "", "Sync only specific prefix")
+	syncCmd.Flags().Bool("dry-run", false, "Preview without executing")
+	syncCmd.Flags().StringSlice("include", []string{}, "Only sync files matching gitignore-style patterns relative to --prefix (can be repeated)")
+	syncCmd.Flags().StringSlice("exclude", []string{}, "Exclude gitignore-style patterns relative to --prefix (can be repeated)")
+	syncCmd.Flags().Int("parallel", 4, "Number of files to transfer concurrently")
+	syncCmd.Flags().Bool("checksum", false, "Compare file content (MD5/ETag) to detect changes when only modification times differ")
+	syncCmd.Flags().String("prefer", "both", "Conflict policy: local, remote, newer or both")
+	syncCmd.Flags().String("symlinks", "skip", "How to sync local symbolic links: skip, follow or preserve")
+	syncCmd.Flags().Int("download-threads", 8, "Concurrent range requests per large file")
+	syncCmd.Flags().String("chunk-size", "64MiB", "Range request size, larger files are downloaded in parallel chunks")
+	syncCmd.Flags().Bool("preserve-owner", false, "Restore the owner and group recorded at upload (usually requires root)")
+	syncCmd.Flags().Int("max-delete", 0, "Abort before deleting if more than this many files on either side would be deleted (0 = no limit)")
+	syncCmd.Flags().Float64("max-delete-percent", 0, "Abort before deleting if more than this percentage of files on either side would be deleted (0 = no limit)")
+	syncCmd.Flags().Bool("force", false, "Allow deleting every file when the source side is empty")
+	syncCmd.Flags().Bool("trash", false, "Move deleted files to the trash instead of removing them permanently")
+	syncCmd.Flags().String("failed-out", "", "Write the keys that failed to this file, one per line")
+	syncCmd.Flags().String("retry-from", "", "Only sync the keys listed in this file, e.g. written by --failed-out")
+
+	// Flags for apply command
+	applyCmd.Flags().Int("parallel", 4, "Number of files to transfer concurrently")
+	applyCmd.Flags().Int("download-threads", 8, "Concurrent range requests per large file")
+	applyCmd.Flags().String("chunk-size", "64MiB", "Range request size, larger files are downloaded in parallel chunks")
+	applyCmd.Flags().Bool("preserve-owner", false, "Restore the owner and group recorded at upload (usually requires root)")
+	applyCmd.Flags().Bool("trash", false, "Move deleted files to the trash instead of removing them permanently")
+	applyCmd.Flags().String("failed-out", "", "Write the keys that failed to this file, one per line")
 
 	// Flags for rm command
 	rmCmd.Flags().String("prefix", "", "Remove all files under this prefix")
 	rmCmd.Flags().Bool("dry-run", false, "Preview without executing")
 	rmCmd.Flags().Bool("recursive", true, "Remove recursively when using --prefix")
+	rmCmd.Flags().StringSlice("include", []string{}, "Only remove keys matching gitignore-style patterns relative to --prefix (can be repeated)")
+	rmCmd.Flags().StringSlice("exclude", []string{}, "Never remove keys matching gitignore-style patterns relative to --prefix (can be repeated)")
+	rmCmd.Flags().Bool("force", false, "Allow an empty --prefix to remove every object in the bucket")
+	rmCmd.Flags().Int("max-delete", 0, "With --prefix, abort before deleting if more than this many files would be deleted (0 = no limit)")
+	rmCmd.Flags().Float64("max-delete-percent", 0, "With --prefix, abort before deleting if more than this percentage of the files under the prefix would be deleted (0 = no limit)")
+	rmCmd.Flags().Bool("trash", false, "Move deleted files to the trash instead of removing them permanently")
+	rmCmd.Flags().String("failed-out", "", "Write the keys that failed to this file, one per line")
+	rmCmd.Flags().String("retry-from", "", "Remove the keys listed in this file, e.g. written by --failed-out")
 }
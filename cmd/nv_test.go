@@ -0,0 +1,907 @@
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/vngcloud/aiplatform-util/pkg/s3test"
+)
+
+// past is a modification time old enough to never look newer than a transfer
+var past = time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+
+func TestLsListsObjects(t *testing.T) {
+	e := newEnv(t)
+	e.putRemote("models/a.pth", "weights", past)
+	e.putRemote("models/b/c.pth", "more weights", past)
+	e.putRemote("data/train.csv", "x,y", past)
+
+	output := e.mustRun("nv", "ls", "--prefix", "models/")
+	assertContains(t, output, "models/a.pth", "models/b/c.pth", "Total: 2 objects")
+	if strings.Contains(output, "data/train.csv") {
+		t.Errorf("ls listed an object outside the prefix:\n%s", output)
+	}
+
+	output = e.mustRun("nv", "ls", "--prefix", "models/", "--recursive=false")
+	assertContains(t, output, "models/a.pth", "models/b/", "<DIR>")
+}
+
+func TestLsListsBucketsWithoutBucket(t *testing.T) {
+	e := newEnv(t)
+	t.Setenv("S3_BUCKET", "")
+
+	output := e.mustRun("nv", "ls")
+	assertContains(t, output, "Available buckets", s3test.DefaultBucket)
+}
+
+func TestLsJSONOutput(t *testing.T) {
+	e := newEnv(t)
+	e.putRemote("a.txt", "a", past)
+	e.putRemote("b.txt", "bb", past)
+
+	output := e.mustRun("nv", "ls", "-o", "json")
+
+	var records []objectRecord
+	if err := json.Unmarshal([]byte(output), &records); err != nil {
+		t.Fatalf("invalid json output: %v\n%s", err, output)
+	}
+	if len(records) != 2 || records[0].Key != "a.txt" || records[1].Size != 2 {
+		t.Errorf("unexpected records: %+v", records)
+	}
+}
+
+func TestPushCSVOutputEndsWithStats(t *testing.T) {
+	e := newEnv(t)
+	e.writeLocal("a.txt", "alpha", past)
+	e.writeLocal("b.txt", "beta", past)
+
+	output := e.mustRun("nv", "push", "-o", "csv")
+
+	// The stats follow the file rows as a table of their own
+	reader := csv.NewReader(strings.NewReader(output))
+	reader.FieldsPerRecord = -1
+	rows, err := reader.ReadAll()
+	if err != nil {
+		t.Fatalf("invalid csv output: %v\n%s", err, output)
+	}
+	if len(rows) != 5 {
+		t.Fatalf("got %d rows, want a file table and a stats table:\n%s", len(rows), output)
+	}
+	if rows[0][0] != "action" || rows[1][1] != "a.txt" || rows[2][1] != "b.txt" {
+		t.Errorf("unexpected file rows:\n%s", output)
+	}
+	header, stats := rows[3], rows[4]
+	want := []string{"command", "dry_run", "uploaded", "skipped", "deleted", "failed"}
+	if !slices.Equal(header, want) {
+		t.Errorf("stats header = %q, want %q", header, want)
+	}
+	if !slices.Equal(stats, []string{"push", "false", "2", "0", "0", "0"}) {
+		t.Errorf("stats row = %q", stats)
+	}
+	if !strings.Contains(output, "b.txt,new file,4,done,\n\ncommand,") {
+		t.Errorf("stats table does not start after an empty line:\n%s", output)
+	}
+}
+
+func TestCSVOutputReportsErrors(t *testing.T) {
+	e := newEnv(t)
+
+	output, err := e.run("nv", "rm", "-o", "csv")
+	if err == nil {
+		t.Fatal("rm without keys succeeded")
+	}
+	rows, csvErr := csv.NewReader(strings.NewReader(output)).ReadAll()
+	if csvErr != nil || len(rows) != 2 || rows[0][0] != "error" || !strings.Contains(rows[1][0], "either provide file keys") {
+		t.Errorf("unexpected error output: %v\n%s", csvErr, output)
+	}
+}
+
+func TestPullDownloadsNewFiles(t *testing.T) {
+	e := newEnv(t)
+	e.putRemote("data/a.txt", "alpha", past)
+	e.putRemote("data/sub/b.txt", "beta", past)
+
+	output := e.mustRun("nv", "pull")
+	assertContains(t, output, "Downloading: data/a.txt (new file)", "Downloaded: 2 files")
+	if got := e.local("data/sub/b.txt"); got != "beta" {
+		t.Errorf("local content = %q, want %q", got, "beta")
+	}
+
+	// A second pull has nothing to do
+	output = e.mustRun("nv", "pull")
+	assertContains(t, output, "Downloaded: 0 files", "Skipped:    2 files")
+}
+
+func TestPullDryRunChangesNothing(t *testing.T) {
+	e := newEnv(t)
+	e.putRemote("a.txt", "alpha", past)
+
+	output := e.mustRun("nv", "pull", "--dry-run")
+	assertContains(t, output, "DRY RUN", "Downloading: a.txt")
+	if e.hasLocal("a.txt") {
+		t.Error("dry run downloaded a file")
+	}
+}
+
+func TestPullDeleteRemovesLocalFiles(t *testing.T) {
+	e := newEnv(t)
+	e.putRemote("keep.txt", "keep", past)
+	e.writeLocal("keep.txt", "keep", past)
+	e.writeLocal("stale.txt", "stale", past)
+
+	output := e.mustRun("nv", "pull", "--delete")
+	assertContains(t, output, "Deleting local: stale.txt")
+	if e.hasLocal("stale.txt") || !e.hasLocal("keep.txt") {
+		t.Error("pull --delete did not remove exactly the stale file")
+	}
+}
+
+func TestPullDeleteMergesListingWithLocalFiles(t *testing.T) {
+	e := newEnv(t)
+	for _, key := range []string{"a/x.txt", "a-b.txt", "a.txt", "data/x.txt"} {
+		e.putRemote(key, key, past)
+		e.writeLocal(key, key, past)
+	}
+	e.writeLocal("a/stale.txt", "stale", past)
+	e.writeLocal("a0.txt", "stale", past)
+
+	// The files of a link pushed by following it are listed after keys
+	// sorting between the link and its files
+	e.putRemote("latest/x.txt", "x", past)
+	e.putRemote("latest-run.txt", "run", past)
+	if err := os.Symlink("data", filepath.Join(e.cfg.MountPath, "latest")); err != nil {
+		t.Fatal(err)
+	}
+
+	output := e.mustRun("nv", "pull", "--delete")
+	assertContains(t, output, "Deleting local: a/stale.txt", "Deleting local: a0.txt", "Deleted:    2 files")
+	for _, key := range []string{"a/x.txt", "a-b.txt", "a.txt", "data/x.txt", "latest-run.txt"} {
+		if !e.hasLocal(key) {
+			t.Errorf("pull --delete removed %s", key)
+		}
+	}
+	if _, err := os.Readlink(filepath.Join(e.cfg.MountPath, "latest")); err != nil {
+		t.Errorf("pull --delete removed a link to a pushed directory: %v", err)
+	}
+}
+
+func TestPullDeleteReadsListingPages(t *testing.T) {
+	e := newEnv(t)
+	for i := range 1005 {
+		e.putRemote(fmt.Sprintf("data/%04d.txt", i), "x", past)
+	}
+	e.putRemote("z.txt", "z", past)
+	e.writeLocal("z.txt", "z", past)
+
+	output := e.mustRun("nv", "pull", "--delete", "--dry-run")
+	if strings.Contains(output, "Deleting local") {
+		t.Errorf("pull --delete deleted a file listed on a later page:\n%s", output)
+	}
+	assertContains(t, output, "data/1004.txt")
+}
+
+func TestPullRefusesMassDeletion(t *testing.T) {
+	e := newEnv(t)
+	for _, key := range []string{"a.txt", "b.txt", "c.txt"} {
+		e.writeLocal(key, key, past)
+	}
+
+	if _, err := e.run("nv", "pull", "--delete", "--max-delete", "2"); err == nil {
+		t.Fatal("pull deleting 3 files with --max-delete 2 succeeded")
+	}
+	if !e.hasLocal("a.txt") {
+		t.Error("aborted pull deleted a file")
+	}
+}
+
+func TestPullRetriesServerErrors(t *testing.T) {
+	e := newEnv(t)
+	e.putRemote("a.txt", "alpha", past)
+	e.server.Inject(s3test.Fault{Method: http.MethodGet, Key: "a.txt", Status: http.StatusServiceUnavailable, Times: 2})
+
+	output := e.mustRun("nv", "pull", "--retry-delay", "1ms")
+	assertContains(t, output, "Retrying download a.txt", "Downloaded: 1 files")
+	if got := e.local("a.txt"); got != "alpha" {
+		t.Errorf("local content = %q, want %q", got, "alpha")
+	}
+}
+
+func TestPullGivesUpAfterRetries(t *testing.T) {
+	e := newEnv(t)
+	e.putRemote("a.txt", "alpha", past)
+	e.server.Inject(s3test.Fault{Method: http.MethodGet, Key: "a.txt", Status: http.StatusServiceUnavailable, Times: 3})
+
+	output, err := e.run("nv", "pull", "--retries", "1", "--retry-delay", "1ms")
+	if err == nil {
+		t.Fatalf("pull succeeded after exhausting its retries:\n%s", output)
+	}
+	assertContains(t, output, "attempt 2 of 2", "Failed:     1 files")
+}
+
+func TestPullReportsFailedFiles(t *testing.T) {
+	e := newEnv(t)
+	e.putRemote("a.txt", "alpha", past)
+	e.putRemote("b.txt", "beta", past)
+	e.server.Inject(s3test.Fault{Key: "a.txt", Status: http.StatusForbidden, Times: 1})
+	failed := filepath.Join(t.TempDir(), "failed.txt")
+
+	// Access denied is permanent and must not be retried
+	output, err := e.run("nv", "pull", "--failed-out", failed)
+	if err == nil {
+		t.Fatalf("pull with a failed file exited successfully:\n%s", output)
+	}
+	assertContains(t, output, "Failed: ", "Downloaded: 1 files", "Failed:     1 files", "    a.txt")
+	if strings.Contains(output, "Retrying") {
+		t.Errorf("pull retried a permanent error:\n%s", output)
+	}
+	if e.hasLocal("a.txt") || e.local("b.txt") != "beta" {
+		t.Error("a failed download changed the wrong files")
+	}
+
+	e.putRemote("b.txt", "changed", time.Now().Add(time.Hour))
+	output = e.mustRun("nv", "pull", "--retry-from", failed)
+	assertContains(t, output, "Retrying 1 failed files", "Downloaded: 1 files")
+	if e.local("a.txt") != "alpha" || e.local("b.txt") != "beta" {
+		t.Error("pull --retry-from did not transfer exactly the failed files")
+	}
+}
+
+func TestPullResumesTruncatedDownload(t *testing.T) {
+	e := newEnv(t)
+	content := strings.Repeat("0123456789", 100_000)
+	e.putRemote("big.bin", content, past)
+	e.server.Inject(s3test.Fault{Method: http.MethodGet, Key: "big.bin", Truncate: 300_000, Times: 1})
+
+	output, _ := e.run("nv", "pull", "--download-threads", "1", "--retries", "0")
+	assertContains(t, output, "Failed:     1 files")
+	if e.hasLocal("big.bin") {
+		t.Fatal("truncated download left a file at the destination")
+	}
+
+	output = e.mustRun("nv", "pull", "--download-threads", "1")
+	assertContains(t, output, "Downloaded: 1 files")
+	if e.local("big.bin") != content {
+		t.Error("resumed download has the wrong content")
+	}
+}
+
+func TestPullRetriesTruncatedDownload(t *testing.T) {
+	e := newEnv(t)
+	content := strings.Repeat("0123456789", 100_000)
+	e.putRemote("big.bin", content, past)
+	e.server.Inject(s3test.Fault{Method: http.MethodGet, Key: "big.bin", Truncate: 300_000, Times: 1})
+
+	output := e.mustRun("nv", "pull", "--download-threads", "1", "--retry-delay", "1ms")
+	assertContains(t, output, "Retrying download big.bin", "Downloaded: 1 files")
+	if e.local("big.bin") != content {
+		t.Error("retried download has the wrong content")
+	}
+}
+
+func TestPullTimeoutPrintsPartialSummary(t *testing.T) {
+	e := newEnv(t)
+	e.putRemote("a.txt", "alpha", past)
+	e.putRemote("b.txt", "beta", past)
+	e.server.Inject(s3test.Fault{Method: http.MethodGet, Key: "b.txt", Delay: 5 * time.Second})
+
+	output, err := e.run("nv", "pull", "--parallel", "1", "--timeout", "500ms")
+	if err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+		t.Fatalf("pull --timeout returned %v:\n%s", err, output)
+	}
+	assertContains(t, output, "Summary (interrupted):", "Downloaded: 1 files")
+	if e.local("a.txt") != "alpha" || e.hasLocal("b.txt") {
+		t.Error("interrupted pull left the wrong files")
+	}
+
+	// The files downloaded before the interruption are not transferred again
+	e.server.ClearFaults()
+	output = e.mustRun("nv", "pull")
+	assertContains(t, output, "Downloaded: 1 files", "Skipped:    1 files")
+}
+
+func TestPullSlowResponsesKeepOutputOrder(t *testing.T) {
+	e := newEnv(t)
+	for _, key := range []string{"a.txt", "b.txt", "c.txt", "d.txt"} {
+		e.putRemote(key, key, past)
+	}
+	e.server.Inject(s3test.Fault{Method: http.MethodGet, Key: "a.txt", Delay: 200 * time.Millisecond})
+
+	output := e.mustRun("nv", "pull", "--parallel", "4")
+	first := strings.Index(output, "Downloading: a.txt")
+	last := strings.Index(output, "Downloading: d.txt")
+	if first < 0 || last < first {
+		t.Errorf("parallel pull reported files out of order:\n%s", output)
+	}
+	assertContains(t, output, "Downloaded: 4 files")
+}
+
+func TestPushUploadsFiles(t *testing.T) {
+	e := newEnv(t)
+	e.writeLocal("out/a.txt", "alpha", past)
+	e.writeLocal("out/b.txt", "beta", past)
+
+	output := e.mustRun("nv", "push", "--prefix", "out/")
+	assertContains(t, output, "Uploading: out/a.txt (new file)", "Uploaded:  2 files")
+	if got := e.remote("out/b.txt"); got != "beta" {
+		t.Errorf("remote content = %q, want %q", got, "beta")
+	}
+
+	output = e.mustRun("nv", "push", "--prefix", "out/")
+	assertContains(t, output, "Uploaded:  0 files")
+}
+
+func TestPushMultipartUpload(t *testing.T) {
+	e := newEnv(t)
+	content := strings.Repeat("x", 17*1024*1024)
+	e.writeLocal("big.bin", content, past)
+
+	e.mustRun("nv", "push")
+	if e.remote("big.bin") != content {
+		t.Fatal("multipart upload has the wrong content")
+	}
+
+	// Touching the file bypasses the sync state, so the multipart ETag
+	// must be reproduced locally to skip it
+	e.writeLocal("big.bin", content, time.Now().Add(time.Hour))
+	output := e.mustRun("nv", "push", "--checksum")
+	assertContains(t, output, "Uploaded:  0 files")
+
+	// Content of the same size that differs is uploaded again
+	changed := "y" + content[1:]
+	e.writeLocal("big.bin", changed, time.Now().Add(2*time.Hour))
+	output = e.mustRun("nv", "push", "--checksum")
+	assertContains(t, output, "Uploaded:  1 files")
+	if e.remote("big.bin") != changed {
+		t.Error("changed content was not uploaded")
+	}
+}
+
+func TestPushChecksumVerifiesMultipartUploadsWithoutPartSize(t *testing.T) {
+	e := newEnv(t)
+	content := strings.Repeat("x", 20*1024*1024)
+	e.writeLocal("big.bin", content, time.Now())
+
+	// Uploaded by the SDK with its own part size, without recording it
+	client, err := minio.New(strings.TrimPrefix(e.cfg.Endpoint, "http://"), &minio.Options{
+		Creds: credentials.NewStaticV4(e.cfg.AccessKeyID, e.cfg.SecretAccessKey, ""),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := client.PutObject(context.Background(), e.cfg.BucketName, "big.bin", strings.NewReader(content), int64(len(content)), minio.PutObjectOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(info.ETag, "-2") {
+		t.Fatalf("SDK upload ETag = %s, want a multipart ETag of 2 parts", info.ETag)
+	}
+
+	output := e.mustRun("nv", "push", "--checksum")
+	assertContains(t, output, "Uploaded:  0 files")
+}
+
+func TestPushTimeoutAbortsMultipartUpload(t *testing.T) {
+	e := newEnv(t)
+	e.writeLocal("big.bin", strings.Repeat("x", 17*1024*1024), past)
+	e.server.Inject(s3test.Fault{Method: http.MethodPut, Key: "big.bin", Delay: time.Second})
+
+	output, err := e.run("nv", "push", "--timeout", "300ms")
+	if err == nil {
+		t.Fatalf("push --timeout succeeded:\n%s", output)
+	}
+	assertContains(t, output, "Summary (interrupted):")
+	if e.hasRemote("big.bin") {
+		t.Error("interrupted upload created the object")
+	}
+	if uploads := e.server.UploadIDs(e.cfg.BucketName); len(uploads) != 0 {
+		t.Errorf("interrupted upload left %d incomplete multipart uploads", len(uploads))
+	}
+}
+
+func TestPushDeleteRemovesRemoteFiles(t *testing.T) {
+	e := newEnv(t)
+	e.writeLocal("keep.txt", "keep", past)
+	e.putRemote("stale.txt", "stale", past)
+
+	output := e.mustRun("nv", "push", "--delete")
+	assertContains(t, output, "Deleting remote: stale.txt")
+	if e.hasRemote("stale.txt") || !e.hasRemote("keep.txt") {
+		t.Error("push --delete did not remove exactly the stale object")
+	}
+}
+
+func TestPushDeleteMergesListingWithLocalFiles(t *testing.T) {
+	e := newEnv(t)
+	for _, key := range []string{"a/x.txt", "a-b.txt", "a.txt"} {
+		e.putRemote(key, key, past)
+		e.writeLocal(key, key, past)
+	}
+	e.writeLocal("a/new.txt", "new", past)
+	e.putRemote("a/stale.txt", "stale", past)
+	e.putRemote("a0.txt", "stale", past)
+
+	output := e.mustRun("nv", "push", "--delete")
+	assertContains(t, output, "Deleting remote: a/stale.txt", "Deleting remote: a0.txt", "Uploaded:  1 files")
+	for _, key := range []string{"a/x.txt", "a/new.txt", "a-b.txt", "a.txt"} {
+		if !e.hasRemote(key) {
+			t.Errorf("push --delete removed %s", key)
+		}
+	}
+	if e.hasRemote("a/stale.txt") || e.hasRemote("a0.txt") {
+		t.Error("push --delete kept a stale object")
+	}
+}
+
+func TestPushRetriesServerErrors(t *testing.T) {
+	e := newEnv(t)
+	e.writeLocal("a.txt", "alpha", past)
+	e.server.Inject(s3test.Fault{Method: http.MethodPut, Key: "a.txt", Status: http.StatusInternalServerError, Times: 1})
+
+	output := e.mustRun("nv", "push", "--retry-delay", "1ms")
+	assertContains(t, output, "Uploaded:  1 files")
+	if e.remote("a.txt") != "alpha" {
+		t.Error("retried upload has the wrong content")
+	}
+}
+
+func TestPushWatchUploadsChanges(t *testing.T) {
+	e := newEnv(t)
+	e.writeLocal("a.txt", "alpha", past)
+	e.writeLocal("old/c.txt", "gamma", past)
+
+	done := make(chan error)
+	var output string
+	go func() {
+		var err error
+		output, err = e.run("nv", "push", "--watch", "--delete", "--exclude", "*.tmp", "--debounce", "100ms", "--timeout", "3s")
+		done <- err
+	}()
+
+	// Everything is pushed when the watch starts
+	waitFor(t, "initial push", func() bool { return e.hasRemote("a.txt") && e.hasRemote("old/c.txt") })
+
+	e.writeLocal("a.txt", "alpha, edited", time.Now())
+	e.writeLocal("new/b.txt", "beta", time.Now())
+	e.writeLocal("new/scratch.tmp", "scratch", time.Now())
+	if err := os.RemoveAll(filepath.Join(e.cfg.MountPath, "old")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "changes to be pushed", func() bool {
+		data, _ := e.server.GetObject(e.cfg.BucketName, "a.txt")
+		return string(data) == "alpha, edited" && e.hasRemote("new/b.txt") && !e.hasRemote("old/c.txt")
+	})
+
+	if err := <-done; err != nil {
+		t.Fatalf("push --watch failed: %v\n%s", err, output)
+	}
+	assertContains(t, output, "Watching", "Summary:", "Uploaded:   4 files", "Deleted:    1 files")
+	if e.hasRemote("new/scratch.tmp") {
+		t.Error("push --watch uploaded an excluded file")
+	}
+}
+
+func TestPushWatchReportsPendingFiles(t *testing.T) {
+	e := newEnv(t)
+	e.writeLocal("a.txt", "alpha", past)
+
+	done := make(chan error)
+	var output string
+	go func() {
+		var err error
+		output, err = e.run("nv", "push", "--watch", "--debounce", "1m", "--timeout", "1s")
+		done <- err
+	}()
+
+	waitFor(t, "initial push", func() bool { return e.hasRemote("a.txt") })
+	e.writeLocal("b.txt", "beta", time.Now())
+
+	// The watch stops before b.txt was stable long enough to be pushed
+	if err := <-done; err == nil {
+		t.Fatalf("push --watch succeeded with a file left to push:\n%s", output)
+	}
+	assertContains(t, output, "Not pushed: 1 files", "    b.txt")
+	if e.hasRemote("b.txt") {
+		t.Error("push --watch uploaded a file before --debounce elapsed")
+	}
+}
+
+func TestPushPullPreservesModeAndModTime(t *testing.T) {
+	e := newEnv(t)
+	e.writeLocal("bin/run.sh", "#!/bin/sh\necho hi\n", past)
+	script := filepath.Join(e.cfg.MountPath, "bin", "run.sh")
+	if err := os.Chmod(script, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	e.mustRun("nv", "push")
+	meta, _ := e.server.Metadata(e.cfg.BucketName, "bin/run.sh")
+	if meta.Get("X-Amz-Meta-Mode") != "33261" || meta.Get("X-Amz-Meta-Mtime") == "" {
+		t.Fatalf("pushed object metadata = %v, want mode and mtime", meta)
+	}
+
+	if err := os.RemoveAll(e.cfg.MountPath); err != nil {
+		t.Fatal(err)
+	}
+	e.mustRun("nv", "pull")
+
+	info, err := os.Stat(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("pulled mode = %v, want -rwxr-xr-x", info.Mode().Perm())
+	}
+	if !info.ModTime().Equal(past) {
+		t.Errorf("pulled modification time = %v, want %v", info.ModTime(), past)
+	}
+}
+
+func TestPullComparesRecordedModTime(t *testing.T) {
+	e := newEnv(t)
+	e.writeLocal("a.txt", "alpha", past)
+	e.mustRun("nv", "push")
+
+	// Without the sync state, the local file is compared against the
+	// modification time recorded at upload rather than the upload time
+	if err := os.RemoveAll(filepath.Join(e.cfg.MountPath, ".aiplatform")); err != nil {
+		t.Fatal(err)
+	}
+	e.writeLocal("a.txt", "alphb", past.Add(time.Hour))
+
+	output := e.mustRun("nv", "pull")
+	assertContains(t, output, "Downloaded: 0 files")
+	if e.local("a.txt") != "alphb" {
+		t.Error("pull replaced a local file newer than the uploaded one")
+	}
+}
+
+func TestPushComparesListedMetadata(t *testing.T) {
+	e := newEnv(t)
+	e.server.ListMetadata(true)
+	e.writeLocal("a.txt", "alpha", past)
+	e.writeLocal("b.txt", "beta", past)
+	e.mustRun("nv", "push")
+
+	// Without the sync state, the recorded modification times come with
+	// the listing instead of a HEAD request per object
+	if err := os.RemoveAll(filepath.Join(e.cfg.MountPath, ".aiplatform")); err != nil {
+		t.Fatal(err)
+	}
+	e.server.Inject(s3test.Fault{Method: http.MethodHead, Status: http.StatusForbidden})
+
+	output := e.mustRun("nv", "push")
+	assertContains(t, output, "Uploaded:  0 files")
+	if strings.Contains(output, "Warning") {
+		t.Errorf("push read object metadata outside the listing:\n%s", output)
+	}
+}
+
+func TestPushReportsMetadataErrors(t *testing.T) {
+	e := newEnv(t)
+	e.writeLocal("a.txt", "alpha", past)
+	e.mustRun("nv", "push")
+
+	if err := os.RemoveAll(filepath.Join(e.cfg.MountPath, ".aiplatform")); err != nil {
+		t.Fatal(err)
+	}
+	e.server.Inject(s3test.Fault{Method: http.MethodHead, Status: http.StatusForbidden})
+
+	// The file cannot be compared, so it is uploaded again with a warning
+	output := e.mustRun("nv", "push")
+	assertContains(t, output, "Warning: failed to get metadata for a.txt", "Uploading: a.txt (metadata error)", "Uploaded:  1 files")
+}
+
+func TestPullWithoutRecordedAttributesUsesLastModified(t *testing.T) {
+	e := newEnv(t)
+	e.putRemote("a.txt", "alpha", past)
+
+	e.mustRun("nv", "pull")
+	info, err := os.Stat(filepath.Join(e.cfg.MountPath, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(past) {
+		t.Errorf("pulled modification time = %v, want %v", info.ModTime(), past)
+	}
+}
+
+func TestPushSkipsSymlinksByDefault(t *testing.T) {
+	e := newEnv(t)
+	e.writeLocal("a.txt", "alpha", past)
+	if err := os.Symlink("a.txt", filepath.Join(e.cfg.MountPath, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	output := e.mustRun("nv", "push")
+	assertContains(t, output, "Uploaded:  1 files", "skipping symbolic link link.txt")
+	if e.hasRemote("link.txt") {
+		t.Error("push uploaded a symbolic link without --symlinks")
+	}
+}
+
+func TestPushPreservesSymlinks(t *testing.T) {
+	e := newEnv(t)
+	e.writeLocal("data/x.txt", "x", past)
+	if err := os.Symlink("data", filepath.Join(e.cfg.MountPath, "latest")); err != nil {
+		t.Fatal(err)
+	}
+
+	e.mustRun("nv", "push", "--symlinks", "preserve")
+	meta, _ := e.server.Metadata(e.cfg.BucketName, "latest")
+	if meta.Get("X-Amz-Meta-Symlink-Target") != "data" {
+		t.Fatalf("pushed link metadata = %v, want its target", meta)
+	}
+	if e.hasRemote("latest/x.txt") {
+		t.Error("push --symlinks preserve followed a link")
+	}
+
+	output := e.mustRun("nv", "push", "--symlinks", "preserve")
+	assertContains(t, output, "Uploaded:  0 files")
+
+	if err := os.RemoveAll(e.cfg.MountPath); err != nil {
+		t.Fatal(err)
+	}
+	e.mustRun("nv", "pull")
+	target, err := os.Readlink(filepath.Join(e.cfg.MountPath, "latest"))
+	if err != nil || target != "data" {
+		t.Fatalf("pulled link target = %q, %v, want data", target, err)
+	}
+	if e.local("latest/x.txt") != "x" {
+		t.Error("pulled link does not point to the pulled directory")
+	}
+
+	output = e.mustRun("nv", "pull")
+	assertContains(t, output, "Downloaded: 0 files")
+}
+
+func TestPushFollowsSymlinksWithoutCycles(t *testing.T) {
+	e := newEnv(t)
+	e.writeLocal("data/x.txt", "x", past)
+	if err := os.Symlink("data", filepath.Join(e.cfg.MountPath, "alias")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("..", filepath.Join(e.cfg.MountPath, "data", "loop")); err != nil {
+		t.Fatal(err)
+	}
+
+	output := e.mustRun("nv", "push", "--symlinks", "follow")
+	assertContains(t, output, "Uploaded:  2 files", "skipping symbolic link data/loop: it points to a directory containing it")
+	if e.remote("alias/x.txt") != "x" {
+		t.Error("push --symlinks follow did not push the files of a linked directory")
+	}
+}
+
+func TestPushKeepsEmptyDirectories(t *testing.T) {
+	e := newEnv(t)
+	e.writeLocal("a.txt", "alpha", past)
+	if err := os.MkdirAll(filepath.Join(e.cfg.MountPath, "empty", "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	e.mustRun("nv", "push", "--keep-empty-dirs")
+	if !e.hasRemote("empty/sub/") || e.hasRemote("empty/") {
+		t.Fatalf("remote keys = %v, want a marker for empty/sub only", e.server.Keys(e.cfg.BucketName))
+	}
+
+	if err := os.RemoveAll(e.cfg.MountPath); err != nil {
+		t.Fatal(err)
+	}
+	e.mustRun("nv", "pull")
+	if info, err := os.Stat(filepath.Join(e.cfg.MountPath, "empty", "sub")); err != nil || !info.IsDir() {
+		t.Fatalf("pull did not recreate the empty directory: %v", err)
+	}
+
+	// The marker is removed once the directory has files
+	e.writeLocal("empty/sub/b.txt", "beta", past)
+	e.mustRun("nv", "push", "--keep-empty-dirs", "--delete")
+	if e.hasRemote("empty/sub/") {
+		t.Error("push --keep-empty-dirs --delete kept the marker of a directory that is no longer empty")
+	}
+}
+
+func TestSyncReconcilesBothSides(t *testing.T) {
+	e := newEnv(t)
+	e.putRemote("remote.txt", "from remote", past)
+	e.writeLocal("local.txt", "from local", past)
+
+	output := e.mustRun("nv", "sync")
+	assertContains(t, output, "Downloading: remote.txt", "Uploading: local.txt")
+	if e.local("remote.txt") != "from remote" || e.remote("local.txt") != "from local" {
+		t.Fatal("sync did not copy files both ways")
+	}
+
+	// An object removed since the last sync is deleted locally
+	e.mustRun("nv", "rm", "remote.txt")
+	output = e.mustRun("nv", "sync")
+	assertContains(t, output, "Deleting local: remote.txt")
+	if e.hasLocal("remote.txt") {
+		t.Error("sync kept a file deleted remotely")
+	}
+}
+
+func TestSyncKeepsBothVersionsOfConflicts(t *testing.T) {
+	e := newEnv(t)
+	e.putRemote("a.txt", "v1", past)
+	e.mustRun("nv", "sync")
+
+	e.writeLocal("a.txt", "local edit", time.Now())
+	e.putRemote("a.txt", "remote edit!", time.Now())
+
+	output := e.mustRun("nv", "sync")
+	assertContains(t, output, "Conflicts:      1 files")
+	if e.local("a.txt") != "remote edit!" {
+		t.Errorf("local file = %q, want the remote version", e.local("a.txt"))
+	}
+
+	// The local version is kept next to it under a conflict name
+	var conflict string
+	for _, key := range e.server.Keys(e.cfg.BucketName) {
+		if strings.Contains(key, "conflict") {
+			conflict = key
+		}
+	}
+	if conflict == "" || e.remote(conflict) != "local edit" {
+		t.Errorf("local version of the conflict was not kept, keys: %v", e.server.Keys(e.cfg.BucketName))
+	}
+}
+
+func TestPlanAndApply(t *testing.T) {
+	e := newEnv(t)
+	e.writeLocal("a.txt", "alpha", past)
+	e.writeLocal("b.txt", "beta", past)
+	plan := filepath.Join(t.TempDir(), "plan.json")
+
+	output := e.mustRun("nv", "push", "--plan-out", plan)
+	assertContains(t, output, "Plan with 2 actions")
+	if e.hasRemote("a.txt") {
+		t.Fatal("writing a plan uploaded a file")
+	}
+
+	// Files changed since the plan was made are refused
+	e.writeLocal("b.txt", "changed", past)
+
+	output = e.mustRun("nv", "apply", plan)
+	assertContains(t, output, "Uploaded:       1 files", "Refused:        1 files")
+	if !e.hasRemote("a.txt") || e.hasRemote("b.txt") {
+		t.Error("apply did not perform exactly the unchanged steps")
+	}
+}
+
+func TestRmRemovesObjects(t *testing.T) {
+	e := newEnv(t)
+	e.putRemote("tmp/a.txt", "a", past)
+	e.putRemote("tmp/b.log", "b", past)
+	e.putRemote("keep.txt", "k", past)
+
+	output := e.mustRun("nv", "rm", "--prefix", "tmp/", "--include", "*.txt", "--dry-run")
+	assertContains(t, output, "would be deleted")
+	if !e.hasRemote("tmp/a.txt") {
+		t.Fatal("rm --dry-run deleted an object")
+	}
+
+	e.mustRun("nv", "rm", "--prefix", "tmp/", "--include", "*.txt")
+	if e.hasRemote("tmp/a.txt") || !e.hasRemote("tmp/b.log") || !e.hasRemote("keep.txt") {
+		t.Errorf("rm removed the wrong objects, keys: %v", e.server.Keys(e.cfg.BucketName))
+	}
+}
+
+func TestRmPrefixChecksDeleteLimits(t *testing.T) {
+	e := newEnv(t)
+	e.putRemote("tmp/a.txt", "a", past)
+	e.putRemote("tmp/b.txt", "b", past)
+	e.putRemote("tmp/c.txt", "c", past)
+
+	output, err := e.run("nv", "rm", "--prefix", "tmp/", "--max-delete", "2")
+	if err == nil {
+		t.Fatalf("rm deleting 3 files with --max-delete 2 succeeded:\n%s", output)
+	}
+	assertContains(t, err.Error(), "exceeds --max-delete 2")
+	if len(e.server.Keys(e.cfg.BucketName)) != 3 {
+		t.Errorf("aborted rm deleted objects, keys: %v", e.server.Keys(e.cfg.BucketName))
+	}
+
+	e.mustRun("nv", "rm", "--prefix", "tmp/", "--max-delete", "3")
+	if len(e.server.Keys(e.cfg.BucketName)) != 0 {
+		t.Errorf("rm left objects, keys: %v", e.server.Keys(e.cfg.BucketName))
+	}
+}
+
+func TestRmPrefixFailedListingDeletesNothing(t *testing.T) {
+	e := newEnv(t)
+	// More objects than fit in one page of the listing
+	for i := range 1500 {
+		e.putRemote(fmt.Sprintf("tmp/%04d.txt", i), "x", past)
+	}
+	// The second page of every listing fails, after the first page of
+	// objects was already seen
+	e.server.Inject(s3test.Fault{Method: http.MethodGet, Key: "", After: 1, Status: http.StatusForbidden})
+
+	if output, err := e.run("nv", "rm", "--prefix", "tmp/"); err == nil {
+		t.Fatalf("rm with a failed listing succeeded:\n%s", output)
+	}
+	if keys := e.server.Keys(e.cfg.BucketName); len(keys) != 1500 {
+		t.Errorf("rm with a failed listing deleted %d objects", 1500-len(keys))
+	}
+}
+
+func TestRmJSONLOutputReportsFailures(t *testing.T) {
+	e := newEnv(t)
+	e.putRemote("a.txt", "a", past)
+	e.putRemote("b.txt", "b", past)
+	e.server.Inject(s3test.Fault{Method: http.MethodDelete, Key: "a.txt", Status: http.StatusForbidden})
+
+	output, err := e.run("nv", "rm", "a.txt", "b.txt", "-o", "jsonl")
+	if err == nil {
+		t.Fatalf("rm with a failed key exited successfully:\n%s", output)
+	}
+
+	statuses := make(map[string]string)
+	errors := 0
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		var record fileRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid jsonl line %q: %v", line, err)
+		}
+		if record.Type == "file" {
+			statuses[record.Key] = record.Status
+		}
+		if record.Type == "error" {
+			errors++
+		}
+	}
+	if statuses["a.txt"] != statusFailed || statuses["b.txt"] != statusDone {
+		t.Errorf("unexpected file statuses: %v", statuses)
+	}
+	if errors != 1 {
+		t.Errorf("rm reported %d error records, want 1", errors)
+	}
+}
+
+func TestPushLogsOverallProgress(t *testing.T) {
+	e := newEnv(t)
+	e.writeLocal("a.txt", "alpha", past)
+	e.writeLocal("b.txt", "bravo", past)
+	e.writeLocal("c.txt", "charlie", past)
+
+	// Runs shorter than the default interval log no progress
+	output := e.mustRun("nv", "push", "--prefix", "a.txt")
+	if strings.Contains(output, "Progress:") {
+		t.Errorf("short push logged progress:\n%s", output)
+	}
+
+	// Totals cover only the files that are transferred, the limit makes
+	// the run last longer than the interval
+	output = e.mustRun("nv", "push", "--progress-interval", "1ms", "--bwlimit", "1KiB/s")
+	assertContains(t, output, "Progress: 2/2 files, 12 B / 12 B (100%)", "done in")
+
+	// Dry runs transfer nothing and show no progress
+	e.writeLocal("d.txt", "delta", past)
+	output = e.mustRun("nv", "push", "--progress-interval", "1ms", "--dry-run")
+	if strings.Contains(output, "Progress:") {
+		t.Errorf("push --dry-run logged progress:\n%s", output)
+	}
+}
+
+func TestPullOverallProgressCountsDeletions(t *testing.T) {
+	e := newEnv(t)
+	e.putRemote("a.txt", "alpha", past)
+	e.writeLocal("old.txt", "old", past)
+
+	output := e.mustRun("nv", "pull", "--delete", "--progress-interval", "1ms", "--bwlimit", "1KiB/s")
+	assertContains(t, output, "Progress: 2/2 files, 5 B / 5 B (100%)")
+
+	if _, err := e.run("nv", "pull", "--progress-interval", "often"); err == nil || !strings.Contains(err.Error(), "invalid --progress-interval") {
+		t.Errorf("pull --progress-interval often error = %v, want invalid --progress-interval", err)
+	}
+}
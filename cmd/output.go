@@ -0,0 +1,370 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/vngcloud/aiplatform-util/pkg/storage"
+	nvsync "github.com/vngcloud/aiplatform-util/pkg/sync"
+)
+
+// Output formats selected with --output
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+	outputJSONL = "jsonl"
+	outputCSV   = "csv"
+)
+
+// printer writes command results in the format selected with --output.
+// The table format is the human-readable text printed by each command, the
+// structured formats emit records instead and suppress that text:
+//
+//	json  - a single JSON array of all records, written when the command ends
+//	jsonl - one JSON record per line, written as soon as it is known
+//	csv   - one row per record, records of another kind such as the stats
+//	        start a new table with its own header after an empty line
+type printer struct {
+	format string
+	w      io.Writer
+
+	// progressInterval is how often the overall progress of a run is
+	// logged when w is not a terminal, 0 for never
+	progressInterval time.Duration
+
+	mu      sync.Mutex
+	records []any
+	csv     *csv.Writer
+	header  []string
+}
+
+// out is the printer of the running command
+var out = &printer{format: outputTable, w: os.Stdout}
+
+// csvRecord is a record that can be written as a CSV row
+type csvRecord interface {
+	csvHeader() []string
+	csvRow() []string
+}
+
+// bucketRecord describes a bucket listed by ls
+type bucketRecord struct {
+	Type    string    `json:"type"`
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+}
+
+func (r bucketRecord) csvHeader() []string { return []string{"name", "created"} }
+func (r bucketRecord) csvRow() []string {
+	return []string{r.Name, r.Created.Format(time.RFC3339)}
+}
+
+// objectRecord describes an object listed by ls
+type objectRecord struct {
+	Type         string     `json:"type"`
+	Key          string     `json:"key"`
+	Size         int64      `json:"size"`
+	LastModified *time.Time `json:"last_modified,omitempty"`
+	ETag         string     `json:"etag,omitempty"`
+	Dir          bool       `json:"dir,omitempty"`
+}
+
+func (r objectRecord) csvHeader() []string {
+	return []string{"key", "size", "last_modified", "etag", "dir"}
+}
+func (r objectRecord) csvRow() []string {
+	modified := ""
+	if r.LastModified != nil {
+		modified = r.LastModified.Format(time.RFC3339)
+	}
+	return []string{r.Key, strconv.FormatInt(r.Size, 10), modified, r.ETag, strconv.FormatBool(r.Dir)}
+}
+
+// File record statuses
+const (
+	statusDone   = "done"
+	statusFailed = "failed"
+	statusDryRun = "dry-run"
+)
+
+// fileRecord reports what happened to a single file
+type fileRecord struct {
+	Type string `json:"type"`
+	nvsync.FileEvent
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (r fileRecord) csvHeader() []string {
+	return []string{"action", "key", "reason", "size", "status", "error"}
+}
+func (r fileRecord) csvRow() []string {
+	return []string{r.Action, r.Key, r.Reason, strconv.FormatInt(r.Size, 10), r.Status, r.Error}
+}
+
+// trashRecord describes a file in the trash
+type trashRecord struct {
+	Type string `json:"type"`
+	nvsync.TrashEntry
+}
+
+func (r trashRecord) csvHeader() []string {
+	return []string{"location", "key", "trash_key", "size", "deleted"}
+}
+func (r trashRecord) csvRow() []string {
+	return []string{r.Location, r.Key, r.TrashKey, strconv.FormatInt(r.Size, 10), r.Deleted.Format(time.RFC3339)}
+}
+
+// uploadRecord describes an incomplete multipart upload
+type uploadRecord struct {
+	Type string `json:"type"`
+	storage.Upload
+}
+
+func (r uploadRecord) csvHeader() []string {
+	return []string{"key", "upload_id", "initiated", "size"}
+}
+func (r uploadRecord) csvRow() []string {
+	return []string{r.Key, r.ID, r.Initiated.Format(time.RFC3339), strconv.FormatInt(r.Size, 10)}
+}
+
+// statsRecord holds the summary of a command
+type statsRecord struct {
+	Type    string `json:"type"`
+	Command string `json:"command"`
+	DryRun  bool   `json:"dry_run,omitempty"`
+	Stats   any    `json:"stats"`
+}
+
+func (r statsRecord) csvHeader() []string {
+	names, _ := csvFields(r.Stats)
+	return append([]string{"command", "dry_run"}, names...)
+}
+func (r statsRecord) csvRow() []string {
+	_, values := csvFields(r.Stats)
+	return append([]string{r.Command, strconv.FormatBool(r.DryRun)}, values...)
+}
+
+// errorRecord reports the error that made a command fail
+type errorRecord struct {
+	Type  string `json:"type"`
+	Error string `json:"error"`
+}
+
+func (r errorRecord) csvHeader() []string { return []string{"error"} }
+func (r errorRecord) csvRow() []string    { return []string{r.Error} }
+
+// csvFields returns the names and values of the fields of v as encoded in
+// JSON, in field order. Strings are written as is, numbers, lists such as
+// the failed keys and other values as JSON.
+func csvFields(v any) ([]string, []string) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if token, err := dec.Token(); err != nil || token != json.Delim('{') {
+		return nil, nil
+	}
+
+	var names, values []string
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			break
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			break
+		}
+		value := string(raw)
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			value = s
+		}
+		names = append(names, token.(string))
+		values = append(values, value)
+	}
+	return names, values
+}
+
+// setFormat validates and selects the output format
+func (p *printer) setFormat(format string) error {
+	switch format {
+	case outputTable, outputJSON, outputJSONL, outputCSV:
+		p.format = format
+		return nil
+	}
+	return fmt.Errorf("invalid --output %q (expected json, jsonl, table or csv)", format)
+}
+
+// terminal reports whether the output is written to a terminal, where
+// progress can be redrawn in place
+func (p *printer) terminal() bool {
+	f, ok := p.w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// structured reports whether records are emitted instead of text
+func (p *printer) structured() bool {
+	return p.format != outputTable
+}
+
+// info returns the writer for human-readable output, which is discarded
+// with structured output
+func (p *printer) info() io.Writer {
+	if p.structured() {
+		return io.Discard
+	}
+	return p.w
+}
+
+// emit writes a record in the selected structured format
+func (p *printer) emit(record any) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	switch p.format {
+	case outputJSON:
+		p.records = append(p.records, record)
+	case outputJSONL:
+		data, err := json.Marshal(record)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to encode output: %v\n", err)
+			return
+		}
+		p.w.Write(append(data, '\n'))
+	case outputCSV:
+		row, ok := record.(csvRecord)
+		if !ok {
+			return
+		}
+		if p.csv == nil {
+			p.csv = csv.NewWriter(p.w)
+		}
+		if header := row.csvHeader(); !slices.Equal(header, p.header) {
+			// Another kind of record starts a new table
+			if p.header != nil {
+				p.csv.Write(nil)
+			}
+			p.csv.Write(header)
+			p.header = header
+		}
+		p.csv.Write(row.csvRow())
+		p.csv.Flush()
+	}
+}
+
+// file emits the outcome of a single file
+func (p *printer) file(event nvsync.FileEvent, status string, err error) {
+	record := fileRecord{Type: "file", FileEvent: event, Status: status}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	p.emit(record)
+}
+
+// observer returns the sync observer of the selected format: the console
+// log with the overall progress of transfers for table output, file records
+// for structured output
+func (p *printer) observer(dryRun bool) nvsync.Observer {
+	if !p.structured() {
+		console := nvsync.NewConsoleObserver(p.w)
+		if dryRun {
+			return console
+		}
+		return nvsync.NewProgressDisplay(console, p.w, p.terminal(), p.progressInterval)
+	}
+	return &recordObserver{p: p, dryRun: dryRun}
+}
+
+// recordObserver emits a file record for the outcome of every file
+type recordObserver struct {
+	nvsync.NopObserver
+	p      *printer
+	dryRun bool
+}
+
+// Planned emits the files that would change in a dry run, other runs
+// report the outcome once it is known
+func (r *recordObserver) Planned(event nvsync.FileEvent) {
+	if r.dryRun {
+		r.p.file(event, statusDryRun, nil)
+	}
+}
+
+func (r *recordObserver) Completed(event nvsync.FileEvent) {
+	r.p.file(event, statusDone, nil)
+}
+
+func (r *recordObserver) Skipped(event nvsync.FileEvent) {
+	status := statusDone
+	if r.dryRun {
+		status = statusDryRun
+	}
+	r.p.file(event, status, nil)
+}
+
+func (r *recordObserver) Failed(event nvsync.FileEvent, err error) {
+	r.p.file(event, statusFailed, err)
+}
+
+func (r *recordObserver) Deleted(event nvsync.FileEvent) {
+	r.p.file(event, statusDone, nil)
+}
+
+// Warning writes warnings to stderr, they are not part of the records
+func (r *recordObserver) Warning(err error) {
+	fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+}
+
+// stats emits the summary of a command
+func (p *printer) stats(command string, dryRun bool, stats any) {
+	p.emit(statsRecord{Type: "stats", Command: command, DryRun: dryRun, Stats: stats})
+}
+
+// fail reports the error that made the command fail
+func (p *printer) fail(err error) {
+	p.emit(errorRecord{Type: "error", Error: err.Error()})
+}
+
+// flush writes the records buffered for json output
+func (p *printer) flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.format != outputJSON {
+		return
+	}
+	records := p.records
+	if records == nil {
+		records = []any{}
+	}
+	enc := json.NewEncoder(p.w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(records); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to encode output: %v\n", err)
+	}
+}
+
+// infof prints human-readable output, suppressed with structured output
+func infof(format string, a ...any) {
+	fmt.Fprintf(out.info(), format, a...)
+}
+
+// infoln prints a line of human-readable output, suppressed with structured output
+func infoln(a ...any) {
+	fmt.Fprintln(out.info(), a...)
+}
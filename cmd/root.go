@@ -1,14 +1,21 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/spf13/cobra"
+	"github.com/vngcloud/aiplatform-util/pkg/config"
 )
 
 var (
 	version = "0.1.0"
+
+	// stopTimeout releases the timer of the --timeout context
+	stopTimeout context.CancelFunc = func() {}
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -21,18 +28,90 @@ var rootCmd = &cobra.Command{
 It provides a git-like interface for listing, pulling, and pushing files between
 your local workspace and the network volume.`,
 	Version: version,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		format, _ := cmd.Flags().GetString("output")
+		if err := out.setFormat(format); err != nil {
+			return err
+		}
+
+		// Structured output reports errors as records
+		if out.structured() {
+			cmd.SilenceErrors = true
+			cmd.SilenceUsage = true
+		}
+
+		// Overall progress of transfers is logged this often when the
+		// output is not a terminal
+		intervalStr, _ := cmd.Flags().GetString("progress-interval")
+		interval, err := config.ParseDuration(intervalStr)
+		if err != nil {
+			return fmt.Errorf("invalid --progress-interval: %w", err)
+		}
+		out.progressInterval = interval
+
+		// Bound the whole run, it stops like an interrupted one
+		timeoutStr, _ := cmd.Flags().GetString("timeout")
+		timeout, err := config.ParseDuration(timeoutStr)
+		if err != nil {
+			return fmt.Errorf("invalid --timeout: %w", err)
+		}
+		if timeout > 0 {
+			ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+			cmd.SetContext(ctx)
+			stopTimeout = cancel
+		}
+		return nil
+	},
+}
+
+// signalContext returns a context cancelled by the first SIGINT or SIGTERM,
+// so that commands stop transferring, clean up and print what they did.
+// A second signal terminates the process immediately.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			fmt.Fprintf(os.Stderr, "\nReceived %s, stopping after cleaning up (press Ctrl-C again to quit immediately)\n", sig)
+			signal.Stop(signals)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel()
+	}
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Fprintln(os.Stderr, err)
+	ctx, stop := signalContext()
+	err := rootCmd.ExecuteContext(ctx)
+	stopTimeout()
+	stop()
+
+	if err != nil {
+		if out.structured() {
+			out.fail(err)
+			out.flush()
+		} else {
+			fmt.Fprintln(os.Stderr, err)
+		}
 		os.Exit(1)
 	}
+	out.flush()
 }
 
 func init() {
 	// Global flags can be added here
 	// rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.aiplatform-util.yaml)")
+	rootCmd.PersistentFlags().StringP("output", "o", outputTable, "Output format: table, json, jsonl or csv")
+	rootCmd.PersistentFlags().String("progress-interval", "10s", "How often the overall progress of transfers is logged when the output is not a terminal (0 = never)")
+	rootCmd.PersistentFlags().String("timeout", "0", "Stop the command cleanly after this long, e.g. 30m or 2h (0 = no limit)")
 }
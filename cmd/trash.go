@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vngcloud/aiplatform-util/pkg/config"
+	"github.com/vngcloud/aiplatform-util/pkg/sync"
+)
+
+// trashCmd represents the trash command
+var trashCmd = &cobra.Command{
+	Use:   "trash",
+	Short: "Manage files deleted with --trash",
+	Long: `Files deleted by pull, push, sync or rm with --trash are kept in a trash
+instead of being removed permanently. Remote objects are moved to the
+.trash/<timestamp>/ prefix of the bucket and local files to
+.aiplatform/trash/<timestamp>/ in the workspace.
+
+Available commands:
+  ls      - List trashed files
+  restore - Restore a trashed file to its original location
+  empty   - Permanently delete trashed files`,
+}
+
+// trashLsCmd represents the trash ls command
+var trashLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List trashed files",
+	Long: `List the files in the remote and local trash, most recently deleted first.
+
+Examples:
+  aiplatform-util nv trash ls`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		cfg, client, err := remoteClient(cmd, "trash")
+		if err != nil {
+			return err
+		}
+
+		entries, err := sync.ListTrash(ctx, client, cfg.MountPath)
+		if err != nil {
+			return err
+		}
+
+		if out.structured() {
+			for _, entry := range entries {
+				out.emit(trashRecord{Type: "trash", TrashEntry: entry})
+			}
+			return nil
+		}
+
+		if len(entries) == 0 {
+			infoln("Trash is empty")
+			return nil
+		}
+
+		infof("%-8s %-20s %-60s %15s\n", "WHERE", "DELETED", "KEY", "SIZE")
+		infoln("─────────────────────────────────────────────────────────────────────────────────────────────────────────")
+		for _, entry := range entries {
+			infof("%-8s %-20s %-60s %15s\n",
+				entry.Location,
+				entry.Deleted.Local().Format("2006-01-02 15:04:05"),
+				entry.Key,
+				formatSize(entry.Size))
+		}
+
+		infof("\nTotal: %d files\n", len(entries))
+		return nil
+	},
+}
+
+// trashRestoreCmd represents the trash restore command
+var trashRestoreCmd = &cobra.Command{
+	Use:   "restore <key>",
+	Short: "Restore a trashed file to its original location",
+	Long: `Restore a trashed file to the key it had before it was deleted. The key is
+either the original key, which restores its most recently deleted version,
+or the full trash key (e.g. .trash/20240102T150405.123456789/data/a.csv).
+Remote objects are restored by default, use --local for files deleted
+locally.
+
+Examples:
+  aiplatform-util nv trash restore models/model.pth
+  aiplatform-util nv trash restore .trash/20240102T150405.123456789/models/model.pth
+  aiplatform-util nv trash restore --local data/train.csv`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		cfg, client, err := remoteClient(cmd, "trash")
+		if err != nil {
+			return err
+		}
+
+		local, _ := cmd.Flags().GetBool("local")
+		force, _ := cmd.Flags().GetBool("force")
+
+		location := sync.TrashRemote
+		if local {
+			location = sync.TrashLocal
+		}
+
+		entry, err := sync.RestoreTrash(ctx, client, cfg.MountPath, location, args[0], force)
+		if err != nil {
+			return fmt.Errorf("restore failed: %w", err)
+		}
+
+		out.emit(trashRecord{Type: "restored", TrashEntry: *entry})
+		infof("Restored %s %s (deleted %s)\n", entry.Location, entry.Key, entry.Deleted.Local().Format("2006-01-02 15:04:05"))
+		return nil
+	},
+}
+
+// trashEmptyCmd represents the trash empty command
+var trashEmptyCmd = &cobra.Command{
+	Use:   "empty",
+	Short: "Permanently delete trashed files",
+	Long: `Permanently delete files from the remote and local trash. With --older-than,
+only files deleted longer ago than the given duration are removed.
+
+Examples:
+  aiplatform-util nv trash empty --older-than 7d
+  aiplatform-util nv trash empty --older-than 36h --dry-run
+  aiplatform-util nv trash empty`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		cfg, client, err := remoteClient(cmd, "trash")
+		if err != nil {
+			return err
+		}
+
+		olderThanStr, _ := cmd.Flags().GetString("older-than")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		olderThan, err := config.ParseDuration(olderThanStr)
+		if err != nil {
+			return fmt.Errorf("invalid --older-than: %w", err)
+		}
+
+		if dryRun {
+			infoln("DRY RUN - no changes will be made")
+			infoln()
+		}
+
+		stats, err := sync.EmptyTrash(ctx, client, cfg.MountPath, time.Now().Add(-olderThan), dryRun, out.info())
+		if err != nil {
+			return fmt.Errorf("empty trash failed: %w", err)
+		}
+		out.stats("trash empty", dryRun, stats)
+
+		if !dryRun {
+			infoln()
+			infoln("─────────────────────────────────────")
+			infoln("Summary:")
+			infof("  Deleted: %d files\n", stats.Deleted)
+			if stats.Failed > 0 {
+				infof("  Failed:  %d files\n", stats.Failed)
+			}
+			infoln("─────────────────────────────────────")
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	// Add trash command to nv
+	nvCmd.AddCommand(trashCmd)
+
+	// Add subcommands to trash
+	trashCmd.AddCommand(trashLsCmd)
+	trashCmd.AddCommand(trashRestoreCmd)
+	trashCmd.AddCommand(trashEmptyCmd)
+
+	// Flags for trash restore command
+	trashRestoreCmd.Flags().Bool("local", false, "Restore a file from the local trash instead of the remote trash")
+	trashRestoreCmd.Flags().Bool("force", false, "Overwrite an existing file at the original key")
+
+	// Flags for trash empty command
+	trashEmptyCmd.Flags().String("older-than", "0s", "Only delete files trashed longer ago than this (e.g. 7d, 2w, 36h)")
+	trashEmptyCmd.Flags().Bool("dry-run", false, "Preview without executing")
+}
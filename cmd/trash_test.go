@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTrashRestoresRemovedObject(t *testing.T) {
+	e := newEnv(t)
+	e.putRemote("models/model.pth", "weights", past)
+
+	e.mustRun("nv", "rm", "--trash", "models/model.pth")
+	if e.hasRemote("models/model.pth") {
+		t.Fatal("rm --trash kept the object")
+	}
+
+	output := e.mustRun("nv", "trash", "ls")
+	assertContains(t, output, "remote", "models/model.pth")
+
+	e.mustRun("nv", "trash", "restore", "models/model.pth")
+	if e.remote("models/model.pth") != "weights" {
+		t.Error("restored object has the wrong content")
+	}
+	assertContains(t, e.mustRun("nv", "trash", "ls"), "Trash is empty")
+}
+
+func TestTrashRestoreLocalFile(t *testing.T) {
+	e := newEnv(t)
+	e.putRemote("keep.txt", "keep", past)
+	e.writeLocal("keep.txt", "keep", past)
+	e.writeLocal("notes.txt", "my notes", past)
+
+	e.mustRun("nv", "pull", "--delete", "--trash")
+	if e.hasLocal("notes.txt") {
+		t.Fatal("pull --delete --trash kept the local file")
+	}
+
+	e.mustRun("nv", "trash", "restore", "--local", "notes.txt")
+	if e.local("notes.txt") != "my notes" {
+		t.Error("restored file has the wrong content")
+	}
+}
+
+func TestTrashEmpty(t *testing.T) {
+	e := newEnv(t)
+	e.putRemote("a.txt", "a", past)
+	e.mustRun("nv", "rm", "--trash", "a.txt")
+
+	// Nothing was trashed a week ago
+	output := e.mustRun("nv", "trash", "empty", "--older-than", "7d")
+	assertContains(t, output, "Deleted: 0 files")
+
+	output = e.mustRun("nv", "trash", "empty", "--dry-run")
+	assertContains(t, output, "DRY RUN")
+	if len(e.server.Keys(e.cfg.BucketName)) != 1 {
+		t.Fatal("trash empty --dry-run deleted an object")
+	}
+
+	e.mustRun("nv", "trash", "empty")
+	if keys := e.server.Keys(e.cfg.BucketName); len(keys) != 0 {
+		t.Errorf("trash empty left objects: %s", strings.Join(keys, ", "))
+	}
+}
+
+func TestTrashKeepsVersionsDeletedInOneSecond(t *testing.T) {
+	e := newEnv(t)
+	// Trashed before stamps had fractional seconds
+	e.putRemote(".trash/20240102T150405/old.txt", "old", past)
+
+	e.putRemote("a.txt", "v1", past)
+	e.mustRun("nv", "rm", "--trash", "a.txt")
+	e.putRemote("a.txt", "v2", past)
+	e.mustRun("nv", "rm", "--trash", "a.txt")
+
+	output := e.mustRun("nv", "trash", "ls")
+	assertContains(t, output, "old.txt")
+	if count := strings.Count(output, "a.txt"); count != 2 {
+		t.Fatalf("trash ls lists %d versions of a.txt, want 2:\n%s", count, output)
+	}
+
+	// The latest version is restored first
+	e.mustRun("nv", "trash", "restore", "a.txt")
+	if e.remote("a.txt") != "v2" {
+		t.Errorf("restored %q, want the latest version", e.remote("a.txt"))
+	}
+}
+
+func TestTrashEmptyRemovesLocalTrashDirectories(t *testing.T) {
+	e := newEnv(t)
+	e.putRemote("keep.txt", "keep", past)
+	e.writeLocal("notes.txt", "my notes", past)
+	e.mustRun("nv", "pull", "--delete", "--trash")
+
+	e.mustRun("nv", "trash", "empty")
+	entries, err := os.ReadDir(filepath.Join(e.cfg.MountPath, ".aiplatform", "trash"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("trash empty left %d local trash directories", len(entries))
+	}
+}
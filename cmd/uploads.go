@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/vngcloud/aiplatform-util/pkg/config"
+	"github.com/vngcloud/aiplatform-util/pkg/storage"
+	"github.com/vngcloud/aiplatform-util/pkg/sync"
+)
+
+// uploadsCmd represents the uploads command
+var uploadsCmd = &cobra.Command{
+	Use:   "uploads",
+	Short: "Manage incomplete multipart uploads",
+	Long: `Large files are uploaded in parts. An upload that is interrupted before it
+completes, e.g. because the machine running push was stopped, leaves its parts
+in the bucket where they take up quota without being visible as objects.
+
+Available commands:
+  ls    - List incomplete uploads
+  abort - Abort incomplete uploads and delete their parts`,
+}
+
+// uploadsLsCmd represents the uploads ls command
+var uploadsLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List incomplete uploads",
+	Long: `List the incomplete multipart uploads in the bucket with the time they were
+started and the size of the parts uploaded so far.
+
+Examples:
+  aiplatform-util nv uploads ls
+  aiplatform-util nv uploads ls --prefix models/`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		uploads, err := uploadsClient(cmd)
+		if err != nil {
+			return err
+		}
+
+		prefix, _ := cmd.Flags().GetString("prefix")
+
+		list, err := uploads.ListUploads(ctx, prefix)
+		if err != nil {
+			return err
+		}
+
+		if out.structured() {
+			for _, upload := range list {
+				out.emit(uploadRecord{Type: "upload", Upload: upload})
+			}
+			return nil
+		}
+
+		if len(list) == 0 {
+			infoln("No incomplete uploads")
+			return nil
+		}
+
+		var total int64
+		infof("%-20s %-60s %15s\n", "INITIATED", "KEY", "SIZE")
+		infoln("────────────────────────────────────────────────────────────────────────────────────────────────")
+		for _, upload := range list {
+			infof("%-20s %-60s %15s\n",
+				upload.Initiated.Local().Format("2006-01-02 15:04:05"),
+				upload.Key,
+				formatSize(upload.Size))
+			total += upload.Size
+		}
+
+		infof("\nTotal: %d uploads, %s\n", len(list), formatSize(total))
+		return nil
+	},
+}
+
+// uploadsAbortCmd represents the uploads abort command
+var uploadsAbortCmd = &cobra.Command{
+	Use:   "abort",
+	Short: "Abort incomplete uploads and delete their parts",
+	Long: `Abort the incomplete multipart uploads started longer ago than --older-than
+and delete the parts uploaded so far. An upload still in progress fails if it
+is aborted, so the default only aborts uploads started more than a day ago.
+
+Examples:
+  aiplatform-util nv uploads abort
+  aiplatform-util nv uploads abort --older-than 7d --prefix models/
+  aiplatform-util nv uploads abort --older-than 0 --dry-run`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+
+		uploads, err := uploadsClient(cmd)
+		if err != nil {
+			return err
+		}
+
+		prefix, _ := cmd.Flags().GetString("prefix")
+		olderThanStr, _ := cmd.Flags().GetString("older-than")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+		olderThan, err := config.ParseDuration(olderThanStr)
+		if err != nil {
+			return fmt.Errorf("invalid --older-than: %w", err)
+		}
+
+		if dryRun {
+			infoln("DRY RUN - no changes will be made")
+			infoln()
+		}
+
+		stats, err := sync.AbortUploads(ctx, uploads, prefix, time.Now().Add(-olderThan), dryRun, out.info())
+		if err != nil {
+			err = fmt.Errorf("abort uploads failed: %w", err)
+			if stats == nil {
+				return err
+			}
+		}
+		out.stats("uploads abort", dryRun, stats)
+
+		if !dryRun {
+			infoln()
+			infoln("─────────────────────────────────────")
+			summaryTitle(dryRun, err)
+			infof("  Aborted: %d uploads (%s)\n", stats.Aborted, formatSize(stats.Size))
+			if stats.Failed > 0 {
+				infof("  Failed:  %d uploads\n", stats.Failed)
+			}
+			infoln("─────────────────────────────────────")
+		}
+
+		if err == nil && stats.Failed > 0 {
+			cmd.SilenceUsage = true
+			err = fmt.Errorf("%d uploads could not be aborted", stats.Failed)
+		}
+		return err
+	},
+}
+
+// uploadsClient creates the client used by the uploads commands
+func uploadsClient(cmd *cobra.Command) (storage.Uploads, error) {
+	_, client, err := remoteClient(cmd, "uploads")
+	if err != nil {
+		return nil, err
+	}
+	return storage.UploadsOf(client), nil
+}
+
+func init() {
+	// Add uploads command to nv
+	nvCmd.AddCommand(uploadsCmd)
+
+	// Add subcommands to uploads
+	uploadsCmd.AddCommand(uploadsLsCmd)
+	uploadsCmd.AddCommand(uploadsAbortCmd)
+
+	// Flags for uploads ls command
+	uploadsLsCmd.Flags().String("prefix", "", "Only list uploads of keys starting with this prefix")
+
+	// Flags for uploads abort command
+	uploadsAbortCmd.Flags().String("prefix", "", "Only abort uploads of keys starting with this prefix")
+	uploadsAbortCmd.Flags().String("older-than", "24h", "Only abort uploads started longer ago than this (e.g. 36h, 7d, 0 for all)")
+	uploadsAbortCmd.Flags().Bool("dry-run", false, "Preview without executing")
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUploadsLsListsIncompleteUploads(t *testing.T) {
+	e := newEnv(t)
+	e.server.StartUpload(e.cfg.BucketName, "models/a.bin", make([]byte, 2048), time.Now().Add(-48*time.Hour))
+	e.server.StartUpload(e.cfg.BucketName, "data/b.bin", make([]byte, 1024), time.Now())
+
+	output := e.mustRun("nv", "uploads", "ls")
+	assertContains(t, output, "models/a.bin", "data/b.bin", "Total: 2 uploads, 3.00 KB")
+
+	output = e.mustRun("nv", "uploads", "ls", "--prefix", "models/", "--output", "jsonl")
+	assertContains(t, output, `"key":"models/a.bin"`, `"size":2048`)
+	if strings.Contains(output, "data/b.bin") {
+		t.Errorf("uploads ls --prefix listed an upload outside the prefix:\n%s", output)
+	}
+}
+
+func TestUploadsAbortOlderThan(t *testing.T) {
+	e := newEnv(t)
+	e.server.StartUpload(e.cfg.BucketName, "a.bin", make([]byte, 1024), time.Now().Add(-48*time.Hour))
+	recent := e.server.StartUpload(e.cfg.BucketName, "b.bin", make([]byte, 1024), time.Now())
+
+	output := e.mustRun("nv", "uploads", "abort", "--dry-run")
+	assertContains(t, output, "DRY RUN", "Aborting upload of a.bin")
+	if uploads := e.server.UploadIDs(e.cfg.BucketName); len(uploads) != 2 {
+		t.Fatalf("uploads abort --dry-run aborted an upload, %d left", len(uploads))
+	}
+
+	// Only the upload started more than a day ago is aborted by default
+	output = e.mustRun("nv", "uploads", "abort")
+	assertContains(t, output, "Aborted: 1 uploads")
+	if uploads := e.server.UploadIDs(e.cfg.BucketName); len(uploads) != 1 || uploads[0] != recent {
+		t.Fatalf("uploads left = %v, want [%s]", uploads, recent)
+	}
+
+	e.mustRun("nv", "uploads", "abort", "--older-than", "0")
+	if uploads := e.server.UploadIDs(e.cfg.BucketName); len(uploads) != 0 {
+		t.Errorf("uploads abort --older-than 0 left %d uploads", len(uploads))
+	}
+}
+
+func TestPushAbortsStaleUploads(t *testing.T) {
+	e := newEnv(t)
+	e.writeLocal("a.txt", "alpha", past)
+	e.server.StartUpload(e.cfg.BucketName, "a.txt", make([]byte, 1024), time.Now().Add(-48*time.Hour))
+	other := e.server.StartUpload(e.cfg.BucketName, "other.txt", make([]byte, 1024), time.Now().Add(-48*time.Hour))
+
+	output := e.mustRun("nv", "push", "--abort-stale-uploads", "24h")
+	assertContains(t, output, "Uploaded:  1 files", "Aborted:   1 stale uploads")
+
+	// Uploads of keys that are not pushed are left alone
+	if uploads := e.server.UploadIDs(e.cfg.BucketName); len(uploads) != 1 || uploads[0] != other {
+		t.Errorf("uploads left = %v, want [%s]", uploads, other)
+	}
+}
@@ -2,7 +2,12 @@ module github.com/vngcloud/aiplatform-util
 
 go 1.24.3
 
-require github.com/spf13/cobra v1.10.1
+require (
+	github.com/fsnotify/fsnotify v1.9.0
+	github.com/minio/minio-go/v7 v7.0.97
+	github.com/spf13/cobra v1.10.1
+	github.com/spf13/pflag v1.0.9
+)
 
 require (
 	github.com/dustin/go-humanize v1.0.1 // indirect
@@ -14,10 +19,8 @@ require (
 	github.com/klauspost/crc32 v1.3.0 // indirect
 	github.com/minio/crc64nvme v1.1.0 // indirect
 	github.com/minio/md5-simd v1.1.2 // indirect
-	github.com/minio/minio-go/v7 v7.0.97 // indirect
 	github.com/philhofer/fwd v1.2.0 // indirect
 	github.com/rs/xid v1.6.0 // indirect
-	github.com/spf13/pflag v1.0.9 // indirect
 	github.com/tinylib/msgp v1.3.0 // indirect
 	golang.org/x/crypto v0.36.0 // indirect
 	golang.org/x/net v0.38.0 // indirect
@@ -0,0 +1,137 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Bandwidth holds transfer rate limits in bytes per second, 0 = unlimited
+type Bandwidth struct {
+	Upload   int64
+	Download int64
+}
+
+// BandwidthSlot is the bandwidth limit in effect from Start, the time of day
+// as an offset from midnight, until the start of the next slot
+type BandwidthSlot struct {
+	Start time.Duration
+	Bandwidth
+}
+
+// BandwidthSchedule is a list of bandwidth limits ordered by the time of day
+// they come into effect. The last slot stays in effect past midnight until
+// the first one starts. An empty schedule does not limit transfers.
+type BandwidthSchedule []BandwidthSlot
+
+// ParseBandwidthSchedule parses a bandwidth limit. The limit is either a
+// single rate such as "50MiB/s", separate upload and download rates such as
+// "10MiB/s:100MiB/s", or a timetable of space separated "HH:MM,rate"
+// entries such as "08:00,10MiB/s 18:00,off". "off" and "0" mean unlimited.
+func ParseBandwidthSchedule(value string) (BandwidthSchedule, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	// A single rate applies all day
+	if len(fields) == 1 && !strings.Contains(fields[0], ",") {
+		bandwidth, err := parseBandwidth(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		if bandwidth == (Bandwidth{}) {
+			return nil, nil
+		}
+		return BandwidthSchedule{{Bandwidth: bandwidth}}, nil
+	}
+
+	schedule := make(BandwidthSchedule, 0, len(fields))
+	seen := make(map[time.Duration]bool)
+	for _, field := range fields {
+		at, rate, ok := strings.Cut(field, ",")
+		if !ok {
+			return nil, fmt.Errorf("invalid bandwidth timetable entry %q (expected e.g. 08:00,10MiB/s)", field)
+		}
+		start, err := parseTimeOfDay(at)
+		if err != nil {
+			return nil, err
+		}
+		if seen[start] {
+			return nil, fmt.Errorf("bandwidth timetable lists %s twice", at)
+		}
+		seen[start] = true
+		bandwidth, err := parseBandwidth(rate)
+		if err != nil {
+			return nil, err
+		}
+		schedule = append(schedule, BandwidthSlot{Start: start, Bandwidth: bandwidth})
+	}
+
+	sort.Slice(schedule, func(i, j int) bool { return schedule[i].Start < schedule[j].Start })
+	return schedule, nil
+}
+
+// At returns the bandwidth limit in effect at t, in t's time zone
+func (s BandwidthSchedule) At(t time.Time) Bandwidth {
+	if len(s) == 0 {
+		return Bandwidth{}
+	}
+
+	hour, minute, second := t.Clock()
+	sinceMidnight := time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute + time.Duration(second)*time.Second
+
+	// Before the first slot of the day the last one of the previous day applies
+	current := s[len(s)-1]
+	for _, slot := range s {
+		if slot.Start > sinceMidnight {
+			break
+		}
+		current = slot
+	}
+	return current.Bandwidth
+}
+
+// parseBandwidth parses a rate applying to both directions such as
+// "50MiB/s", or separate upload and download rates such as "10MiB/s:off"
+func parseBandwidth(value string) (Bandwidth, error) {
+	up, down, separate := strings.Cut(value, ":")
+	upload, err := parseRate(up)
+	if err != nil {
+		return Bandwidth{}, err
+	}
+	if !separate {
+		return Bandwidth{Upload: upload, Download: upload}, nil
+	}
+	download, err := parseRate(down)
+	if err != nil {
+		return Bandwidth{}, err
+	}
+	return Bandwidth{Upload: upload, Download: download}, nil
+}
+
+// parseRate parses a rate in bytes per second such as "50MiB/s" or "512K",
+// returning 0 for "off"
+func parseRate(value string) (int64, error) {
+	if strings.EqualFold(value, "off") {
+		return 0, nil
+	}
+	size, err := ParseSize(strings.TrimSuffix(strings.TrimSuffix(value, "/s"), "/S"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid bandwidth %q (expected e.g. 50MiB/s or off)", value)
+	}
+	return size, nil
+}
+
+// parseTimeOfDay parses a 24-hour "HH:MM" time into the offset from midnight
+func parseTimeOfDay(value string) (time.Duration, error) {
+	hours, minutes, ok := strings.Cut(value, ":")
+	h, herr := strconv.Atoi(hours)
+	m, merr := strconv.Atoi(minutes)
+	if !ok || herr != nil || merr != nil || h < 0 || h > 23 || m < 0 || m > 59 {
+		return 0, fmt.Errorf("invalid time of day %q (expected HH:MM)", value)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseBandwidthSchedule(t *testing.T) {
+	const mib = 1 << 20
+	tests := []struct {
+		name    string
+		value   string
+		want    BandwidthSchedule
+		wantErr string
+	}{
+		{"empty", "", nil, ""},
+		{"off", "off", nil, ""},
+		{"zero", "0", nil, ""},
+		{"single rate", "50MiB/s", BandwidthSchedule{{Bandwidth: Bandwidth{Upload: 50 * mib, Download: 50 * mib}}}, ""},
+		{"rate without /s", "512K", BandwidthSchedule{{Bandwidth: Bandwidth{Upload: 512 << 10, Download: 512 << 10}}}, ""},
+		{"decimal unit", "1.5MB/s", BandwidthSchedule{{Bandwidth: Bandwidth{Upload: 1.5 * mib, Download: 1.5 * mib}}}, ""},
+		{"separate rates", "10MiB/s:100MiB/s", BandwidthSchedule{{Bandwidth: Bandwidth{Upload: 10 * mib, Download: 100 * mib}}}, ""},
+		{"upload only", "10MiB/s:off", BandwidthSchedule{{Bandwidth: Bandwidth{Upload: 10 * mib}}}, ""},
+
+		// Timetable entries are sorted by time of day
+		{"timetable", "18:00,off 08:00,10MiB/s", BandwidthSchedule{
+			{Start: 8 * time.Hour, Bandwidth: Bandwidth{Upload: 10 * mib, Download: 10 * mib}},
+			{Start: 18 * time.Hour},
+		}, ""},
+		{"single entry", "08:30,1M:2M", BandwidthSchedule{
+			{Start: 8*time.Hour + 30*time.Minute, Bandwidth: Bandwidth{Upload: mib, Download: 2 * mib}},
+		}, ""},
+		{"midnight and last minute", "00:00,1M 23:59,2M", BandwidthSchedule{
+			{Start: 0, Bandwidth: Bandwidth{Upload: mib, Download: mib}},
+			{Start: 23*time.Hour + 59*time.Minute, Bandwidth: Bandwidth{Upload: 2 * mib, Download: 2 * mib}},
+		}, ""},
+
+		{"bad unit", "10XB/s", nil, `invalid bandwidth "10XB/s"`},
+		{"bad rate unit", "10MiB/m", nil, `invalid bandwidth "10MiB/m"`},
+		{"negative rate", "-1M", nil, `invalid bandwidth "-1M"`},
+		{"bad download rate", "1M:fast", nil, `invalid bandwidth "fast"`},
+		{"bad timetable rate", "08:00,10Mbps", nil, `invalid bandwidth "10Mbps"`},
+		{"missing time", "10M 20M", nil, `invalid bandwidth timetable entry "10M"`},
+		{"overlapping entries", "08:00,1M 08:00,2M", nil, "bandwidth timetable lists 08:00 twice"},
+		{"overlapping spellings", "8:00,1M 08:00,2M", nil, "bandwidth timetable lists 08:00 twice"},
+		{"hour out of range", "24:00,1M", nil, `invalid time of day "24:00"`},
+		{"minute out of range", "08:60,1M", nil, `invalid time of day "08:60"`},
+		{"time without minutes", "08,1M", nil, `invalid time of day "08"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseBandwidthSchedule(tt.value)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseBandwidthSchedule(%q) = %v, want an error containing %q", tt.value, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseBandwidthSchedule(%q) failed: %v", tt.value, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseBandwidthSchedule(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBandwidthScheduleAt(t *testing.T) {
+	schedule, err := ParseBandwidthSchedule("08:00,10M 12:30,off 18:00,1M:2M")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		at   string
+		want Bandwidth
+	}{
+		// The last slot wraps around midnight until the first one starts
+		{"00:00:00", Bandwidth{Upload: 1 << 20, Download: 2 << 20}},
+		{"07:59:59", Bandwidth{Upload: 1 << 20, Download: 2 << 20}},
+		{"08:00:00", Bandwidth{Upload: 10 << 20, Download: 10 << 20}},
+		{"12:29:59", Bandwidth{Upload: 10 << 20, Download: 10 << 20}},
+		{"12:30:00", Bandwidth{}},
+		{"18:00:00", Bandwidth{Upload: 1 << 20, Download: 2 << 20}},
+		{"23:59:59", Bandwidth{Upload: 1 << 20, Download: 2 << 20}},
+	}
+	for _, tt := range tests {
+		at, err := time.Parse("15:04:05", tt.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := schedule.At(at); got != tt.want {
+			t.Errorf("At(%s) = %+v, want %+v", tt.at, got, tt.want)
+		}
+	}
+
+	var empty BandwidthSchedule
+	if got := empty.At(time.Now()); got != (Bandwidth{}) {
+		t.Errorf("empty schedule At = %+v, want no limit", got)
+	}
+}
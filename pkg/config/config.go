@@ -16,6 +16,10 @@ type Config struct {
 	// S3 bucket and local mount path
 	BucketName string
 	MountPath  string
+
+	// BandwidthLimit is the default transfer rate limit or timetable, see
+	// ParseBandwidthSchedule
+	BandwidthLimit string
 }
 
 const (
@@ -58,6 +62,7 @@ func Load() (*Config, error) {
 		Endpoint:        getConfigValue("AWS_ENDPOINT"),
 		BucketName:      getConfigValue("S3_BUCKET"),
 		MountPath:       mountPath,
+		BandwidthLimit:  getConfigValue("BWLIMIT"),
 	}
 
 	// Validate required fields
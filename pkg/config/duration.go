@@ -0,0 +1,30 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseDuration parses a duration such as "7d", "2w" or "36h". Days and
+// weeks are accepted on top of the units understood by time.ParseDuration.
+func ParseDuration(value string) (time.Duration, error) {
+	s := strings.TrimSpace(value)
+
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if number, ok := strings.CutSuffix(s, suffix); ok {
+			n, err := strconv.ParseFloat(number, 64)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid duration %q (expected e.g. 7d, 2w or 36h)", value)
+			}
+			return time.Duration(n * float64(unit)), nil
+		}
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q (expected e.g. 7d, 2w or 36h)", value)
+	}
+	return d, nil
+}
@@ -0,0 +1,58 @@
+package ignore
+
+import "strings"
+
+// Filter selects keys with --include and --exclude patterns evaluated
+// against the key relative to a prefix. A key is selected if it matches at
+// least one include pattern (or no include patterns are given) and no
+// exclude pattern. A nil Filter selects everything.
+type Filter struct {
+	prefix  string
+	include *Matcher
+	exclude *Matcher
+}
+
+// NewFilter creates a filter for keys under prefix
+func NewFilter(prefix string, include []string, exclude []string) *Filter {
+	return &Filter{
+		prefix:  prefix,
+		include: New(include...),
+		exclude: New(exclude...),
+	}
+}
+
+// Empty reports whether the filter has no patterns
+func (f *Filter) Empty() bool {
+	return f == nil || (f.include.Empty() && f.exclude.Empty())
+}
+
+// Selected reports whether a key passes the filter
+func (f *Filter) Selected(key string, isDir bool) bool {
+	if f.Empty() {
+		return true
+	}
+
+	name := f.relative(key)
+	if f.exclude.Match(name, isDir) {
+		return false
+	}
+	// Include patterns select files, directories are kept so that
+	// matching files inside them can still be reached
+	if isDir || f.include.Empty() {
+		return true
+	}
+	return f.include.Match(name, isDir)
+}
+
+// Pruned reports whether a directory key is excluded with everything in it
+func (f *Filter) Pruned(dir string) bool {
+	if f.Empty() {
+		return false
+	}
+	return f.exclude.Match(f.relative(dir), true)
+}
+
+// relative returns a key relative to the filter prefix
+func (f *Filter) relative(key string) string {
+	return strings.TrimLeft(strings.TrimPrefix(key, f.prefix), "/")
+}
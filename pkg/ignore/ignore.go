@@ -0,0 +1,243 @@
+package ignore
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FileName is the name of per-directory ignore files
+const FileName = ".nvignore"
+
+// pattern is a single parsed gitignore-style pattern
+type pattern struct {
+	// base is the slash-separated directory the pattern is relative to,
+	// "" for the root
+	base string
+
+	segments []string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// Matcher matches slash-separated paths against gitignore-style patterns.
+// Patterns support anchoring with a leading or inner "/", "**" for any number
+// of directories, a trailing "/" to match directories only and "!" to
+// re-include a path excluded by an earlier pattern. The last matching
+// pattern wins, and nothing inside an excluded directory can be re-included.
+type Matcher struct {
+	patterns []pattern
+}
+
+// New creates a matcher for patterns relative to the root
+func New(patterns ...string) *Matcher {
+	m := &Matcher{}
+	m.Add("", patterns...)
+	return m
+}
+
+// Add adds patterns relative to the slash-separated directory base.
+// Blank lines and lines starting with "#" are ignored.
+func (m *Matcher) Add(base string, lines ...string) {
+	base = strings.Trim(base, "/")
+	if base == "." {
+		base = ""
+	}
+
+	for _, line := range lines {
+		if p, ok := parsePattern(base, line); ok {
+			m.patterns = append(m.patterns, p)
+		}
+	}
+}
+
+// AddFile adds the patterns of an ignore file located in the slash-separated
+// directory base. A missing file is not an error.
+func (m *Matcher) AddFile(base string, filePath string) error {
+	file, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", filePath, err)
+	}
+
+	m.Add(base, lines...)
+	return nil
+}
+
+// AddDir loads the ignore file of a local directory, if any.
+// root is the local root directory and relDir the slash-separated path
+// of the directory relative to it.
+func (m *Matcher) AddDir(root string, relDir string) error {
+	return m.AddFile(relDir, filepath.Join(root, filepath.FromSlash(relDir), FileName))
+}
+
+// AddParents loads the ignore files of root and of every directory between
+// root and relDir, excluding relDir itself. It is used when walking starts
+// below the root so that patterns from parent directories still apply.
+func (m *Matcher) AddParents(root string, relDir string) error {
+	relDir = strings.Trim(relDir, "/")
+	if relDir == "" || relDir == "." {
+		return nil
+	}
+
+	if err := m.AddDir(root, ""); err != nil {
+		return err
+	}
+	parts := strings.Split(relDir, "/")
+	for i := 1; i < len(parts); i++ {
+		if err := m.AddDir(root, strings.Join(parts[:i], "/")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Empty reports whether the matcher has no patterns
+func (m *Matcher) Empty() bool {
+	return m == nil || len(m.patterns) == 0
+}
+
+// Match reports whether a slash-separated path is excluded, either directly
+// or because one of its parent directories is excluded
+func (m *Matcher) Match(name string, isDir bool) bool {
+	if m.Empty() {
+		return false
+	}
+
+	name = strings.Trim(name, "/")
+	parts := strings.Split(name, "/")
+	for i := 1; i < len(parts); i++ {
+		if m.matchPath(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return m.matchPath(name, isDir)
+}
+
+// matchPath applies the patterns to a single path without looking at its
+// parents, the last matching pattern decides
+func (m *Matcher) matchPath(name string, isDir bool) bool {
+	excluded := false
+	for _, p := range m.patterns {
+		if p.matches(name, isDir) {
+			excluded = !p.negate
+		}
+	}
+	return excluded
+}
+
+// parsePattern parses a single line of an ignore file
+func parsePattern(base string, line string) (pattern, bool) {
+	line = strings.TrimSuffix(line, "\r")
+
+	// Trailing spaces are ignored unless escaped
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+
+	if line == "" || strings.HasPrefix(line, "#") {
+		return pattern{}, false
+	}
+
+	p := pattern{base: base}
+
+	// "!" negates, "\!" and "\#" escape a literal first character
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	// A slash at the start or in the middle anchors the pattern to base
+	if strings.HasPrefix(line, "/") {
+		p.anchored = true
+		line = strings.TrimLeft(line, "/")
+	} else if strings.Contains(line, "/") {
+		p.anchored = true
+	}
+
+	if line == "" {
+		return pattern{}, false
+	}
+
+	p.segments = strings.Split(line, "/")
+	return p, true
+}
+
+// matches reports whether the pattern matches a single path
+func (p pattern) matches(name string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+
+	// Patterns only apply below the directory that defines them
+	rel := name
+	if p.base != "" {
+		if !strings.HasPrefix(name, p.base+"/") {
+			return false
+		}
+		rel = strings.TrimPrefix(name, p.base+"/")
+	}
+
+	parts := strings.Split(rel, "/")
+
+	// Unanchored patterns match the name at any depth
+	if !p.anchored {
+		matched, err := path.Match(p.segments[0], parts[len(parts)-1])
+		return err == nil && matched
+	}
+
+	return matchSegments(p.segments, parts)
+}
+
+// matchSegments matches path segments against pattern segments, where "**"
+// matches zero or more whole segments
+func matchSegments(pat []string, parts []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			// A trailing "**" matches everything inside, but not the
+			// directory itself
+			if len(pat) == 1 {
+				return len(parts) > 0
+			}
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pat[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(parts) == 0 {
+			return false
+		}
+		matched, err := path.Match(pat[0], parts[0])
+		if err != nil || !matched {
+			return false
+		}
+		pat, parts = pat[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
@@ -0,0 +1,164 @@
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		path     string
+		isDir    bool
+		want     bool
+	}{
+		{"glob", []string{"*.log"}, "a.log", false, true},
+		{"glob at any depth", []string{"*.log"}, "dir/a.log", false, true},
+		{"glob mismatch", []string{"*.log"}, "a.txt", false, false},
+		{"single character", []string{"a?c"}, "abc", false, true},
+		{"character class", []string{"[ab].txt"}, "b.txt", false, true},
+		{"character class mismatch", []string{"[ab].txt"}, "c.txt", false, false},
+
+		// A leading or inner slash anchors the pattern
+		{"leading slash", []string{"/build"}, "build", true, true},
+		{"leading slash below root", []string{"/build"}, "src/build", true, false},
+		{"inner slash", []string{"doc/*.txt"}, "doc/a.txt", false, true},
+		{"inner slash does not cross directories", []string{"doc/*.txt"}, "doc/sub/a.txt", false, false},
+		{"inner slash below root", []string{"doc/*.txt"}, "x/doc/a.txt", false, false},
+		{"path below root", []string{"a/b"}, "x/a/b", false, false},
+
+		// A trailing slash only matches directories
+		{"directory only", []string{"build/"}, "build", true, true},
+		{"directory only on file", []string{"build/"}, "build", false, false},
+		{"directory only at any depth", []string{"build/"}, "src/build", true, true},
+		{"inside excluded directory", []string{"build/"}, "build/x.o", false, true},
+
+		{"leading double star", []string{"**/foo"}, "foo", false, true},
+		{"leading double star at depth", []string{"**/foo"}, "a/b/foo", false, true},
+		{"leading double star with path", []string{"**/foo/bar"}, "a/foo/bar", false, true},
+		{"trailing double star", []string{"abc/**"}, "abc/x/y", false, true},
+		{"trailing double star excludes contents only", []string{"abc/**"}, "abc", true, false},
+		{"inner double star matches no directory", []string{"a/**/b"}, "a/b", false, true},
+		{"inner double star matches directories", []string{"a/**/b"}, "a/x/y/b", false, true},
+		{"inner double star is anchored", []string{"a/**/b"}, "b", false, false},
+
+		// The last matching pattern wins
+		{"negation", []string{"*.log", "!keep.log"}, "keep.log", false, false},
+		{"negation keeps others excluded", []string{"*.log", "!keep.log"}, "a.log", false, true},
+		{"negation overridden", []string{"!keep.log", "*.log"}, "keep.log", false, true},
+		{"negation inside excluded directory", []string{"logs/", "!logs/keep.log"}, "logs/keep.log", false, true},
+		{"negation of excluded contents", []string{"logs/*", "!logs/keep.log"}, "logs/keep.log", false, false},
+
+		{"comment", []string{"# comment"}, "# comment", false, false},
+		{"escaped hash", []string{`\#hash`}, "#hash", false, true},
+		{"escaped exclamation mark", []string{`\!important`}, "!important", false, true},
+		{"trailing spaces", []string{"foo  "}, "foo", false, true},
+		{"escaped trailing space", []string{`foo\ `}, "foo ", false, true},
+		{"blank line", []string{""}, "foo", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := New(tt.patterns...).Match(tt.path, tt.isDir); got != tt.want {
+				t.Errorf("patterns %q match %s = %v, want %v", tt.patterns, tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+// writeIgnore writes the ignore file of a directory below root
+func writeIgnore(t *testing.T, root string, dir string, lines ...string) {
+	t.Helper()
+	path := filepath.Join(root, filepath.FromSlash(dir), FileName)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddParents(t *testing.T) {
+	root := t.TempDir()
+	writeIgnore(t, root, "", "*.tmp")
+	writeIgnore(t, root, "a", "!keep.tmp", "/local")
+	writeIgnore(t, root, "a/b", "*.txt")
+
+	m := New()
+	if err := m.AddParents(root, "a/b"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		// Patterns of the root apply everywhere
+		{"a/b/x.tmp", false, true},
+		// Patterns of a parent override those of the root below it
+		{"a/b/keep.tmp", false, false},
+		{"c/keep.tmp", false, true},
+		// Anchored patterns are relative to the directory of their file
+		{"a/local", true, true},
+		{"local", true, false},
+		{"a/b/local", true, false},
+		// The ignore file of the directory itself is not loaded
+		{"a/b/x.txt", false, false},
+	}
+	for _, tt := range tests {
+		if got := m.Match(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("Match(%s) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	if err := m.AddDir(root, "a/b"); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Match("a/b/x.txt", false) {
+		t.Error("patterns of a/b/.nvignore do not apply after AddDir")
+	}
+	if m.Match("a/x.txt", false) {
+		t.Error("patterns of a/b/.nvignore apply outside a/b")
+	}
+}
+
+func TestAddFileMissing(t *testing.T) {
+	m := New()
+	if err := m.AddFile("", filepath.Join(t.TempDir(), FileName)); err != nil {
+		t.Errorf("missing ignore file: %v", err)
+	}
+	if !m.Empty() {
+		t.Error("missing ignore file added patterns")
+	}
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		key     string
+		isDir   bool
+		want    bool
+	}{
+		{"no patterns", nil, nil, "data/a.txt", false, true},
+		{"included", []string{"*.txt"}, nil, "data/a.txt", false, true},
+		{"not included", []string{"*.txt"}, nil, "data/a.bin", false, false},
+		{"directories are kept for includes", []string{"*.txt"}, nil, "data/sub/", true, true},
+		{"excluded", nil, []string{"*.bin"}, "data/a.bin", false, false},
+		{"exclude wins over include", []string{"*.txt"}, []string{"a.*"}, "data/a.txt", false, false},
+		{"relative to prefix", nil, []string{"/a.txt"}, "data/a.txt", false, false},
+		{"anchored below prefix", nil, []string{"/a.txt"}, "data/sub/a.txt", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewFilter("data/", tt.include, tt.exclude)
+			if got := f.Selected(tt.key, tt.isDir); got != tt.want {
+				t.Errorf("Selected(%s) = %v, want %v", tt.key, got, tt.want)
+			}
+		})
+	}
+}
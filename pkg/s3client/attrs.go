@@ -0,0 +1,122 @@
+package s3client
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vngcloud/aiplatform-util/pkg/storage"
+)
+
+// User metadata keys recording the attributes of an uploaded file. They
+// follow s3fs, which mounts the same bucket: mtime in seconds, mode as the
+// decimal st_mode of a regular file or directory, and the numeric owner and
+// group. The modification time keeps nanoseconds after a decimal point, as
+// rclone does. The target of a symbolic link is path-escaped, as metadata
+// values must be ASCII.
+const (
+	mtimeMetaKey = "Mtime"
+	modeMetaKey  = "Mode"
+	uidMetaKey   = "Uid"
+	gidMetaKey   = "Gid"
+	linkMetaKey  = "Symlink-Target"
+)
+
+// File type bits of st_mode
+const (
+	fileTypeMask    = 0o170000
+	regularFileMode = 0o100000
+	directoryMode   = 0o040000
+)
+
+// attrsMetadata adds the metadata recording attrs to meta
+func attrsMetadata(meta map[string]string, attrs *storage.Attrs) {
+	meta[mtimeMetaKey] = formatMtime(attrs.ModTime)
+	if attrs.Link != "" {
+		meta[linkMetaKey] = url.PathEscape(attrs.Link)
+	}
+	if attrs.Mode != 0 {
+		fileType := regularFileMode
+		if attrs.Mode.IsDir() {
+			fileType = directoryMode
+		}
+		meta[modeMetaKey] = strconv.FormatUint(uint64(fileType)|uint64(attrs.Mode.Perm()), 10)
+	}
+	if attrs.UID >= 0 && attrs.GID >= 0 {
+		meta[uidMetaKey] = strconv.Itoa(attrs.UID)
+		meta[gidMetaKey] = strconv.Itoa(attrs.GID)
+	}
+}
+
+// parseAttrs returns the attributes recorded in the user metadata of an
+// object, nil if it was uploaded without them. Malformed values are ignored.
+func parseAttrs(meta map[string]string) *storage.Attrs {
+	attrs := &storage.Attrs{UID: -1, GID: -1}
+	found := false
+
+	if value, ok := meta[mtimeMetaKey]; ok {
+		if mtime, ok := parseMtime(value); ok {
+			attrs.ModTime = mtime
+			found = true
+		}
+	}
+	if value, ok := meta[modeMetaKey]; ok {
+		if mode, err := strconv.ParseUint(value, 10, 32); err == nil {
+			attrs.Mode = os.FileMode(mode).Perm()
+			if mode&fileTypeMask == directoryMode {
+				attrs.Mode |= os.ModeDir
+			}
+			found = true
+		}
+	}
+	if value, ok := meta[linkMetaKey]; ok {
+		if target, err := url.PathUnescape(value); err == nil && target != "" {
+			attrs.Link = target
+			found = true
+		}
+	}
+	uid, uidErr := strconv.Atoi(meta[uidMetaKey])
+	gid, gidErr := strconv.Atoi(meta[gidMetaKey])
+	if uidErr == nil && gidErr == nil && uid >= 0 && gid >= 0 {
+		attrs.UID, attrs.GID = uid, gid
+		found = true
+	}
+
+	if !found {
+		return nil
+	}
+	return attrs
+}
+
+// formatMtime formats a modification time as seconds since the epoch,
+// with nanoseconds after a decimal point if it has any
+func formatMtime(t time.Time) string {
+	if t.Nanosecond() == 0 {
+		return strconv.FormatInt(t.Unix(), 10)
+	}
+	return strings.TrimRight(fmt.Sprintf("%d.%09d", t.Unix(), t.Nanosecond()), "0")
+}
+
+// parseMtime parses a modification time written by formatMtime
+func parseMtime(value string) (time.Time, bool) {
+	secondsStr, fraction, _ := strings.Cut(value, ".")
+	seconds, err := strconv.ParseInt(secondsStr, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	var nanos int64
+	if fraction != "" {
+		if len(fraction) > 9 {
+			fraction = fraction[:9]
+		}
+		nanos, err = strconv.ParseInt(fraction+strings.Repeat("0", 9-len(fraction)), 10, 64)
+		if err != nil || nanos < 0 {
+			return time.Time{}, false
+		}
+	}
+	return time.Unix(seconds, nanos), true
+}
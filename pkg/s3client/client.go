@@ -1,74 +1,99 @@
 package s3client
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net/textproto"
 	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 	"github.com/vngcloud/aiplatform-util/pkg/config"
+	"github.com/vngcloud/aiplatform-util/pkg/storage"
 )
 
-// Client wraps MinIO client for S3 operations
+// partSizeMetaKey is the user metadata key recording the multipart part
+// size used by Put, so that multipart ETags can be reproduced locally
+const partSizeMetaKey = "Part-Size"
+
+// Client wraps MinIO client for S3 operations and implements storage.Storage
+// for the configured bucket
 type Client struct {
 	cfg         *config.Config
 	minioClient *minio.Client
-}
+	download    DownloadOptions
 
-// S3Object represents a file in S3
-type S3Object struct {
-	Key          string
-	Size         int64
-	LastModified time.Time
-	ETag         string
+	// uploadLimit and downloadLimit cap the combined throughput of all
+	// transfers in each direction, nil for no limit
+	uploadLimit   *storage.Limiter
+	downloadLimit *storage.Limiter
+
+	// log receives informational and warning messages
+	log io.Writer
 }
 
+var (
+	_ storage.Storage = (*Client)(nil)
+	_ storage.Uploads = (*Client)(nil)
+)
+
 // Bucket represents an S3 bucket
 type Bucket struct {
 	Name         string
 	CreationDate time.Time
 }
 
-// ProgressReader wraps an io.Reader and reports progress
+// ProgressReader wraps an io.Reader and reports progress.
+// Progress may also be fed from several readers at once, e.g. by the
+// chunks of a parallel download.
 type ProgressReader struct {
 	reader       io.Reader
 	total        int64
 	current      int64
 	key          string
 	lastReported int64
+	report       storage.ProgressFunc
+
+	mu sync.Mutex
 }
 
-// NewProgressReader creates a new progress reader
-func NewProgressReader(reader io.Reader, total int64, key string) *ProgressReader {
+// NewProgressReader creates a new progress reader reporting to report,
+// which may be nil
+func NewProgressReader(reader io.Reader, total int64, key string, report storage.ProgressFunc) *ProgressReader {
 	return &ProgressReader{
 		reader: reader,
 		total:  total,
 		key:    key,
+		report: report,
 	}
 }
 
 // Read implements io.Reader and reports progress
 func (pr *ProgressReader) Read(p []byte) (int, error) {
 	n, err := pr.reader.Read(p)
-	pr.current += int64(n)
+	pr.add(int64(n), err == io.EOF)
+	return n, err
+}
 
-	// Report progress every 10MB or at completion
-	if pr.current-pr.lastReported >= 10*1024*1024 || err == io.EOF {
+// add records n more bytes and reports progress every 10MB or at completion
+func (pr *ProgressReader) add(n int64, done bool) {
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+
+	pr.current += n
+	if pr.current-pr.lastReported >= 10*1024*1024 || done {
 		pr.lastReported = pr.current
-		percent := float64(pr.current) / float64(pr.total) * 100
-		fmt.Printf("  Progress: %s - %.2f%% (%s / %s)\n",
-			pr.key,
-			percent,
-			formatSize(pr.current),
-			formatSize(pr.total))
+		if pr.report != nil {
+			pr.report(pr.key, pr.current, pr.total)
+		}
 	}
-
-	return n, err
 }
 
 // New creates a new S3 client using MinIO SDK
@@ -80,11 +105,13 @@ func New(cfg *config.Config) (*Client, error) {
 	// Determine if using SSL
 	useSSL := strings.HasPrefix(cfg.Endpoint, "https://")
 
-	// Initialize MinIO client
+	// Initialize MinIO client. Requests are attempted once, failures are
+	// retried by storage.WithRetry so that the retry policy is configurable.
 	minioClient, err := minio.New(endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(cfg.AccessKeyID, cfg.SecretAccessKey, ""),
-		Secure: useSSL,
-		Region: "hcm04", // Default region for VNG Cloud
+		Creds:      credentials.NewStaticV4(cfg.AccessKeyID, cfg.SecretAccessKey, ""),
+		Secure:     useSSL,
+		Region:     "hcm04", // Default region for VNG Cloud
+		MaxRetries: 1,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create MinIO client: %w", err)
@@ -93,124 +120,136 @@ func New(cfg *config.Config) (*Client, error) {
 	return &Client{
 		cfg:         cfg,
 		minioClient: minioClient,
+		download:    DefaultDownloadOptions,
+		log:         io.Discard,
 	}, nil
 }
 
-// ListObjects lists all objects in the bucket with optional prefix filter
-func (c *Client) ListObjects(ctx context.Context, prefix string, recursive bool) ([]S3Object, error) {
-	var objects []S3Object
+// SetDownloadOptions changes how subsequent downloads fetch objects
+func (c *Client) SetDownloadOptions(opts DownloadOptions) {
+	c.download = opts
+}
 
-	// Create list options
-	opts := minio.ListObjectsOptions{
-		Prefix:    prefix,
-		Recursive: recursive,
+// SetBandwidthLimit makes subsequent uploads and downloads share the given
+// limiters. Either may be nil to leave that direction unlimited.
+func (c *Client) SetBandwidthLimit(upload *storage.Limiter, download *storage.Limiter) {
+	c.uploadLimit = upload
+	c.downloadLimit = download
+}
+
+// SetLogOutput changes where informational and warning messages, such as
+// resumed downloads, are written. They are discarded by default.
+func (c *Client) SetLogOutput(w io.Writer) {
+	c.log = w
+}
+
+// List lists all objects in the bucket with optional prefix filter
+func (c *Client) List(ctx context.Context, prefix string, recursive bool) ([]storage.Object, error) {
+	var objects []storage.Object
+	err := c.ListEach(ctx, prefix, recursive, "", func(obj storage.Object) error {
+		objects = append(objects, obj)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return objects, nil
+}
+
+// ListEach streams the objects whose key starts with prefix to fn in key
+// order, fetching them page by page as fn consumes them
+func (c *Client) ListEach(ctx context.Context, prefix string, recursive bool, startAfter string, fn func(storage.Object) error) error {
+	// Stop fetching pages once fn fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	// List objects
-	objectCh := c.minioClient.ListObjects(ctx, c.cfg.BucketName, opts)
-	for object := range objectCh {
+	// Servers that support it include the user metadata of each object,
+	// which saves comparing files from a Stat per object. Others ignore
+	// the request and list objects without it.
+	opts := minio.ListObjectsOptions{
+		Prefix:       prefix,
+		Recursive:    recursive,
+		StartAfter:   startAfter,
+		WithMetadata: true,
+	}
+	for object := range c.minioClient.ListObjects(ctx, c.cfg.BucketName, opts) {
 		if object.Err != nil {
-			return nil, fmt.Errorf("error listing objects: %w", object.Err)
+			return transient(fmt.Errorf("error listing objects: %w", object.Err))
 		}
 
-		objects = append(objects, S3Object{
+		listed := storage.Object{
 			Key:          object.Key,
 			Size:         object.Size,
 			LastModified: object.LastModified,
 			ETag:         strings.Trim(object.ETag, "\""),
-		})
+		}
+		if object.UserMetadata != nil {
+			meta := listedMetadata(object.UserMetadata)
+			listed.PartSize = parsePartSize(meta)
+			listed.Attrs = parseAttrs(meta)
+			listed.HasMetadata = true
+		}
+		err := fn(listed)
+		if err != nil {
+			return err
+		}
 	}
-
-	return objects, nil
+	return nil
 }
 
-// DownloadFile downloads a single file from S3 to local path
-func (c *Client) DownloadFile(ctx context.Context, key string, localPath string) error {
-	// Create directory if it doesn't exist
-	dir := filepath.Dir(localPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("failed to create directory %s: %w", dir, err)
-	}
-
-	// Get object info for progress tracking
-	objInfo, err := c.minioClient.StatObject(ctx, c.cfg.BucketName, key, minio.StatObjectOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to stat object %s: %w", key, err)
-	}
-
-	// Download object
-	object, err := c.minioClient.GetObject(ctx, c.cfg.BucketName, key, minio.GetObjectOptions{})
+// Put uploads a single file from local path to S3 with progress tracking
+// and returns the uploaded object. The file's modification time, mode and
+// owner are recorded in the object metadata. Symbolic links and directories
+// are uploaded as empty objects recording the link target or directory mode.
+func (c *Client) Put(ctx context.Context, localPath string, key string) (*storage.Object, error) {
+	// Get file info without following a symbolic link
+	fileInfo, err := os.Lstat(localPath)
 	if err != nil {
-		return fmt.Errorf("failed to get object %s: %w", key, err)
+		return nil, fmt.Errorf("failed to stat local file %s: %w", localPath, err)
 	}
-	defer object.Close()
-
-	// Create local file
-	localFile, err := os.Create(localPath)
-	if err != nil {
-		return fmt.Errorf("failed to create local file %s: %w", localPath, err)
-	}
-	defer localFile.Close()
-
-	// Wrap reader with progress tracking for large files (> 10MB)
-	var reader io.Reader = object
-	if objInfo.Size > 10*1024*1024 {
-		reader = NewProgressReader(object, objInfo.Size, key)
-	}
-
-	// Copy with progress
-	written, err := io.Copy(localFile, reader)
+	attrs, err := storage.ReadAttrs(localPath, fileInfo)
 	if err != nil {
-		return fmt.Errorf("failed to download %s: %w", key, err)
+		return nil, err
 	}
-
-	// Set modification time to match S3 object
-	if err := os.Chtimes(localPath, objInfo.LastModified, objInfo.LastModified); err != nil {
-		// Non-fatal error, just log
-		fmt.Printf("  Warning: failed to set modification time for %s: %v\n", localPath, err)
-	}
-
-	if written != objInfo.Size {
-		return fmt.Errorf("size mismatch for %s: expected %d, got %d", key, objInfo.Size, written)
-	}
-
-	return nil
-}
-
-// UploadFile uploads a single file from local path to S3 with progress tracking
-func (c *Client) UploadFile(ctx context.Context, localPath string, key string) error {
-	// Get file info
-	fileInfo, err := os.Stat(localPath)
-	if err != nil {
-		return fmt.Errorf("failed to stat local file %s: %w", localPath, err)
+	if attrs.Link != "" || fileInfo.IsDir() {
+		return c.putEmpty(ctx, key, attrs)
 	}
 
 	// Open local file
 	file, err := os.Open(localPath)
 	if err != nil {
-		return fmt.Errorf("failed to open local file %s: %w", localPath, err)
+		return nil, fmt.Errorf("failed to open local file %s: %w", localPath, err)
 	}
 	defer file.Close()
 
 	// Wrap reader with progress tracking for large files (> 10MB)
 	var reader io.Reader = file
 	if fileInfo.Size() > 10*1024*1024 {
-		reader = NewProgressReader(file, fileInfo.Size(), key)
+		reader = NewProgressReader(file, fileInfo.Size(), key, storage.ProgressFromContext(ctx))
 	}
+	reader = c.uploadLimit.Reader(ctx, reader)
 
 	// Determine content type
 	contentType := "application/octet-stream"
 
 	// Upload options with 10 concurrent parts for multipart uploads
-	// PartSize: 0 lets MinIO SDK auto-calculate optimal part size based on file size
+	// The part size is pinned and recorded in metadata so the resulting
+	// multipart ETag can be recomputed from the local file later
+	partSize := storage.PartSize(fileInfo.Size())
 	uploadOpts := minio.PutObjectOptions{
 		ContentType:    contentType,
-		NumThreads:     10,    // 10 concurrent uploads for maximum throughput
-		PartSize:       0,     // Auto-calculate optimal part size (handles files up to 5TB)
-		SendContentMd5: false, // Disable MD5 for faster uploads
+		NumThreads:     10,               // 10 concurrent uploads for maximum throughput
+		PartSize:       uint64(partSize), // Optimal part size (handles files up to 5TB), 0 for single-part
+		SendContentMd5: false,            // Disable MD5 for faster uploads
+		UserMetadata:   map[string]string{},
+	}
+	attrsMetadata(uploadOpts.UserMetadata, attrs)
+	if partSize > 0 {
+		uploadOpts.UserMetadata[partSizeMetaKey] = strconv.FormatInt(partSize, 10)
 	}
 
 	// Upload file
+	started := time.Now()
 	info, err := c.minioClient.PutObject(
 		ctx,
 		c.cfg.BucketName,
@@ -220,40 +259,239 @@ func (c *Client) UploadFile(ctx context.Context, localPath string, key string) e
 		uploadOpts,
 	)
 	if err != nil {
-		return fmt.Errorf("failed to upload %s: %w", key, err)
+		if partSize > 0 {
+			c.abortUploads(ctx, key, started)
+		}
+		return nil, transient(fmt.Errorf("failed to upload %s: %w", key, err))
 	}
 
 	if info.Size != fileInfo.Size() {
-		return fmt.Errorf("size mismatch for %s: expected %d, got %d", key, fileInfo.Size(), info.Size)
+		return nil, fmt.Errorf("size mismatch for %s: expected %d, got %d", key, fileInfo.Size(), info.Size)
+	}
+
+	return &storage.Object{
+		Key:          key,
+		Size:         info.Size,
+		LastModified: info.LastModified,
+		ETag:         strings.Trim(info.ETag, "\""),
+		PartSize:     partSize,
+		Attrs:        attrs,
+		HasMetadata:  true,
+	}, nil
+}
+
+// putEmpty uploads an object without content carrying only the metadata
+// of attrs, such as a symbolic link or the marker of a directory
+func (c *Client) putEmpty(ctx context.Context, key string, attrs *storage.Attrs) (*storage.Object, error) {
+	// Directory markers use the content type s3fs gives them
+	contentType := "application/octet-stream"
+	if attrs.Mode.IsDir() {
+		contentType = "application/x-directory"
+	}
+	uploadOpts := minio.PutObjectOptions{
+		ContentType:  contentType,
+		UserMetadata: map[string]string{},
+	}
+	attrsMetadata(uploadOpts.UserMetadata, attrs)
+
+	info, err := c.minioClient.PutObject(ctx, c.cfg.BucketName, key, bytes.NewReader(nil), 0, uploadOpts)
+	if err != nil {
+		return nil, transient(fmt.Errorf("failed to upload %s: %w", key, err))
+	}
+
+	return &storage.Object{
+		Key:          key,
+		LastModified: info.LastModified,
+		ETag:         strings.Trim(info.ETag, "\""),
+		Attrs:        attrs,
+		HasMetadata:  true,
+	}, nil
+}
+
+// abortTimeout bounds the cleanup of a failed multipart upload
+const abortTimeout = 30 * time.Second
+
+// abortUploads aborts the incomplete multipart uploads of key initiated
+// since started, such as the one left behind by a failed or cancelled Put,
+// so that their parts stop taking up space in the bucket. The cleanup runs
+// even if ctx was cancelled.
+func (c *Client) abortUploads(ctx context.Context, key string, started time.Time) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), abortTimeout)
+	defer cancel()
+
+	// Allow for clock skew between this machine and the server
+	since := started.Add(-time.Minute)
+
+	core := minio.Core{Client: c.minioClient}
+	for upload := range c.minioClient.ListIncompleteUploads(ctx, c.cfg.BucketName, key, false) {
+		if upload.Err != nil {
+			fmt.Fprintf(c.log, "  Warning: failed to list incomplete uploads of %s: %v\n", key, upload.Err)
+			return
+		}
+		if upload.Key != key || upload.Initiated.Before(since) {
+			continue
+		}
+		if err := core.AbortMultipartUpload(ctx, c.cfg.BucketName, key, upload.UploadID); err != nil {
+			fmt.Fprintf(c.log, "  Warning: failed to abort incomplete upload of %s: %v\n", key, err)
+		}
+	}
+}
+
+// ListUploads lists the incomplete multipart uploads whose key starts with
+// prefix, sorted by key then initiation time, with the size of the parts
+// uploaded so far
+func (c *Client) ListUploads(ctx context.Context, prefix string) ([]storage.Upload, error) {
+	var uploads []storage.Upload
+	for upload := range c.minioClient.ListIncompleteUploads(ctx, c.cfg.BucketName, prefix, true) {
+		if upload.Err != nil {
+			return nil, transient(fmt.Errorf("failed to list incomplete uploads: %w", upload.Err))
+		}
+		size, err := c.uploadedSize(ctx, upload.Key, upload.UploadID)
+		var resp minio.ErrorResponse
+		if errors.As(err, &resp) && resp.Code == "NoSuchUpload" {
+			// Completed or aborted since it was listed
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, storage.Upload{
+			Key:       upload.Key,
+			ID:        upload.UploadID,
+			Initiated: upload.Initiated,
+			Size:      size,
+		})
+	}
+	return uploads, nil
+}
+
+// uploadedSize returns the total size of the parts of an incomplete upload
+func (c *Client) uploadedSize(ctx context.Context, key string, uploadID string) (int64, error) {
+	core := minio.Core{Client: c.minioClient}
+	var size int64
+	marker := 0
+	for {
+		result, err := core.ListObjectParts(ctx, c.cfg.BucketName, key, uploadID, marker, 0)
+		if err != nil {
+			return 0, transient(fmt.Errorf("failed to list parts of %s: %w", key, err))
+		}
+		for _, part := range result.ObjectParts {
+			size += part.Size
+		}
+		if !result.IsTruncated {
+			return size, nil
+		}
+		marker = result.NextPartNumberMarker
 	}
+}
 
+// AbortUpload aborts an incomplete multipart upload and deletes its parts
+func (c *Client) AbortUpload(ctx context.Context, upload storage.Upload) error {
+	core := minio.Core{Client: c.minioClient}
+	if err := core.AbortMultipartUpload(ctx, c.cfg.BucketName, upload.Key, upload.ID); err != nil {
+		return transient(fmt.Errorf("failed to abort upload of %s: %w", upload.Key, err))
+	}
 	return nil
 }
 
-// DeleteObject deletes a single object from S3
-func (c *Client) DeleteObject(ctx context.Context, key string) error {
+// Delete deletes a single object from S3
+func (c *Client) Delete(ctx context.Context, key string) error {
 	err := c.minioClient.RemoveObject(ctx, c.cfg.BucketName, key, minio.RemoveObjectOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to delete %s: %w", key, err)
+		return transient(fmt.Errorf("failed to delete %s: %w", key, err))
+	}
+	return nil
+}
+
+// Copy copies an object within the bucket on the server side, keeping
+// its metadata. Objects larger than 5GiB are copied in parts.
+func (c *Client) Copy(ctx context.Context, srcKey string, dstKey string) error {
+	src := minio.CopySrcOptions{Bucket: c.cfg.BucketName, Object: srcKey}
+	dst := minio.CopyDestOptions{Bucket: c.cfg.BucketName, Object: dstKey}
+	if _, err := c.minioClient.ComposeObject(ctx, dst, src); err != nil {
+		return transient(fmt.Errorf("failed to copy %s to %s: %w", srcKey, dstKey, err))
 	}
 	return nil
 }
 
-// GetObjectMetadata gets metadata for a single object without downloading it
-func (c *Client) GetObjectMetadata(ctx context.Context, key string) (*S3Object, error) {
+// Stat gets metadata for a single object without downloading it
+func (c *Client) Stat(ctx context.Context, key string) (*storage.Object, error) {
 	objInfo, err := c.minioClient.StatObject(ctx, c.cfg.BucketName, key, minio.StatObjectOptions{})
+	if IsNotFound(err) {
+		return nil, fmt.Errorf("failed to get metadata for %s: %w", key, storage.ErrNotFound)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to get metadata for %s: %w", key, err)
+		return nil, transient(fmt.Errorf("failed to get metadata for %s: %w", key, err))
 	}
 
-	return &S3Object{
+	return &storage.Object{
 		Key:          key,
 		Size:         objInfo.Size,
 		LastModified: objInfo.LastModified,
 		ETag:         strings.Trim(objInfo.ETag, "\""),
+		PartSize:     parsePartSize(objInfo.UserMetadata),
+		Attrs:        parseAttrs(objInfo.UserMetadata),
+		HasMetadata:  true,
 	}, nil
 }
 
+// parsePartSize returns the part size recorded in the user metadata of an
+// object, 0 for objects uploaded in a single part or by other tools
+func parsePartSize(meta map[string]string) int64 {
+	partSize, _ := strconv.ParseInt(meta[partSizeMetaKey], 10, 64)
+	return partSize
+}
+
+// listedMetadata returns the user metadata included in a listing keyed as
+// in StatObject results. Listings use the full header names, such as
+// X-Amz-Meta-Mtime, next to system metadata like the content type.
+func listedMetadata(listed map[string]string) map[string]string {
+	const userPrefix = "x-amz-meta-"
+	meta := make(map[string]string, len(listed))
+	for key, value := range listed {
+		if len(key) > len(userPrefix) && strings.EqualFold(key[:len(userPrefix)], userPrefix) {
+			meta[textproto.CanonicalMIMEHeaderKey(key[len(userPrefix):])] = value
+		}
+	}
+	return meta
+}
+
+// IsNotFound reports whether an error means the object does not exist
+func IsNotFound(err error) bool {
+	if storage.IsNotFound(err) {
+		return true
+	}
+	var resp minio.ErrorResponse
+	if errors.As(err, &resp) {
+		return resp.Code == "NoSuchKey" || resp.StatusCode == 404
+	}
+	return false
+}
+
+// transientCodes are S3 error codes of failures that go away on their own
+var transientCodes = map[string]bool{
+	"InternalError":        true,
+	"RequestTimeout":       true,
+	"ServiceUnavailable":   true,
+	"SlowDown":             true,
+	"Throttling":           true,
+	"RequestLimitExceeded": true,
+}
+
+// transient marks err as retryable when it wraps an S3 error response
+// reporting throttling or a server-side failure. Client errors such as
+// access denied or a missing object are permanent.
+func transient(err error) error {
+	var resp minio.ErrorResponse
+	if !errors.As(err, &resp) {
+		return err
+	}
+	if transientCodes[resp.Code] || resp.StatusCode == 408 || resp.StatusCode == 429 || resp.StatusCode >= 500 {
+		return storage.Retryable(err)
+	}
+	return err
+}
+
 // ListBuckets lists all available S3 buckets
 func (c *Client) ListBuckets(ctx context.Context) ([]Bucket, error) {
 	buckets, err := c.minioClient.ListBuckets(ctx)
@@ -271,30 +509,3 @@ func (c *Client) ListBuckets(ctx context.Context) ([]Bucket, error) {
 
 	return result, nil
 }
-
-// formatSize formats bytes as human-readable string
-func formatSize(bytes int64) string {
-	const (
-		KB = 1024
-		MB = KB * 1024
-		GB = MB * 1024
-		TB = GB * 1024
-	)
-
-	if bytes == 0 {
-		return "0 B"
-	}
-
-	switch {
-	case bytes < KB:
-		return fmt.Sprintf("%d B", bytes)
-	case bytes < MB:
-		return fmt.Sprintf("%.2f KB", float64(bytes)/KB)
-	case bytes < GB:
-		return fmt.Sprintf("%.2f MB", float64(bytes)/MB)
-	case bytes < TB:
-		return fmt.Sprintf("%.2f GB", float64(bytes)/GB)
-	default:
-		return fmt.Sprintf("%.2f TB", float64(bytes)/TB)
-	}
-}
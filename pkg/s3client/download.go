@@ -0,0 +1,484 @@
+package s3client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/vngcloud/aiplatform-util/pkg/config"
+	"github.com/vngcloud/aiplatform-util/pkg/storage"
+)
+
+// checkpointInterval is how many bytes are written between sidecar updates
+const checkpointInterval = 8 * 1024 * 1024
+
+// DownloadOptions controls how objects are downloaded
+type DownloadOptions struct {
+	// Threads is the number of concurrent range requests per object,
+	// 1 downloads every object over a single stream
+	Threads int
+
+	// ChunkSize is the size of each range request, objects larger than
+	// one chunk are downloaded in parallel
+	ChunkSize int64
+
+	// Owner restores the owner and group recorded at upload, which
+	// usually requires running as root
+	Owner bool
+}
+
+// DefaultDownloadOptions are used by clients created with New
+var DefaultDownloadOptions = DownloadOptions{
+	Threads:   8,
+	ChunkSize: 64 * 1024 * 1024,
+}
+
+// partialDownload is the sidecar stored next to a .part file so an
+// interrupted download can be resumed with ranged GETs
+type partialDownload struct {
+	ETag string `json:"etag"`
+	Size int64  `json:"size"`
+
+	// Received is the length of the contiguous prefix written so far
+	Received int64 `json:"received"`
+
+	// ChunkSize and Chunks track completed chunks of a parallel download
+	ChunkSize int64  `json:"chunk_size,omitempty"`
+	Chunks    []bool `json:"chunks,omitempty"`
+}
+
+// Get downloads a single file from S3 to local path.
+// Data is written to a .part file next to the destination, which is synced,
+// verified and renamed over the destination only once complete, so a failed
+// download never leaves a partially written file at localPath. An
+// interrupted download of the same object version is resumed from where it
+// stopped the next time the file is downloaded.
+func (c *Client) Get(ctx context.Context, key string, localPath string) (err error) {
+	defer func() { err = transient(err) }()
+
+	// Create directory if it doesn't exist
+	dir := filepath.Dir(localPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+
+	// Get object info for progress tracking
+	objInfo, err := c.minioClient.StatObject(ctx, c.cfg.BucketName, key, minio.StatObjectOptions{})
+	if IsNotFound(err) {
+		return fmt.Errorf("failed to stat object %s: %w", key, storage.ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat object %s: %w", key, err)
+	}
+	etag := strings.Trim(objInfo.ETag, "\"")
+
+	// Restore the attributes of the uploaded file, objects uploaded
+	// without them get the modification time of the object
+	attrs := parseAttrs(objInfo.UserMetadata)
+	if attrs == nil {
+		attrs = &storage.Attrs{ModTime: objInfo.LastModified, UID: -1, GID: -1}
+	} else if attrs.ModTime.IsZero() {
+		attrs.ModTime = objInfo.LastModified
+	}
+
+	// Links and directory markers have no content to download
+	switch {
+	case attrs.Link != "":
+		if err := storage.WriteLink(localPath, attrs.Link); err != nil {
+			return err
+		}
+		c.restoreAttrs(localPath, attrs)
+		return nil
+	case strings.HasSuffix(key, "/"):
+		if err := os.MkdirAll(localPath, 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", localPath, err)
+		}
+		c.restoreAttrs(localPath, attrs)
+		return nil
+	}
+
+	partPath := localPath + storage.PartialSuffix
+	metaPath := partPath + storage.PartialMetaSuffix
+
+	// Resume only if the previous attempt was for the same object version
+	var previous *partialDownload
+	if partial, err := readPartial(metaPath); err == nil && partial.ETag == etag && partial.Size == objInfo.Size {
+		if info, err := os.Stat(partPath); err == nil && info.Size() >= partial.Received {
+			previous = partial
+		}
+	}
+
+	partFile, err := os.OpenFile(partPath, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create local file %s: %w", partPath, err)
+	}
+	defer partFile.Close()
+
+	// Large objects are fetched as concurrent ranges, others over one stream
+	opts := c.download
+	if opts.Threads > 1 && opts.ChunkSize > 0 && objInfo.Size > opts.ChunkSize {
+		err = c.downloadChunks(ctx, key, partFile, metaPath, newChunkedPartial(etag, objInfo.Size, opts.ChunkSize, previous), opts.Threads)
+	} else {
+		err = c.downloadStream(ctx, key, partFile, metaPath, newStreamPartial(etag, objInfo.Size, previous))
+	}
+	if err != nil {
+		return err
+	}
+
+	// Flush the data to disk before it becomes visible at the final path
+	if err := partFile.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", partPath, err)
+	}
+	if err := partFile.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", partPath, err)
+	}
+
+	// Verify the content against the ETag, discarding a corrupt download
+	// entirely so the next attempt starts from scratch
+	if err := verifyDownload(partPath, objInfo); err != nil {
+		os.Remove(partPath)
+		os.Remove(metaPath)
+		return fmt.Errorf("failed to verify %s: %w", key, err)
+	}
+
+	c.restoreAttrs(partPath, attrs)
+
+	// Atomically replace the destination so readers never observe a
+	// partially written file
+	if err := os.Rename(partPath, localPath); err != nil {
+		return fmt.Errorf("failed to rename %s to %s: %w", partPath, localPath, err)
+	}
+	os.Remove(metaPath)
+	storage.SyncDir(dir)
+
+	return nil
+}
+
+// restoreAttrs restores the attributes of a downloaded file, logging
+// failures as they are not fatal
+func (c *Client) restoreAttrs(path string, attrs *storage.Attrs) {
+	if err := storage.RestoreAttrs(path, attrs, c.download.Owner); err != nil {
+		fmt.Fprintf(c.log, "  Warning: failed to restore attributes of %s: %v\n", strings.TrimSuffix(path, storage.PartialSuffix), err)
+	}
+}
+
+// verifyDownload checks that a downloaded file matches the object's ETag.
+// Objects whose ETag is not derived from their content, such as encrypted
+// objects or multipart uploads with an unknown part size, are not verified.
+func verifyDownload(localPath string, objInfo minio.ObjectInfo) error {
+	if objInfo.Metadata.Get("X-Amz-Server-Side-Encryption") != "" ||
+		objInfo.Metadata.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm") != "" {
+		return nil
+	}
+
+	expected := strings.Trim(objInfo.ETag, "\"")
+	partSize, ok := storage.ETagPartSize(expected, objInfo.Size, parsePartSize(objInfo.UserMetadata))
+	if !ok {
+		return nil
+	}
+
+	actual, err := storage.FileETag(localPath, partSize)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("ETag mismatch: expected %s, got %s", expected, actual)
+	}
+	return nil
+}
+
+// newStreamPartial returns the progress of a single-stream download,
+// continuing from the contiguous prefix of a previous attempt
+func newStreamPartial(etag string, size int64, previous *partialDownload) *partialDownload {
+	partial := &partialDownload{ETag: etag, Size: size}
+	if previous != nil {
+		partial.Received = previous.Received
+	}
+	return partial
+}
+
+// newChunkedPartial returns the progress of a parallel download, reusing
+// the chunks completed by a previous attempt
+func newChunkedPartial(etag string, size int64, chunkSize int64, previous *partialDownload) *partialDownload {
+	count := (size + chunkSize - 1) / chunkSize
+	partial := &partialDownload{ETag: etag, Size: size, ChunkSize: chunkSize, Chunks: make([]bool, count)}
+	if previous == nil {
+		return partial
+	}
+
+	for i := range partial.Chunks {
+		end := min(int64(i+1)*chunkSize, size)
+		switch {
+		case end <= previous.Received:
+			// Covered by the contiguous prefix of any earlier attempt
+			partial.Chunks[i] = true
+		case previous.ChunkSize == chunkSize && i < len(previous.Chunks):
+			partial.Chunks[i] = previous.Chunks[i]
+		}
+	}
+	partial.updateReceived()
+	return partial
+}
+
+// updateReceived recomputes the contiguous prefix from completed chunks
+func (p *partialDownload) updateReceived() {
+	p.Received = 0
+	for i, done := range p.Chunks {
+		if !done {
+			return
+		}
+		p.Received = min(int64(i+1)*p.ChunkSize, p.Size)
+	}
+}
+
+// downloadStream fetches the object over a single stream, resuming after
+// the bytes already received
+func (c *Client) downloadStream(ctx context.Context, key string, partFile *os.File, metaPath string, partial *partialDownload) error {
+	offset := partial.Received
+
+	// Discard anything past the last checkpoint
+	if err := partFile.Truncate(offset); err != nil {
+		return fmt.Errorf("failed to truncate %s: %w", partFile.Name(), err)
+	}
+	if _, err := partFile.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek %s: %w", partFile.Name(), err)
+	}
+	if err := writePartial(metaPath, partial); err != nil {
+		return err
+	}
+
+	// Download the remaining bytes, failing if the object changed meanwhile
+	getOpts := minio.GetObjectOptions{}
+	if err := getOpts.SetMatchETag(partial.ETag); err != nil {
+		return fmt.Errorf("failed to set ETag condition: %w", err)
+	}
+	if offset > 0 {
+		fmt.Fprintf(c.log, "  Resuming: %s from %s\n", key, config.FormatSize(offset))
+		if err := getOpts.SetRange(offset, 0); err != nil {
+			return fmt.Errorf("failed to set range: %w", err)
+		}
+	}
+
+	written := offset
+	if offset < partial.Size {
+		object, err := c.minioClient.GetObject(ctx, c.cfg.BucketName, key, getOpts)
+		if err != nil {
+			return fmt.Errorf("failed to get object %s: %w", key, err)
+		}
+		defer object.Close()
+
+		// Wrap reader with progress tracking for large files (> 10MB)
+		var reader io.Reader = object
+		if partial.Size > 10*1024*1024 {
+			progress := NewProgressReader(object, partial.Size, key, storage.ProgressFromContext(ctx))
+			progress.current = offset
+			progress.lastReported = offset
+			reader = progress
+		}
+		reader = c.downloadLimit.Reader(ctx, reader)
+
+		// Copy with periodic checkpoints so an interruption can be resumed
+		writer := &checkpointWriter{file: partFile, metaPath: metaPath, partial: partial}
+		n, err := io.Copy(writer, reader)
+		written += n
+		if err != nil {
+			return fmt.Errorf("failed to download %s: %w", key, err)
+		}
+	}
+
+	if written != partial.Size {
+		return fmt.Errorf("size mismatch for %s: expected %d, got %d", key, partial.Size, written)
+	}
+	return nil
+}
+
+// downloadChunks fetches the missing chunks of the object with concurrent
+// range requests, writing each at its offset in a preallocated file
+func (c *Client) downloadChunks(ctx context.Context, key string, partFile *os.File, metaPath string, partial *partialDownload, threads int) error {
+	// Preallocate the file so chunks can be written at any offset
+	if err := partFile.Truncate(partial.Size); err != nil {
+		return fmt.Errorf("failed to allocate %s: %w", partFile.Name(), err)
+	}
+	if err := writePartial(metaPath, partial); err != nil {
+		return err
+	}
+
+	var pending []int
+	var remaining int64
+	for i, done := range partial.Chunks {
+		if !done {
+			pending = append(pending, i)
+			remaining += partial.chunkLength(i)
+		}
+	}
+	if remaining < partial.Size {
+		fmt.Fprintf(c.log, "  Resuming: %s, %s remaining\n", key, config.FormatSize(remaining))
+	}
+
+	progress := NewProgressReader(nil, partial.Size, key, storage.ProgressFromContext(ctx))
+	progress.current = partial.Size - remaining
+	progress.lastReported = progress.current
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	chunks := make(chan int)
+
+	for range min(threads, len(pending)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range chunks {
+				err := c.downloadChunk(ctx, key, partFile, partial, i, progress)
+
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+					}
+					cancel()
+				} else {
+					// Checkpoint every completed chunk
+					partial.Chunks[i] = true
+					partial.updateReceived()
+					if err := writePartial(metaPath, partial); err != nil && firstErr == nil {
+						firstErr = err
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, i := range pending {
+		select {
+		case chunks <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(chunks)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("failed to download %s: %w", key, err)
+	}
+	progress.add(0, true)
+	return nil
+}
+
+// downloadChunk fetches a single chunk with a range request
+func (c *Client) downloadChunk(ctx context.Context, key string, partFile *os.File, partial *partialDownload, i int, progress *ProgressReader) error {
+	start := int64(i) * partial.ChunkSize
+	length := partial.chunkLength(i)
+
+	getOpts := minio.GetObjectOptions{}
+	if err := getOpts.SetMatchETag(partial.ETag); err != nil {
+		return fmt.Errorf("failed to set ETag condition: %w", err)
+	}
+	if err := getOpts.SetRange(start, start+length-1); err != nil {
+		return fmt.Errorf("failed to set range: %w", err)
+	}
+
+	object, err := c.minioClient.GetObject(ctx, c.cfg.BucketName, key, getOpts)
+	if err != nil {
+		return fmt.Errorf("failed to get object %s: %w", key, err)
+	}
+	defer object.Close()
+
+	reader := &chunkReader{reader: c.downloadLimit.Reader(ctx, object), progress: progress}
+	written, err := io.Copy(io.NewOffsetWriter(partFile, start), reader)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", key, err)
+	}
+	if written != length {
+		return fmt.Errorf("size mismatch for %s at offset %d: expected %d, got %d", key, start, length, written)
+	}
+	return nil
+}
+
+// chunkLength returns the length of chunk i, the last chunk may be shorter
+func (p *partialDownload) chunkLength(i int) int64 {
+	start := int64(i) * p.ChunkSize
+	return min(p.ChunkSize, p.Size-start)
+}
+
+// chunkReader reports the bytes read from one chunk to the progress of the
+// whole object
+type chunkReader struct {
+	reader   io.Reader
+	progress *ProgressReader
+}
+
+// Read implements io.Reader and reports progress
+func (r *chunkReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.progress.add(int64(n), false)
+	return n, err
+}
+
+// checkpointWriter writes to a partial file and records the number of bytes
+// received in its sidecar every checkpointInterval bytes
+type checkpointWriter struct {
+	file        *os.File
+	metaPath    string
+	partial     *partialDownload
+	sinceUpdate int64
+}
+
+// Write implements io.Writer and checkpoints progress
+func (w *checkpointWriter) Write(p []byte) (int, error) {
+	n, err := w.file.Write(p)
+	w.partial.Received += int64(n)
+	w.sinceUpdate += int64(n)
+
+	if w.sinceUpdate >= checkpointInterval {
+		w.sinceUpdate = 0
+		if metaErr := writePartial(w.metaPath, w.partial); metaErr != nil && err == nil {
+			err = metaErr
+		}
+	}
+
+	return n, err
+}
+
+// readPartial reads the sidecar of an unfinished download
+func readPartial(metaPath string) (*partialDownload, error) {
+	data, err := os.ReadFile(metaPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var partial partialDownload
+	if err := json.Unmarshal(data, &partial); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", metaPath, err)
+	}
+	return &partial, nil
+}
+
+// writePartial records the progress of an unfinished download
+func writePartial(metaPath string, partial *partialDownload) error {
+	data, err := json.Marshal(partial)
+	if err != nil {
+		return fmt.Errorf("failed to encode download progress: %w", err)
+	}
+	if err := os.WriteFile(metaPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", metaPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package s3client
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vngcloud/aiplatform-util/pkg/s3test"
+)
+
+const mib = 1024 * 1024
+
+// chunkedEnv is a client downloading objects larger than 1MiB in parallel
+// chunks from a fake server holding one such object
+type chunkedEnv struct {
+	server  *s3test.Server
+	client  *Client
+	log     *bytes.Buffer
+	content []byte
+	etag    string
+	path    string
+}
+
+func newChunkedEnv(t *testing.T) *chunkedEnv {
+	t.Helper()
+	server, cfg := s3test.Start(t)
+	client, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetDownloadOptions(DownloadOptions{Threads: 4, ChunkSize: mib})
+	log := &bytes.Buffer{}
+	client.SetLogOutput(log)
+
+	// Every chunk has different content, so misplaced chunks are noticed
+	content := make([]byte, 5*mib+mib/2)
+	for i := range content {
+		content[i] = byte(i / 4096)
+	}
+	server.PutObject(cfg.BucketName, "big.bin", content, time.Now())
+	sum := md5.Sum(content)
+
+	return &chunkedEnv{
+		server:  server,
+		client:  client,
+		log:     log,
+		content: content,
+		etag:    hex.EncodeToString(sum[:]),
+		path:    filepath.Join(cfg.MountPath, "big.bin"),
+	}
+}
+
+// get downloads the object to the workspace
+func (e *chunkedEnv) get() error {
+	return e.client.Get(context.Background(), "big.bin", e.path)
+}
+
+// assertDownloaded checks the downloaded file and that nothing is left of
+// the partial download
+func (e *chunkedEnv) assertDownloaded(t *testing.T) {
+	t.Helper()
+	data, err := os.ReadFile(e.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, e.content) {
+		t.Error("downloaded file has the wrong content")
+	}
+	for _, path := range []string{e.path + ".part", e.path + ".part.json"} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s left behind: %v", filepath.Base(path), err)
+		}
+	}
+}
+
+// writePart writes a partial download whose chunks are marked done as
+// listed, with data in place of the content of the chunks marked true
+func (e *chunkedEnv) writePart(t *testing.T, chunks []bool, data func(i int, chunk []byte) []byte) {
+	t.Helper()
+	part := make([]byte, len(e.content))
+	for i, done := range chunks {
+		if done {
+			start := i * mib
+			end := min(start+mib, len(e.content))
+			copy(part[start:end], data(i, e.content[start:end]))
+		}
+	}
+	if err := os.WriteFile(e.path+".part", part, 0644); err != nil {
+		t.Fatal(err)
+	}
+	partial := &partialDownload{ETag: e.etag, Size: int64(len(e.content)), ChunkSize: mib, Chunks: chunks}
+	partial.updateReceived()
+	if err := writePartial(e.path+".part.json", partial); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetDownloadsChunks(t *testing.T) {
+	e := newChunkedEnv(t)
+	if err := e.get(); err != nil {
+		t.Fatal(err)
+	}
+	e.assertDownloaded(t)
+}
+
+func TestGetResumesChunks(t *testing.T) {
+	e := newChunkedEnv(t)
+	e.writePart(t, []bool{true, true, false, true, false, false}, func(_ int, chunk []byte) []byte { return chunk })
+
+	if err := e.get(); err != nil {
+		t.Fatal(err)
+	}
+	e.assertDownloaded(t)
+
+	// Only the 3 chunks not done are downloaded
+	if !strings.Contains(e.log.String(), "Resuming: big.bin, 2.50 MB remaining") {
+		t.Errorf("log = %q, want the remaining chunks resumed", e.log.String())
+	}
+}
+
+func TestGetKeepsProgressOfFailedChunks(t *testing.T) {
+	e := newChunkedEnv(t)
+	e.server.Inject(s3test.Fault{Method: http.MethodGet, Key: "big.bin", Status: http.StatusInternalServerError, Times: 1})
+
+	if err := e.get(); err == nil {
+		t.Fatal("download with a failing chunk succeeded")
+	}
+	if _, err := os.Stat(e.path); !os.IsNotExist(err) {
+		t.Fatalf("failed download left a file at the destination: %v", err)
+	}
+	partial, err := readPartial(e.path + ".part.json")
+	if err != nil {
+		t.Fatalf("failed download left no progress to resume: %v", err)
+	}
+	if partial.ETag != e.etag || len(partial.Chunks) != 6 {
+		t.Errorf("progress = %+v, want the 6 chunks of the object", partial)
+	}
+
+	if err := e.get(); err != nil {
+		t.Fatal(err)
+	}
+	e.assertDownloaded(t)
+}
+
+func TestGetDiscardsChunksFailingVerification(t *testing.T) {
+	e := newChunkedEnv(t)
+	// A chunk recorded as done holds other data, e.g. after a crash
+	e.writePart(t, []bool{true, false, false, false, false, false}, func(_ int, chunk []byte) []byte {
+		return bytes.Repeat([]byte{0xff}, len(chunk))
+	})
+
+	err := e.get()
+	if err == nil || !strings.Contains(err.Error(), "ETag mismatch") {
+		t.Fatalf("download of corrupt chunks = %v, want an ETag mismatch", err)
+	}
+	if _, err := os.Stat(e.path); !os.IsNotExist(err) {
+		t.Fatalf("corrupt download left a file at the destination: %v", err)
+	}
+
+	// The corrupt progress is discarded, so the next download starts over
+	if err := e.get(); err != nil {
+		t.Fatal(err)
+	}
+	e.assertDownloaded(t)
+}
@@ -0,0 +1,181 @@
+package s3test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fault makes the server misbehave on matching requests
+type Fault struct {
+	// Method matches the HTTP method of requests, empty matches every method
+	Method string
+
+	// Key matches the object key of requests, empty matches every request
+	Key string
+
+	// After is the number of matching requests handled normally before the
+	// fault applies, e.g. 1 to fail the second page of a listing
+	After int
+
+	// Times is the number of requests affected, 0 affects all of them
+	Times int
+
+	// Delay is waited before the request is handled
+	Delay time.Duration
+
+	// Status, when not 0, is returned as an S3 error instead of handling
+	// the request, e.g. 500 or 503
+	Status int
+
+	// Truncate, when greater than 0, closes the connection after this
+	// many bytes of the response body were sent
+	Truncate int64
+
+	// skipped counts the requests handled normally so far
+	skipped int
+
+	// hits counts the requests affected so far
+	hits int
+}
+
+// Inject adds a fault. Faults are matched in the order they were added and
+// the first matching fault applies.
+func (s *Server) Inject(fault Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &fault)
+}
+
+// ClearFaults removes all injected faults
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+// fault returns the fault applying to a request, nil if there is none
+func (s *Server) fault(method string, key string) *Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, fault := range s.faults {
+		if fault.Method != "" && fault.Method != method {
+			continue
+		}
+		if fault.Key != "" && fault.Key != key {
+			continue
+		}
+		if fault.skipped < fault.After {
+			fault.skipped++
+			continue
+		}
+		if fault.Times > 0 && fault.hits >= fault.Times {
+			continue
+		}
+		fault.hits++
+		return fault
+	}
+	return nil
+}
+
+// truncatingWriter aborts a response once its byte budget is exhausted,
+// so the client sees the connection close in the middle of the body
+type truncatingWriter struct {
+	http.ResponseWriter
+	remaining int64
+}
+
+// Write sends at most the remaining budget, then aborts the response
+func (w *truncatingWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) <= w.remaining {
+		w.remaining -= int64(len(p))
+		return w.ResponseWriter.Write(p)
+	}
+
+	w.ResponseWriter.Write(p[:w.remaining])
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	panic(http.ErrAbortHandler)
+}
+
+// chunkedReader decodes a request body sent with the aws-chunked content
+// encoding, where each chunk is preceded by its hexadecimal size and an
+// optional signature, and the last chunk may be followed by trailers
+type chunkedReader struct {
+	r         *bufio.Reader
+	remaining int64
+	done      bool
+}
+
+// newChunkedReader creates a reader decoding the aws-chunked body r
+func newChunkedReader(r io.Reader) *chunkedReader {
+	return &chunkedReader{r: bufio.NewReader(r)}
+}
+
+// Read implements io.Reader
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		if err := c.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// nextChunk reads the header of the next chunk, skipping the line ending
+// of the previous one and the trailers after the last one
+func (c *chunkedReader) nextChunk() error {
+	line, err := c.readLine()
+	if line == "" && err == nil {
+		line, err = c.readLine()
+	}
+	if err != nil {
+		return err
+	}
+
+	sizeHex, _, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeHex), 16, 64)
+	if err != nil {
+		return fmt.Errorf("invalid chunk header %q", line)
+	}
+	if size > 0 {
+		c.remaining = size
+		return nil
+	}
+
+	// Skip trailers up to the final empty line
+	c.done = true
+	for {
+		line, err := c.readLine()
+		if line == "" || err != nil {
+			return nil
+		}
+	}
+}
+
+// readLine reads a line without its line ending
+func (c *chunkedReader) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil && !(err == io.EOF && line != "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,287 @@
+package s3test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// upload is a multipart upload in progress
+type upload struct {
+	bucket      string
+	key         string
+	id          string
+	initiated   time.Time
+	contentType string
+	metadata    http.Header
+	parts       map[int]*part
+}
+
+// part is an uploaded part of a multipart upload
+type part struct {
+	data     []byte
+	etag     string
+	modified time.Time
+}
+
+// UploadIDs returns the IDs of the multipart uploads in progress in a
+// bucket, sorted by key
+func (s *Server) UploadIDs(bucketName string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var uploads []*upload
+	for _, u := range s.uploads {
+		if u.bucket == bucketName {
+			uploads = append(uploads, u)
+		}
+	}
+	sortUploads(uploads)
+
+	ids := make([]string, 0, len(uploads))
+	for _, u := range uploads {
+		ids = append(ids, u.id)
+	}
+	return ids
+}
+
+// StartUpload starts a multipart upload of key with the given initiation
+// time and one part holding data, as left behind by an interrupted upload,
+// and returns its ID
+func (s *Server) StartUpload(bucketName string, key string, data []byte, initiated time.Time) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u := s.newUpload(bucketName, key, "", nil)
+	u.initiated = initiated.UTC()
+	u.parts[1] = newPart(data)
+	return u.id
+}
+
+// newUpload registers a new multipart upload. The caller holds s.mu.
+func (s *Server) newUpload(bucketName string, key string, contentType string, metadata http.Header) *upload {
+	s.bucket(bucketName)
+	s.nextID++
+	u := &upload{
+		bucket:      bucketName,
+		key:         key,
+		id:          fmt.Sprintf("upload-%d", s.nextID),
+		initiated:   time.Now().UTC(),
+		contentType: contentType,
+		metadata:    metadata,
+		parts:       make(map[int]*part),
+	}
+	s.uploads[u.id] = u
+	return u
+}
+
+// serveMultipart handles the requests of multipart uploads
+func (s *Server) serveMultipart(w http.ResponseWriter, r *http.Request, bucketName string, key string, query url.Values) {
+	if r.Method == http.MethodPost && query.Has("uploads") {
+		s.mu.Lock()
+		u := s.newUpload(bucketName, key, r.Header.Get("Content-Type"), userMetadata(r.Header))
+		s.mu.Unlock()
+
+		writeXML(w, http.StatusOK, initiateMultipartUploadResult{Bucket: bucketName, Key: key, UploadID: u.id})
+		return
+	}
+
+	s.mu.Lock()
+	u, ok := s.uploads[query.Get("uploadId")]
+	s.mu.Unlock()
+	if !ok || u.bucket != bucketName || u.key != key {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist.")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		s.uploadPart(w, r, u, query)
+	case http.MethodPost:
+		s.completeUpload(w, r, u)
+	case http.MethodGet:
+		s.listParts(w, u)
+	case http.MethodDelete:
+		s.mu.Lock()
+		delete(s.uploads, u.id)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed")
+	}
+}
+
+// uploadPart handles UploadPart
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request, u *upload, query url.Values) {
+	number, err := strconv.Atoi(query.Get("partNumber"))
+	if err != nil || number < 1 || number > 10000 {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "Part number must be an integer between 1 and 10000")
+		return
+	}
+
+	// UploadPartCopy takes the part from a range of another object
+	if r.Header.Get("X-Amz-Copy-Source") != "" {
+		s.copyPart(w, r, u, number)
+		return
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	p := newPart(data)
+
+	s.mu.Lock()
+	u.parts[number] = p
+	s.mu.Unlock()
+
+	w.Header().Set("ETag", quote(p.etag))
+	w.WriteHeader(http.StatusOK)
+}
+
+// copyPart handles UploadPartCopy
+func (s *Server) copyPart(w http.ResponseWriter, r *http.Request, u *upload, number int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	src, ok := s.copySource(w, r)
+	if !ok {
+		return
+	}
+
+	data := src.data
+	if copyRange := r.Header.Get("X-Amz-Copy-Source-Range"); copyRange != "" {
+		start, end, err := parseRange(copyRange, int64(len(data)))
+		if err != nil {
+			writeError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable")
+			return
+		}
+		data = data[start : end+1]
+	}
+
+	p := newPart(bytes.Clone(data))
+	u.parts[number] = p
+	writeXML(w, http.StatusOK, copyPartResult{ETag: quote(p.etag), LastModified: p.modified})
+}
+
+// newPart creates an uploaded part
+func newPart(data []byte) *part {
+	sum := md5.Sum(data)
+	return &part{data: data, etag: hex.EncodeToString(sum[:]), modified: time.Now().UTC()}
+}
+
+// completeUpload handles CompleteMultipartUpload. The object ETag is the
+// MD5 of the concatenated part MD5s followed by the number of parts.
+func (s *Server) completeUpload(w http.ResponseWriter, r *http.Request, u *upload) {
+	var request completeMultipartUpload
+	if err := xml.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Parts) == 0 {
+		writeError(w, http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var data, digests []byte
+	previous := 0
+	for _, requested := range request.Parts {
+		p, ok := u.parts[requested.PartNumber]
+		if !ok || strings.Trim(requested.ETag, `"`) != p.etag {
+			writeError(w, http.StatusBadRequest, "InvalidPart", "One or more of the specified parts could not be found.")
+			return
+		}
+		if requested.PartNumber <= previous {
+			writeError(w, http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order.")
+			return
+		}
+		previous = requested.PartNumber
+
+		data = append(data, p.data...)
+		digest, _ := hex.DecodeString(p.etag)
+		digests = append(digests, digest...)
+	}
+
+	sum := md5.Sum(digests)
+	obj := newObject(data, time.Now(), u.contentType, u.metadata)
+	obj.etag = fmt.Sprintf("%s-%d", hex.EncodeToString(sum[:]), len(request.Parts))
+	s.bucket(u.bucket).objects[u.key] = obj
+	delete(s.uploads, u.id)
+
+	writeXML(w, http.StatusOK, completeMultipartUploadResult{
+		Location: s.URL + "/" + u.bucket + "/" + u.key,
+		Bucket:   u.bucket,
+		Key:      u.key,
+		ETag:     quote(obj.etag),
+	})
+}
+
+// listParts handles ListParts
+func (s *Server) listParts(w http.ResponseWriter, u *upload) {
+	s.mu.Lock()
+	result := listPartsResult{Bucket: u.bucket, Key: u.key, UploadID: u.id, MaxParts: 10000}
+	for number, p := range u.parts {
+		result.Parts = append(result.Parts, partInfo{
+			PartNumber:   number,
+			ETag:         quote(p.etag),
+			LastModified: p.modified,
+			Size:         int64(len(p.data)),
+		})
+	}
+	s.mu.Unlock()
+
+	sort.Slice(result.Parts, func(i, j int) bool { return result.Parts[i].PartNumber < result.Parts[j].PartNumber })
+	writeXML(w, http.StatusOK, result)
+}
+
+// listUploads handles ListMultipartUploads, returning every upload whose
+// key starts with the prefix in a single page
+func (s *Server) listUploads(w http.ResponseWriter, bucketName string, query url.Values) {
+	prefix := query.Get("prefix")
+
+	s.mu.Lock()
+	var uploads []*upload
+	for _, u := range s.uploads {
+		if u.bucket == bucketName && strings.HasPrefix(u.key, prefix) {
+			uploads = append(uploads, u)
+		}
+	}
+	sortUploads(uploads)
+
+	result := listMultipartUploadsResult{
+		Bucket:     bucketName,
+		Prefix:     prefix,
+		Delimiter:  query.Get("delimiter"),
+		MaxUploads: 1000,
+	}
+	for _, u := range uploads {
+		result.Uploads = append(result.Uploads, uploadInfo{
+			Key:          u.key,
+			UploadID:     u.id,
+			Initiated:    u.initiated,
+			StorageClass: "STANDARD",
+		})
+	}
+	s.mu.Unlock()
+
+	writeXML(w, http.StatusOK, result)
+}
+
+// sortUploads sorts uploads by key, then by initiation time
+func sortUploads(uploads []*upload) {
+	sort.Slice(uploads, func(i, j int) bool {
+		if uploads[i].key != uploads[j].key {
+			return uploads[i].key < uploads[j].key
+		}
+		return uploads[i].initiated.Before(uploads[j].initiated)
+	})
+}
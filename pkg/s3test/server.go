@@ -0,0 +1,562 @@
+package s3test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vngcloud/aiplatform-util/pkg/config"
+)
+
+const (
+	// DefaultBucket is the bucket created by Start
+	DefaultBucket = "test-bucket"
+
+	// AccessKeyID and SecretAccessKey are the credentials of the returned
+	// configurations. Requests are not authenticated, any key is accepted.
+	AccessKeyID     = "s3test-access-key"
+	SecretAccessKey = "s3test-secret-key"
+)
+
+// Server is an in-process S3-compatible server keeping objects in memory.
+// It implements the subset of the S3 API used by the MinIO SDK to list,
+// stat, download (including ranged GETs), upload (including multipart
+// uploads), copy and delete objects.
+type Server struct {
+	// URL is the endpoint of the server, e.g. http://127.0.0.1:43567
+	URL string
+
+	srv *httptest.Server
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	uploads map[string]*upload
+	faults  []*Fault
+	nextID  int
+
+	// listMetadata includes user metadata in listings that request it
+	listMetadata bool
+}
+
+// bucket holds the objects of a bucket
+type bucket struct {
+	created time.Time
+	objects map[string]*object
+}
+
+// object is a stored object
+type object struct {
+	data        []byte
+	etag        string
+	modified    time.Time
+	contentType string
+	metadata    http.Header
+}
+
+// NewServer starts a server listening on a loopback port with no buckets.
+// Close it when done.
+func NewServer() *Server {
+	s := &Server{
+		buckets: make(map[string]*bucket),
+		uploads: make(map[string]*upload),
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.srv.URL
+	return s
+}
+
+// Start starts a server with DefaultBucket that is closed when the test
+// ends, and returns it with a configuration using it and a temporary
+// workspace
+func Start(t testing.TB) (*Server, *config.Config) {
+	t.Helper()
+
+	s := NewServer()
+	t.Cleanup(s.Close)
+	s.CreateBucket(DefaultBucket)
+
+	return s, s.Config(DefaultBucket, t.TempDir())
+}
+
+// Close shuts the server down
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Config returns a configuration using the server, bucket and mountPath
+func (s *Server) Config(bucketName string, mountPath string) *config.Config {
+	return &config.Config{
+		AccessKeyID:     AccessKeyID,
+		SecretAccessKey: SecretAccessKey,
+		Endpoint:        s.URL,
+		BucketName:      bucketName,
+		MountPath:       mountPath,
+	}
+}
+
+// CreateBucket creates an empty bucket, keeping an existing one as is
+func (s *Server) CreateBucket(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.buckets[name]; !ok {
+		s.buckets[name] = &bucket{created: time.Now().UTC(), objects: make(map[string]*object)}
+	}
+}
+
+// PutObject stores data under key with the given modification time
+func (s *Server) PutObject(bucketName string, key string, data []byte, modified time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := s.bucket(bucketName)
+	b.objects[key] = newObject(data, modified, "", nil)
+}
+
+// GetObject returns the content of an object
+func (s *Server) GetObject(bucketName string, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obj, ok := s.bucket(bucketName).objects[key]
+	if !ok {
+		return nil, false
+	}
+	return bytes.Clone(obj.data), true
+}
+
+// Metadata returns the x-amz-meta-* headers stored with an object
+func (s *Server) Metadata(bucketName string, key string) (http.Header, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obj, ok := s.bucket(bucketName).objects[key]
+	if !ok {
+		return nil, false
+	}
+	return obj.metadata.Clone(), true
+}
+
+// ListMetadata makes listings requested with metadata=true include the user
+// metadata of each object, as MinIO does. Other servers, and this one by
+// default, ignore the request.
+func (s *Server) ListMetadata(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.listMetadata = enabled
+}
+
+// Keys returns the sorted keys of the objects in a bucket
+func (s *Server) Keys(bucketName string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []string
+	for key := range s.bucket(bucketName).objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// bucket returns a bucket, creating it if needed. The caller holds s.mu.
+func (s *Server) bucket(name string) *bucket {
+	b, ok := s.buckets[name]
+	if !ok {
+		b = &bucket{created: time.Now().UTC(), objects: make(map[string]*object)}
+		s.buckets[name] = b
+	}
+	return b
+}
+
+// newObject creates an object with the ETag of a single-part upload
+func newObject(data []byte, modified time.Time, contentType string, metadata http.Header) *object {
+	sum := md5.Sum(data)
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return &object{
+		data:        data,
+		etag:        hex.EncodeToString(sum[:]),
+		modified:    modified.UTC(),
+		contentType: contentType,
+		metadata:    metadata,
+	}
+}
+
+// serveHTTP applies the injected faults and dispatches a request
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	bucketName, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+
+	if fault := s.fault(r.Method, key); fault != nil {
+		if fault.Delay > 0 {
+			select {
+			case <-time.After(fault.Delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if fault.Status != 0 {
+			writeError(w, fault.Status, statusCode(fault.Status), http.StatusText(fault.Status))
+			return
+		}
+		if fault.Truncate > 0 {
+			w = &truncatingWriter{ResponseWriter: w, remaining: fault.Truncate}
+		}
+	}
+
+	// Request bodies may be sent with the aws-chunked encoding
+	if strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		r.Body = io.NopCloser(newChunkedReader(r.Body))
+	}
+
+	query := r.URL.Query()
+	switch {
+	case bucketName == "":
+		s.listBuckets(w)
+	case key == "":
+		s.serveBucket(w, r, bucketName, query)
+	case query.Has("uploads") || query.Has("uploadId"):
+		s.serveMultipart(w, r, bucketName, key, query)
+	default:
+		s.serveObject(w, r, bucketName, key)
+	}
+}
+
+// serveBucket handles requests on a bucket
+func (s *Server) serveBucket(w http.ResponseWriter, r *http.Request, bucketName string, query url.Values) {
+	s.mu.Lock()
+	_, ok := s.buckets[bucketName]
+	s.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPut:
+		s.CreateBucket(bucketName)
+		w.WriteHeader(http.StatusOK)
+	case !ok:
+		writeError(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+	case r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodGet && query.Has("location"):
+		writeXML(w, http.StatusOK, locationConstraint{Location: "us-east-1"})
+	case r.Method == http.MethodGet && query.Has("uploads"):
+		s.listUploads(w, bucketName, query)
+	case r.Method == http.MethodGet:
+		s.listObjects(w, bucketName, query)
+	case r.Method == http.MethodPost && query.Has("delete"):
+		s.deleteObjects(w, r, bucketName)
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented", "Operation not implemented by s3test")
+	}
+}
+
+// serveObject handles requests on an object
+func (s *Server) serveObject(w http.ResponseWriter, r *http.Request, bucketName string, key string) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		s.getObject(w, r, bucketName, key)
+	case http.MethodPut:
+		if r.Header.Get("X-Amz-Copy-Source") != "" {
+			s.copyObject(w, r, bucketName, key)
+		} else {
+			s.putObject(w, r, bucketName, key)
+		}
+	case http.MethodDelete:
+		s.mu.Lock()
+		delete(s.bucket(bucketName).objects, key)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed")
+	}
+}
+
+// listBuckets handles ListBuckets
+func (s *Server) listBuckets(w http.ResponseWriter) {
+	s.mu.Lock()
+	result := listAllMyBucketsResult{}
+	for name, b := range s.buckets {
+		result.Buckets = append(result.Buckets, bucketInfo{Name: name, CreationDate: b.created})
+	}
+	s.mu.Unlock()
+
+	sort.Slice(result.Buckets, func(i, j int) bool { return result.Buckets[i].Name < result.Buckets[j].Name })
+	writeXML(w, http.StatusOK, result)
+}
+
+// listObjects handles ListObjectsV2, grouping keys by delimiter and paging
+// through results with continuation tokens
+func (s *Server) listObjects(w http.ResponseWriter, bucketName string, query url.Values) {
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	token := query.Get("continuation-token")
+	if token == "" {
+		token = query.Get("start-after")
+	}
+	maxKeys := 1000
+	if value, err := strconv.Atoi(query.Get("max-keys")); err == nil && value > 0 && value < maxKeys {
+		maxKeys = value
+	}
+
+	s.mu.Lock()
+	objects := s.bucket(bucketName).objects
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := listBucketResult{
+		Name:              bucketName,
+		Prefix:            prefix,
+		Delimiter:         delimiter,
+		MaxKeys:           maxKeys,
+		ContinuationToken: query.Get("continuation-token"),
+	}
+	last := ""
+	for _, key := range keys {
+		if !strings.HasPrefix(key, prefix) || key <= token {
+			continue
+		}
+
+		// Keys below a common prefix returned on a previous page are skipped
+		if delimiter != "" && strings.HasSuffix(token, delimiter) && strings.HasPrefix(key, token) {
+			continue
+		}
+
+		if result.KeyCount == maxKeys {
+			result.IsTruncated = true
+			result.NextContinuationToken = last
+			break
+		}
+
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				group := key[:len(prefix)+i+len(delimiter)]
+				if group != last {
+					result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: group})
+					result.KeyCount++
+					last = group
+				}
+				continue
+			}
+		}
+
+		obj := objects[key]
+		info := objectInfo{
+			Key:          key,
+			LastModified: obj.modified,
+			ETag:         quote(obj.etag),
+			Size:         int64(len(obj.data)),
+			StorageClass: "STANDARD",
+		}
+		if s.listMetadata && query.Get("metadata") == "true" {
+			metadata := metadataMap(obj.metadata.Clone())
+			if metadata == nil {
+				metadata = metadataMap{}
+			}
+			info.UserMetadata = &metadata
+		}
+		result.Contents = append(result.Contents, info)
+		result.KeyCount++
+		last = key
+	}
+	s.mu.Unlock()
+
+	writeXML(w, http.StatusOK, result)
+}
+
+// getObject handles GetObject and HeadObject, including ranged GETs
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, bucketName string, key string) {
+	s.mu.Lock()
+	obj, ok := s.bucket(bucketName).objects[key]
+	s.mu.Unlock()
+
+	if !ok {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+
+	if match := r.Header.Get("If-Match"); match != "" && strings.Trim(match, `"`) != obj.etag {
+		writeError(w, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold")
+		return
+	}
+
+	header := w.Header()
+	for name, values := range obj.metadata {
+		header[name] = values
+	}
+	header.Set("Content-Type", obj.contentType)
+	header.Set("ETag", quote(obj.etag))
+	header.Set("Last-Modified", obj.modified.Format(http.TimeFormat))
+	header.Set("Accept-Ranges", "bytes")
+
+	size := int64(len(obj.data))
+	start, end, status := int64(0), size-1, http.StatusOK
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		var err error
+		start, end, err = parseRange(rangeHeader, size)
+		if err != nil {
+			writeError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable")
+			return
+		}
+		status = http.StatusPartialContent
+		header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+	}
+
+	header.Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	w.WriteHeader(status)
+	if r.Method == http.MethodGet {
+		w.Write(obj.data[start : end+1])
+	}
+}
+
+// putObject handles PutObject
+func (s *Server) putObject(w http.ResponseWriter, r *http.Request, bucketName string, key string) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+
+	obj := newObject(data, time.Now(), r.Header.Get("Content-Type"), userMetadata(r.Header))
+
+	s.mu.Lock()
+	s.bucket(bucketName).objects[key] = obj
+	s.mu.Unlock()
+
+	w.Header().Set("ETag", quote(obj.etag))
+	w.WriteHeader(http.StatusOK)
+}
+
+// copyObject handles CopyObject, copying the metadata of the source unless
+// it is replaced
+func (s *Server) copyObject(w http.ResponseWriter, r *http.Request, bucketName string, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	src, ok := s.copySource(w, r)
+	if !ok {
+		return
+	}
+
+	dst := *src
+	dst.modified = time.Now().UTC()
+	if r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" {
+		dst.metadata = userMetadata(r.Header)
+		if contentType := r.Header.Get("Content-Type"); contentType != "" {
+			dst.contentType = contentType
+		}
+	}
+	s.bucket(bucketName).objects[key] = &dst
+
+	writeXML(w, http.StatusOK, copyObjectResult{ETag: quote(dst.etag), LastModified: dst.modified})
+}
+
+// copySource returns the source object of a copy request, writing an error
+// response if it does not exist or does not match. The caller holds s.mu.
+func (s *Server) copySource(w http.ResponseWriter, r *http.Request) (*object, bool) {
+	source, err := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "Invalid copy source")
+		return nil, false
+	}
+	source, _, _ = strings.Cut(source, "?")
+	srcBucket, srcKey, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+
+	src, ok := s.bucket(srcBucket).objects[srcKey]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return nil, false
+	}
+	if match := r.Header.Get("X-Amz-Copy-Source-If-Match"); match != "" && strings.Trim(match, `"`) != src.etag {
+		writeError(w, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold")
+		return nil, false
+	}
+	return src, true
+}
+
+// deleteObjects handles DeleteObjects
+func (s *Server) deleteObjects(w http.ResponseWriter, r *http.Request, bucketName string) {
+	var request deleteRequest
+	if err := xml.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "MalformedXML", err.Error())
+		return
+	}
+
+	result := deleteResult{}
+	s.mu.Lock()
+	for _, obj := range request.Objects {
+		delete(s.bucket(bucketName).objects, obj.Key)
+		if !request.Quiet {
+			result.Deleted = append(result.Deleted, deletedObject{Key: obj.Key})
+		}
+	}
+	s.mu.Unlock()
+
+	writeXML(w, http.StatusOK, result)
+}
+
+// userMetadata returns the x-amz-meta-* headers of a request
+func userMetadata(header http.Header) http.Header {
+	metadata := make(http.Header)
+	for name, values := range header {
+		if strings.HasPrefix(strings.ToLower(name), "x-amz-meta-") {
+			metadata[name] = values
+		}
+	}
+	return metadata
+}
+
+// parseRange parses a single byte range of an object of the given size
+func parseRange(value string, size int64) (int64, int64, error) {
+	spec, ok := strings.CutPrefix(value, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, fmt.Errorf("unsupported range %q", value)
+	}
+	first, last, _ := strings.Cut(spec, "-")
+
+	// A suffix range selects the last bytes of the object
+	if first == "" {
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, fmt.Errorf("invalid range %q", value)
+		}
+		return max(size-n, 0), size - 1, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start >= size {
+		return 0, 0, fmt.Errorf("invalid range %q", value)
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, fmt.Errorf("invalid range %q", value)
+		}
+		end = min(end, size-1)
+	}
+	return start, end, nil
+}
+
+// quote returns an ETag as sent in headers and listings
+func quote(etag string) string {
+	return `"` + etag + `"`
+}
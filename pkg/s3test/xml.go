@@ -0,0 +1,197 @@
+package s3test
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type errorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	RequestID string   `xml:"RequestId"`
+}
+
+type locationConstraint struct {
+	XMLName  xml.Name `xml:"LocationConstraint"`
+	Location string   `xml:",chardata"`
+}
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name     `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListAllMyBucketsResult"`
+	Buckets []bucketInfo `xml:"Buckets>Bucket"`
+}
+
+type bucketInfo struct {
+	Name         string    `xml:"Name"`
+	CreationDate time.Time `xml:"CreationDate"`
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name       `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	KeyCount              int            `xml:"KeyCount"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	Contents              []objectInfo   `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+type objectInfo struct {
+	Key          string       `xml:"Key"`
+	LastModified time.Time    `xml:"LastModified"`
+	ETag         string       `xml:"ETag"`
+	Size         int64        `xml:"Size"`
+	StorageClass string       `xml:"StorageClass"`
+	UserMetadata *metadataMap `xml:"UserMetadata,omitempty"`
+}
+
+// metadataMap is encoded as one element per header, named after it, as
+// MinIO lists the metadata of objects
+type metadataMap http.Header
+
+// MarshalXML implements xml.Marshaler
+func (m metadataMap) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for name, values := range m {
+		if len(values) == 0 {
+			continue
+		}
+		if err := e.EncodeElement(values[0], xml.StartElement{Name: xml.Name{Local: name}}); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type copyObjectResult struct {
+	XMLName      xml.Name  `xml:"CopyObjectResult"`
+	ETag         string    `xml:"ETag"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+type copyPartResult struct {
+	XMLName      xml.Name  `xml:"CopyPartResult"`
+	ETag         string    `xml:"ETag"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+type deleteRequest struct {
+	Quiet   bool `xml:"Quiet"`
+	Objects []struct {
+		Key string `xml:"Key"`
+	} `xml:"Object"`
+}
+
+type deleteResult struct {
+	XMLName xml.Name        `xml:"DeleteResult"`
+	Deleted []deletedObject `xml:"Deleted"`
+}
+
+type deletedObject struct {
+	Key string `xml:"Key"`
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+type completeMultipartUpload struct {
+	Parts []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+type listPartsResult struct {
+	XMLName     xml.Name   `xml:"ListPartsResult"`
+	Bucket      string     `xml:"Bucket"`
+	Key         string     `xml:"Key"`
+	UploadID    string     `xml:"UploadId"`
+	MaxParts    int        `xml:"MaxParts"`
+	IsTruncated bool       `xml:"IsTruncated"`
+	Parts       []partInfo `xml:"Part"`
+}
+
+type partInfo struct {
+	PartNumber   int       `xml:"PartNumber"`
+	ETag         string    `xml:"ETag"`
+	LastModified time.Time `xml:"LastModified"`
+	Size         int64     `xml:"Size"`
+}
+
+type listMultipartUploadsResult struct {
+	XMLName     xml.Name     `xml:"ListMultipartUploadsResult"`
+	Bucket      string       `xml:"Bucket"`
+	Prefix      string       `xml:"Prefix"`
+	Delimiter   string       `xml:"Delimiter,omitempty"`
+	MaxUploads  int          `xml:"MaxUploads"`
+	IsTruncated bool         `xml:"IsTruncated"`
+	Uploads     []uploadInfo `xml:"Upload"`
+}
+
+type uploadInfo struct {
+	Key          string    `xml:"Key"`
+	UploadID     string    `xml:"UploadId"`
+	Initiated    time.Time `xml:"Initiated"`
+	StorageClass string    `xml:"StorageClass"`
+}
+
+// writeXML writes an S3 XML response
+func writeXML(w http.ResponseWriter, status int, body any) {
+	data, err := xml.Marshal(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Length", strconv.Itoa(len(xml.Header)+len(data)))
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
+
+// writeError writes an S3 error response
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	writeXML(w, status, errorResponse{Code: code, Message: message, RequestID: "s3test"})
+}
+
+// statusCode returns the S3 error code of an injected HTTP status
+func statusCode(status int) string {
+	switch status {
+	case http.StatusInternalServerError:
+		return "InternalError"
+	case http.StatusServiceUnavailable:
+		return "SlowDown"
+	case http.StatusNotFound:
+		return "NoSuchKey"
+	case http.StatusForbidden:
+		return "AccessDenied"
+	default:
+		return "InjectedFault"
+	}
+}
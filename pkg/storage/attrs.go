@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Attrs are the attributes of the local file an object was uploaded from.
+// They are stored with the object so that downloads restore them.
+type Attrs struct {
+	// ModTime is the modification time of the file
+	ModTime time.Time
+
+	// Mode holds the permission bits of the file and os.ModeDir for a
+	// directory, 0 when unknown
+	Mode os.FileMode
+
+	// Link is the target of a symbolic link, which is stored in place of
+	// content, empty for other files
+	Link string
+
+	// UID and GID are the owner and group of the file, -1 when unknown
+	UID int
+	GID int
+}
+
+// AttrsOf returns the attributes of a local file
+func AttrsOf(info os.FileInfo) *Attrs {
+	uid, gid := owner(info)
+	return &Attrs{
+		ModTime: info.ModTime(),
+		Mode:    info.Mode() & (os.ModePerm | os.ModeDir),
+		UID:     uid,
+		GID:     gid,
+	}
+}
+
+// ReadAttrs returns the attributes of the local file at path described by
+// info from os.Lstat, reading the target of a symbolic link
+func ReadAttrs(path string, info os.FileInfo) (*Attrs, error) {
+	attrs := AttrsOf(info)
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read link %s: %w", path, err)
+		}
+		attrs.Mode = 0
+		attrs.Link = target
+	}
+	return attrs, nil
+}
+
+// ModTime returns the modification time of the file an object was uploaded
+// from if it is known, or the time the object was stored otherwise
+func (o Object) ModTime() time.Time {
+	if o.Attrs != nil && !o.Attrs.ModTime.IsZero() {
+		return o.Attrs.ModTime
+	}
+	return o.LastModified
+}
+
+// RestoreAttrs sets the modification time and permissions of a downloaded
+// file, and its owner if owner is set. Unknown attributes are left alone.
+// Only the owner of a symbolic link is restored, as changing its mode or
+// times would change the file it points to.
+func RestoreAttrs(path string, attrs *Attrs, owner bool) error {
+	var errs []error
+	if attrs.Mode.Perm() != 0 && attrs.Link == "" {
+		errs = append(errs, os.Chmod(path, attrs.Mode.Perm()))
+	}
+	if !attrs.ModTime.IsZero() && attrs.Link == "" {
+		errs = append(errs, os.Chtimes(path, attrs.ModTime, attrs.ModTime))
+	}
+	if owner && attrs.UID >= 0 && attrs.GID >= 0 {
+		errs = append(errs, os.Lchown(path, attrs.UID, attrs.GID))
+	}
+	return errors.Join(errs...)
+}
+
+// WriteLink atomically replaces path with a symbolic link to target
+func WriteLink(path string, target string) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+
+	partPath := path + PartialSuffix
+	os.Remove(partPath)
+	if err := os.Symlink(target, partPath); err != nil {
+		return fmt.Errorf("failed to create link %s: %w", path, err)
+	}
+	if err := os.Rename(partPath, path); err != nil {
+		os.Remove(partPath)
+		return fmt.Errorf("failed to create link %s: %w", path, err)
+	}
+	return nil
+}
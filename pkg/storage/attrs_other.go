@@ -0,0 +1,10 @@
+//go:build !unix
+
+package storage
+
+import "os"
+
+// owner returns -1, -1, file owners are not recorded on this platform
+func owner(info os.FileInfo) (int, int) {
+	return -1, -1
+}
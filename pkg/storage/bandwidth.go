@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// minLimitedRead is the smallest read a limited reader makes, so that very
+// low limits do not turn a transfer into a stream of tiny reads
+const minLimitedRead = 4 << 10
+
+// Limiter is a token bucket limiting the combined throughput of all readers
+// wrapped by it, e.g. of the concurrent transfers of a command. The limit may
+// change over time, for example following a timetable. A nil Limiter does not
+// limit anything.
+type Limiter struct {
+	// rate returns the limit in bytes per second at a given time,
+	// 0 or less for no limit
+	rate func(time.Time) int64
+
+	// now and sleep are the clock of the limiter, replaced in tests
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+
+	mu sync.Mutex
+
+	// tokens are the bytes that may be read without waiting, negative when
+	// readers are waiting for bytes they have already read
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter creates a limiter whose rate in bytes per second at any time is
+// returned by rate
+func NewLimiter(rate func(time.Time) int64) *Limiter {
+	return &Limiter{rate: rate, now: time.Now, sleep: sleep, last: time.Now()}
+}
+
+// sleep waits for d, stopping early with the context's error when ctx is
+// done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Reader wraps r so that reading from it waits for the limiter. Waiting stops
+// early with the context's error when ctx is done.
+func (l *Limiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &limitedReader{ctx: ctx, reader: r, limiter: l}
+}
+
+// burst returns the number of bytes that may be read at once at rate, a
+// quarter of a second's worth
+func burst(rate int64) float64 {
+	return math.Max(float64(rate)/4, minLimitedRead)
+}
+
+// maxRead returns the size of the largest read that keeps the throughput
+// smooth at the current rate
+func (l *Limiter) maxRead() int {
+	rate := l.rate(l.now())
+	if rate <= 0 {
+		return math.MaxInt
+	}
+	return int(burst(rate))
+}
+
+// wait takes n bytes from the bucket, waiting until the bucket has refilled
+// if it runs out
+func (l *Limiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := l.now()
+	rate := l.rate(now)
+	if rate <= 0 {
+		l.tokens = 0
+		l.last = now
+		l.mu.Unlock()
+		return nil
+	}
+	l.tokens = math.Min(l.tokens+now.Sub(l.last).Seconds()*float64(rate), burst(rate))
+	l.last = now
+	l.tokens -= float64(n)
+	delay := time.Duration(-l.tokens / float64(rate) * float64(time.Second))
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	return l.sleep(ctx, delay)
+}
+
+// limitedReader is an io.Reader that waits for a Limiter after every read
+type limitedReader struct {
+	ctx     context.Context
+	reader  io.Reader
+	limiter *Limiter
+}
+
+// Read implements io.Reader. Reads are capped so that a single read never
+// has to wait much longer than the limiter's burst.
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if limit := r.limiter.maxRead(); len(p) > limit {
+		p = p[:limit]
+	}
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		if werr := r.limiter.wait(r.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a limiter clock that only moves when sleeping or advanced
+type fakeClock struct {
+	mu    sync.Mutex
+	t     time.Time
+	slept time.Duration
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) sleep(ctx context.Context, d time.Duration) error {
+	c.advance(d)
+	c.mu.Lock()
+	c.slept += d
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	c.mu.Unlock()
+}
+
+// newFakeLimiter returns a limiter running on clock
+func newFakeLimiter(clock *fakeClock, rate func(time.Time) int64) *Limiter {
+	l := NewLimiter(rate)
+	l.now, l.sleep, l.last = clock.now, clock.sleep, clock.now()
+	return l
+}
+
+// read reads n bytes through the limiter
+func read(t *testing.T, l *Limiter, n int) {
+	t.Helper()
+	copied, err := io.Copy(io.Discard, l.Reader(context.Background(), bytes.NewReader(make([]byte, n))))
+	if err != nil || copied != int64(n) {
+		t.Fatalf("read %d of %d bytes: %v", copied, n, err)
+	}
+}
+
+// assertDuration fails unless got is within a millisecond of want
+func assertDuration(t *testing.T, what string, got time.Duration, want time.Duration) {
+	t.Helper()
+	if got < want-time.Millisecond || got > want+time.Millisecond {
+		t.Errorf("%s = %v, want %v", what, got, want)
+	}
+}
+
+func TestLimiterPacesReads(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := newFakeLimiter(clock, func(time.Time) int64 { return 256 << 10 })
+
+	// The bucket starts empty, so 1MiB at 256KiB/s takes 4 seconds
+	read(t, l, 1<<20)
+	assertDuration(t, "time to read 1MiB", clock.slept, 4*time.Second)
+}
+
+func TestLimiterBurstAfterIdle(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := newFakeLimiter(clock, func(time.Time) int64 { return 256 << 10 })
+
+	// An idle limiter saves up a quarter of a second's worth of bytes, not
+	// the whole idle time's
+	clock.advance(time.Minute)
+	read(t, l, 64<<10)
+	assertDuration(t, "time to read the burst", clock.slept, 0)
+	read(t, l, 256<<10)
+	assertDuration(t, "time to read past the burst", clock.slept, time.Second)
+}
+
+func TestLimiterSharedByReaders(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := &fakeClock{t: start}
+	l := newFakeLimiter(clock, func(time.Time) int64 { return 100 << 10 })
+
+	// The limit applies to the combined throughput
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			read(t, l, 100<<10)
+		}()
+	}
+	wg.Wait()
+	if elapsed := clock.now().Sub(start); elapsed < 4*time.Second-time.Millisecond {
+		t.Errorf("4 readers read 400KiB at 100KiB/s in %v", elapsed)
+	}
+}
+
+func TestLimiterFollowsRateChanges(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := &fakeClock{t: start}
+	l := newFakeLimiter(clock, func(t time.Time) int64 {
+		// Unlimited for the first minute, then 128KiB/s
+		if t.Before(start.Add(time.Minute)) {
+			return 0
+		}
+		return 128 << 10
+	})
+
+	read(t, l, 10<<20)
+	assertDuration(t, "time to read without a limit", clock.slept, 0)
+
+	// The burst saved up while waiting for the limit is read right away
+	clock.advance(time.Minute)
+	read(t, l, 256<<10)
+	assertDuration(t, "time to read once limited", clock.slept, 1750*time.Millisecond)
+}
+
+func TestLimiterStopsWithContext(t *testing.T) {
+	l := NewLimiter(func(time.Time) int64 { return 1 })
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := io.Copy(io.Discard, l.Reader(ctx, bytes.NewReader(make([]byte, 64<<10))))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("read with a cancelled context = %v, want context.Canceled", err)
+	}
+}
+
+func TestNilLimiterDoesNotLimit(t *testing.T) {
+	var l *Limiter
+	r := bytes.NewReader(nil)
+	if got := l.Reader(context.Background(), r); got != r {
+		t.Error("a nil limiter wrapped the reader")
+	}
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// minPartSize is the smallest part size used for multipart uploads,
+	// files up to this size are uploaded in a single part
+	minPartSize = 16 * 1024 * 1024
+
+	// maxParts is the largest number of parts of a multipart upload
+	maxParts = 10000
+
+	// maxObjectSize is the largest object a multipart upload can create
+	maxObjectSize = 5 * 1024 * 1024 * 1024 * 1024
+
+	// emptyETag is the ETag of an object without content
+	emptyETag = "d41d8cd98f00b204e9800998ecf8427e"
+)
+
+// PartSize returns the multipart part size used to upload a file of the
+// given size, or 0 if the file is uploaded in a single part. It is the
+// smallest multiple of the minimum part size that fits the file in the
+// maximum number of parts. The MinIO SDK truncates size/maxParts before
+// rounding up, which leaves files just above a multiple of maxParts parts
+// with one part too many, and rejects that part size once it is pinned.
+func PartSize(size int64) int64 {
+	// Files up to the minimum part size are sent with a single PUT
+	if size <= minPartSize || size > maxObjectSize {
+		return 0
+	}
+
+	perPart := (size + maxParts - 1) / maxParts
+	return (perPart + minPartSize - 1) / minPartSize * minPartSize
+}
+
+// autoPartSize returns the part size the MinIO SDK picks for a file of the
+// given size when none is set, which uploads made before PartSize was
+// pinned and recorded used. It only differs from PartSize for sizes the
+// SDK cannot upload in maxParts parts.
+func autoPartSize(size int64) int64 {
+	if size <= minPartSize || size > maxObjectSize {
+		return 0
+	}
+	return int64(math.Ceil(float64(size/maxParts)/minPartSize)) * minPartSize
+}
+
+// FileETag computes the ETag S3 would report for a local file uploaded with
+// the given part size. A zero part size yields the plain MD5 of a single-part
+// upload, otherwise the MD5 of the concatenated part MD5s suffixed with the
+// number of parts.
+func FileETag(path string, partSize int64) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open local file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	// Single-part objects use the MD5 of the whole content
+	if partSize <= 0 {
+		hash := md5.New()
+		if _, err := io.Copy(hash, file); err != nil {
+			return "", fmt.Errorf("failed to hash %s: %w", path, err)
+		}
+		return hex.EncodeToString(hash.Sum(nil)), nil
+	}
+
+	// Multipart objects hash each part, then hash the concatenated digests
+	var digests []byte
+	parts := 0
+	for {
+		hash := md5.New()
+		n, err := io.CopyN(hash, file, partSize)
+		if err != nil && err != io.EOF {
+			return "", fmt.Errorf("failed to hash %s: %w", path, err)
+		}
+		if n == 0 && parts > 0 {
+			break
+		}
+		digests = hash.Sum(digests)
+		parts++
+		if n < partSize {
+			break
+		}
+	}
+
+	sum := md5.Sum(digests)
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(sum[:]), parts), nil
+}
+
+// ETagPartSize returns the part size needed to reproduce an object's ETag
+// from its content with FileETag, given the part size recorded at upload time
+// (0 if unknown). It reports false if the ETag cannot be reproduced, e.g.
+// for objects uploaded by other tools with an unknown part size.
+func ETagPartSize(etag string, size int64, recordedPartSize int64) (int64, bool) {
+	dash := strings.Index(etag, "-")
+
+	// Single-part ETags are the plain MD5 of the content
+	if dash < 0 {
+		return 0, len(etag) == 2*md5.Size
+	}
+
+	// Objects uploaded before the part size was recorded used the part
+	// size calculated by the SDK
+	partSize := recordedPartSize
+	if partSize == 0 {
+		partSize = autoPartSize(size)
+	}
+
+	// A different part count means the part size is not the one assumed
+	parts, err := strconv.ParseInt(etag[dash+1:], 10, 64)
+	if err != nil || partSize == 0 || parts != (size+partSize-1)/partSize {
+		return 0, false
+	}
+	return partSize, true
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const mib = 1024 * 1024
+
+func TestPartSize(t *testing.T) {
+	tests := []struct {
+		name string
+		size int64
+		want int64
+	}{
+		{"empty", 0, 0},
+		{"single part", 5 * mib, 0},
+		{"minimum part size", minPartSize, 0},
+		{"just above minimum part size", minPartSize + 1, minPartSize},
+		{"max parts of minimum size", maxParts * minPartSize, minPartSize},
+		// MinIO truncates size/maxParts and would use 10001 parts of 16MiB
+		{"just above max parts of minimum size", maxParts*minPartSize + 1, 2 * minPartSize},
+		{"max parts of twice the minimum size", 2 * maxParts * minPartSize, 2 * minPartSize},
+		{"just above max parts of twice the minimum size", 2*maxParts*minPartSize + 1, 3 * minPartSize},
+		{"max object size", maxObjectSize, 33 * minPartSize},
+		{"too large", maxObjectSize + 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := PartSize(tt.size)
+			if got != tt.want {
+				t.Fatalf("PartSize(%d) = %d, want %d", tt.size, got, tt.want)
+			}
+			if got == 0 {
+				return
+			}
+
+			// The SDK accepts the pinned part size and uploads at most
+			// maxParts parts
+			parts, partSize, _, err := minio.OptimalPartInfo(tt.size, uint64(got))
+			if err != nil {
+				t.Fatalf("MinIO rejected part size %d for %d bytes: %v", got, tt.size, err)
+			}
+			if partSize != got || parts > maxParts {
+				t.Errorf("MinIO uploads %d parts of %d bytes, want at most %d parts of %d", parts, partSize, maxParts, got)
+			}
+		})
+	}
+}
+
+func TestAutoPartSizeMatchesMinIO(t *testing.T) {
+	for _, size := range []int64{minPartSize + 1, 17 * mib, maxParts * minPartSize, maxParts*minPartSize + 1, 2*maxParts*minPartSize + 1, maxObjectSize} {
+		_, want, _, err := minio.OptimalPartInfo(size, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := autoPartSize(size); got != want {
+			t.Errorf("autoPartSize(%d) = %d, MinIO uses %d", size, got, want)
+		}
+	}
+}
+
+func TestPartSizeMatchesMinIO(t *testing.T) {
+	// Below the boundaries the part size is the one the SDK picks itself,
+	// so objects uploaded by other MinIO based tools can be verified
+	for _, size := range []int64{minPartSize + 1, 17 * mib, 1024 * mib, maxParts * minPartSize, 3 * maxParts * minPartSize, maxObjectSize} {
+		_, want, _, err := minio.OptimalPartInfo(size, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := PartSize(size); got != want {
+			t.Errorf("PartSize(%d) = %d, MinIO uses %d", size, got, want)
+		}
+	}
+}
+
+func TestFileETag(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		partSize int64
+		want     string
+	}{
+		{"empty single part", "", 0, emptyETag},
+		{"single part", "hello world", 0, "5eb63bbbe01eeed093cb22bb8f5acdc3"},
+		{"last part shorter", "hello world", 5, "df349a9519959b17a605009540f4b31d-3"},
+		{"exact multiple of part size", "helloworld", 5, "065947336a2f2a95ba8899f3675c3be6-2"},
+		{"one full part", "hello world", 11, "241d8a27c836427bd7f04461b60e7359-1"},
+		{"part larger than file", "hello world", 64, "241d8a27c836427bd7f04461b60e7359-1"},
+		// The ETag S3 reports for a 17MiB upload with PartSize(17MiB)
+		{"multipart upload", strings.Repeat("x", 17*mib), 16 * mib, "5dfb44dab8a9f13f0d127b93a03158f9-2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "file")
+			if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := FileETag(path, tt.partSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("FileETag = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestETagPartSize(t *testing.T) {
+	const multipart = "5dfb44dab8a9f13f0d127b93a03158f9"
+	tests := []struct {
+		name     string
+		etag     string
+		size     int64
+		recorded int64
+		want     int64
+		ok       bool
+	}{
+		{"single part", "5eb63bbbe01eeed093cb22bb8f5acdc3", 11, 0, 0, true},
+		{"not an MD5", "abc", 11, 0, 0, false},
+		{"calculated part size", multipart + "-2", 17 * mib, 0, 16 * mib, true},
+		{"recorded part size", multipart + "-3", 17 * mib, 8 * mib, 8 * mib, true},
+		{"unknown part size", multipart + "-3", 17 * mib, 0, 0, false},
+		{"recorded part size with other count", multipart + "-2", 17 * mib, 8 * mib, 0, false},
+		{"bad part count", multipart + "-x", 17 * mib, 0, 0, false},
+		{"multipart below minimum part size", multipart + "-1", minPartSize, 0, 0, false},
+		{"just above max parts of minimum size", multipart + "-5001", maxParts*minPartSize + 1, 2 * minPartSize, 2 * minPartSize, true},
+		// Without a recorded part size, the one the SDK picks is assumed
+		{"SDK part size", multipart + "-10001", maxParts*minPartSize + 1, 0, minPartSize, true},
+		{"SDK part size with other count", multipart + "-5001", maxParts*minPartSize + 1, 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ETagPartSize(tt.etag, tt.size, tt.recorded)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("ETagPartSize = %d, %v, want %d, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
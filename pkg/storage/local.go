@@ -0,0 +1,271 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Local is a Storage keeping objects as files under a root directory, so
+// the sync engine can sync a workspace with another local directory.
+// ETags are computed from file content, which makes listing read every
+// listed file.
+type Local struct {
+	root string
+}
+
+// NewLocal creates a storage of the files under root
+func NewLocal(root string) *Local {
+	return &Local{root: root}
+}
+
+// path returns the local path of the file stored under key
+func (l *Local) path(key string) string {
+	return filepath.Join(l.root, filepath.FromSlash(key))
+}
+
+// List returns the objects whose key starts with prefix. Directories are
+// not objects, only the files and links they contain are listed, except
+// for empty directories which are listed as directory markers.
+func (l *Local) List(ctx context.Context, prefix string, recursive bool) ([]Object, error) {
+	var objects []Object
+	err := l.ListEach(ctx, prefix, recursive, "", func(obj Object) error {
+		objects = append(objects, obj)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// ListEach calls fn with the objects whose key starts with prefix in key
+// order. Directories are read one at a time in key order as the walk
+// reaches them, and only the directories that can hold keys under prefix
+// and after startAfter are read.
+func (l *Local) ListEach(ctx context.Context, prefix string, recursive bool, startAfter string, fn func(Object) error) error {
+	_, err := l.walk(ctx, l.root, "", prefix, recursive, startAfter, fn)
+	return err
+}
+
+// errFound stops a walk looking for any object
+var errFound = errors.New("object found")
+
+// walk calls fn with the objects below dir, which holds the keys starting
+// with dirKey, and reports whether dir has any entries. Without recursive,
+// the directories below prefix are listed as groups instead of walked.
+func (l *Local) walk(ctx context.Context, dir string, dirKey string, prefix string, recursive bool, startAfter string, fn func(Object) error) (bool, error) {
+	entries, err := sortedEntries(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		// Missing, or removed since its parent was read
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to list %s: %w", dir, err)
+	}
+
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		key := dirKey + entry.Name()
+		path := filepath.Join(dir, entry.Name())
+
+		if entry.IsDir() {
+			key += "/"
+			// Skip directories without keys under the prefix or after startAfter
+			if !strings.HasPrefix(key, prefix) && !strings.HasPrefix(prefix, key) {
+				continue
+			}
+			if key < startAfter && !strings.HasPrefix(startAfter, key) {
+				continue
+			}
+
+			// Files grouped under a directory need no ETag
+			if !recursive && len(key) > len(prefix) && strings.HasPrefix(key, prefix) {
+				if key <= startAfter {
+					continue
+				}
+				// Empty directories are listed as their marker
+				nonEmpty, err := l.walk(ctx, path, key, key, true, "", func(Object) error { return errFound })
+				if errors.Is(err, errFound) || (err == nil && !nonEmpty) {
+					err = fn(Object{Key: key})
+				}
+				if err != nil {
+					return false, err
+				}
+				continue
+			}
+
+			found, err := l.walk(ctx, path, key, prefix, recursive, startAfter, fn)
+			if err != nil {
+				return false, err
+			}
+			// Empty directories are listed as their marker
+			if found || !strings.HasPrefix(key, prefix) || key <= startAfter {
+				continue
+			}
+		} else {
+			if (!entry.Type().IsRegular() && entry.Type()&fs.ModeSymlink == 0) || IsPartialDownload(path) {
+				continue
+			}
+			if !strings.HasPrefix(key, prefix) || key <= startAfter {
+				continue
+			}
+		}
+
+		obj, err := l.Stat(ctx, key)
+		if IsNotFound(err) {
+			// Removed since the directory was read
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if err := fn(*obj); err != nil {
+			return false, err
+		}
+	}
+	return len(entries) > 0, nil
+}
+
+// sortedEntries reads a directory sorted by key. Keys below a directory
+// sort after its name followed by "/", so a directory is ordered as that.
+func sortedEntries(dir string) ([]fs.DirEntry, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	order := func(entry fs.DirEntry) string {
+		if entry.IsDir() {
+			return entry.Name() + "/"
+		}
+		return entry.Name()
+	}
+	sort.Slice(entries, func(i, j int) bool { return order(entries[i]) < order(entries[j]) })
+	return entries, nil
+}
+
+// Stat returns an object without its content
+func (l *Local) Stat(ctx context.Context, key string) (*Object, error) {
+	path := l.path(key)
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && !storedAs(info, key)) {
+		return nil, fmt.Errorf("failed to get metadata for %s: %w", key, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metadata for %s: %w", key, err)
+	}
+
+	attrs, err := ReadAttrs(path, info)
+	if err != nil {
+		return nil, err
+	}
+
+	// Links and directories are stored without content
+	size, etag := int64(0), emptyETag
+	if info.Mode().IsRegular() {
+		size = info.Size()
+		etag, err = FileETag(path, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Object{
+		Key:          key,
+		Size:         size,
+		LastModified: info.ModTime(),
+		ETag:         etag,
+		Attrs:        attrs,
+		HasMetadata:  true,
+	}, nil
+}
+
+// storedAs reports whether a local file is stored under key: directories
+// under their marker key and regular files and links under any other key
+func storedAs(info os.FileInfo, key string) bool {
+	if strings.HasSuffix(key, "/") {
+		return info.IsDir()
+	}
+	return info.Mode().IsRegular() || info.Mode()&os.ModeSymlink != 0
+}
+
+// Get copies the file stored under key to localPath, keeping its
+// modification time and permissions
+func (l *Local) Get(ctx context.Context, key string, localPath string) error {
+	return l.copyFile(ctx, key, l.path(key), localPath)
+}
+
+// Put copies localPath into the storage under key, keeping its
+// modification time and permissions
+func (l *Local) Put(ctx context.Context, localPath string, key string) (*Object, error) {
+	if err := l.copyFile(ctx, key, localPath, l.path(key)); err != nil {
+		return nil, err
+	}
+	return l.Stat(ctx, key)
+}
+
+// Delete removes the file stored under key and the directories it leaves empty
+func (l *Local) Delete(ctx context.Context, key string) error {
+	path := l.path(key)
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete %s: %w", key, err)
+	}
+
+	// Keys have no directories, so none are kept once empty
+	root := filepath.Clean(l.root)
+	for dir := filepath.Dir(path); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// Copy copies the file stored under srcKey to dstKey
+func (l *Local) Copy(ctx context.Context, srcKey string, dstKey string) error {
+	if err := l.copyFile(ctx, srcKey, l.path(srcKey), l.path(dstKey)); err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", srcKey, dstKey, err)
+	}
+	return nil
+}
+
+// copyFile atomically copies src to dst, keeping its modification time and
+// permissions. Links are copied as links and directories are created.
+func (l *Local) copyFile(ctx context.Context, key string, src string, dst string) error {
+	info, err := os.Lstat(src)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to open %s: %w", key, ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", src, err)
+	}
+	attrs, err := ReadAttrs(src, info)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case attrs.Link != "":
+		return WriteLink(dst, attrs.Link)
+	case info.IsDir():
+		if err := os.MkdirAll(dst, 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", dst, err)
+		}
+		return RestoreAttrs(dst, attrs, false)
+	}
+
+	file, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", src, err)
+	}
+	defer file.Close()
+
+	return writeFile(ctx, dst, file, key, info.Size(), attrs)
+}
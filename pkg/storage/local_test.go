@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newLocalTree creates a local storage holding files, empty directories
+// (keys ending in "/") and links (key to target)
+func newLocalTree(t *testing.T, files []string, links map[string]string) *Local {
+	t.Helper()
+	root := t.TempDir()
+	for _, key := range files {
+		path := filepath.Join(root, filepath.FromSlash(key))
+		if strings.HasSuffix(key, "/") {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(key), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for key, target := range links {
+		if err := WriteLink(filepath.Join(root, filepath.FromSlash(key)), target); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewLocal(root)
+}
+
+func TestLocalList(t *testing.T) {
+	local := newLocalTree(t,
+		[]string{"a.txt", "a/b.txt", "a/bc/y.txt", "a/c.txt", "ab/x.txt", "empty/", "a/empty/"},
+		map[string]string{"link": "a.txt"},
+	)
+
+	tests := []struct {
+		name      string
+		prefix    string
+		recursive bool
+		want      []string
+	}{
+		{"everything", "", true, []string{"a.txt", "a/b.txt", "a/bc/y.txt", "a/c.txt", "a/empty/", "ab/x.txt", "empty/", "link"}},
+		{"top level", "", false, []string{"a.txt", "a/", "ab/", "empty/", "link"}},
+		{"prefix of names in the root", "a", true, []string{"a.txt", "a/b.txt", "a/bc/y.txt", "a/c.txt", "a/empty/", "ab/x.txt"}},
+		{"directory", "a/", true, []string{"a/b.txt", "a/bc/y.txt", "a/c.txt", "a/empty/"}},
+		{"directory top level", "a/", false, []string{"a/b.txt", "a/bc/", "a/c.txt", "a/empty/"}},
+		{"prefix of names in a directory", "a/b", true, []string{"a/b.txt", "a/bc/y.txt"}},
+		{"single file", "a/c.txt", true, []string{"a/c.txt"}},
+		{"empty directory", "empty/", true, []string{"empty/"}},
+		{"missing directory", "missing/", true, nil},
+		{"missing nested directory", "missing/dir/", true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects, err := local.List(context.Background(), tt.prefix, tt.recursive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var keys []string
+			for _, obj := range objects {
+				keys = append(keys, obj.Key)
+			}
+			if !reflect.DeepEqual(keys, tt.want) {
+				t.Errorf("List(%q, %v) = %v, want %v", tt.prefix, tt.recursive, keys, tt.want)
+			}
+
+			var streamed []string
+			err = local.ListEach(context.Background(), tt.prefix, tt.recursive, "", func(obj Object) error {
+				streamed = append(streamed, obj.Key)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(streamed, tt.want) {
+				t.Errorf("ListEach(%q, %v) = %v, want %v", tt.prefix, tt.recursive, streamed, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalListEachStartAfter(t *testing.T) {
+	local := newLocalTree(t, []string{"a.txt", "a/b.txt", "a/bc/y.txt", "a/c.txt", "ab/x.txt"}, nil)
+
+	tests := []struct {
+		startAfter string
+		recursive  bool
+		want       []string
+	}{
+		{"a.txt", true, []string{"a/b.txt", "a/bc/y.txt", "a/c.txt", "ab/x.txt"}},
+		{"a/b.txt", true, []string{"a/bc/y.txt", "a/c.txt", "ab/x.txt"}},
+		{"a/bc/", true, []string{"a/bc/y.txt", "a/c.txt", "ab/x.txt"}},
+		{"a/bc/y.txt", true, []string{"a/c.txt", "ab/x.txt"}},
+		{"a/z", true, []string{"ab/x.txt"}},
+		{"a/", false, []string{"ab/"}},
+		{"ab/x.txt", true, nil},
+	}
+	for _, tt := range tests {
+		var keys []string
+		err := local.ListEach(context.Background(), "", tt.recursive, tt.startAfter, func(obj Object) error {
+			keys = append(keys, obj.Key)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(keys, tt.want) {
+			t.Errorf("ListEach after %q = %v, want %v", tt.startAfter, keys, tt.want)
+		}
+	}
+}
+
+func TestLocalListEachStreams(t *testing.T) {
+	local := newLocalTree(t, []string{"a.txt", "b/x.txt", "b/y.txt", "c.txt"}, nil)
+
+	// Directories are read as the listing reaches them, so one removed
+	// before that is not listed
+	var keys []string
+	err := local.ListEach(context.Background(), "", true, "", func(obj Object) error {
+		keys = append(keys, obj.Key)
+		if obj.Key == "a.txt" {
+			return os.RemoveAll(filepath.Join(local.root, "b"))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a.txt", "c.txt"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("listed %v, want %v", keys, want)
+	}
+
+	// Listing stops with the error of fn
+	errStop := errors.New("stop")
+	calls := 0
+	err = local.ListEach(context.Background(), "", true, "", func(obj Object) error {
+		calls++
+		return errStop
+	})
+	if !errors.Is(err, errStop) || calls != 1 {
+		t.Errorf("ListEach = %v after %d calls, want the error of the first call", err, calls)
+	}
+}
+
+func TestLocalListObjects(t *testing.T) {
+	local := newLocalTree(t, []string{"a.txt", "empty/"}, map[string]string{"link": "a.txt"})
+
+	objects, err := local.List(context.Background(), "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 3 {
+		t.Fatalf("listed %d objects, want 3", len(objects))
+	}
+
+	file, dir, link := objects[0], objects[1], objects[2]
+	sum := md5.Sum([]byte("a.txt"))
+	if file.Size != 5 || file.ETag != hex.EncodeToString(sum[:]) {
+		t.Errorf("file = %+v, want its size and content ETag", file)
+	}
+	if dir.Size != 0 || dir.ETag != emptyETag || dir.Attrs == nil {
+		t.Errorf("empty directory = %+v, want an empty marker with attributes", dir)
+	}
+	if link.Size != 0 || link.Attrs == nil || link.Attrs.Link != "a.txt" {
+		t.Errorf("link = %+v, want an empty object recording its target", link)
+	}
+}
+
+func TestLocalDeleteRemovesEmptyDirectories(t *testing.T) {
+	local := newLocalTree(t, []string{"a/b/c.txt", "a/d.txt"}, nil)
+	ctx := context.Background()
+
+	if err := local.Delete(ctx, "a/b/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(local.root, "a", "b")); !os.IsNotExist(err) {
+		t.Errorf("directory left empty by a deletion was kept: %v", err)
+	}
+	if _, err := local.Stat(ctx, "a/d.txt"); err != nil {
+		t.Errorf("deletion removed another file: %v", err)
+	}
+
+	if err := local.Delete(ctx, "a/d.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(local.root); err != nil {
+		t.Errorf("deletion removed the root: %v", err)
+	}
+	if err := local.Delete(ctx, "missing.txt"); err != nil {
+		t.Errorf("deleting a missing key failed: %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory is a Storage keeping objects in memory, intended for tests
+type Memory struct {
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+}
+
+// memoryObject is the content and metadata of an object held in memory
+type memoryObject struct {
+	data     []byte
+	modified time.Time
+	etag     string
+	attrs    *Attrs
+}
+
+// NewMemory creates an empty in-memory storage
+func NewMemory() *Memory {
+	return &Memory{objects: make(map[string]memoryObject)}
+}
+
+// PutBytes stores data under key with the given modification time
+func (m *Memory) PutBytes(key string, data []byte, modified time.Time) {
+	sum := md5.Sum(data)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = memoryObject{
+		data:     bytes.Clone(data),
+		modified: modified,
+		etag:     hex.EncodeToString(sum[:]),
+	}
+}
+
+// GetBytes returns the content of an object
+func (m *Memory) GetBytes(key string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	obj, ok := m.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("failed to get %s: %w", key, ErrNotFound)
+	}
+	return bytes.Clone(obj.data), nil
+}
+
+// List returns the objects whose key starts with prefix
+func (m *Memory) List(ctx context.Context, prefix string, recursive bool) ([]Object, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	objects := make([]Object, 0, len(m.objects))
+	for key, obj := range m.objects {
+		objects = append(objects, obj.object(key))
+	}
+	return listing(objects, prefix, recursive), nil
+}
+
+// ListEach calls fn with the objects whose key starts with prefix in key
+// order, from a snapshot of the objects taken before the first call
+func (m *Memory) ListEach(ctx context.Context, prefix string, recursive bool, startAfter string, fn func(Object) error) error {
+	objects, err := m.List(ctx, prefix, recursive)
+	if err != nil {
+		return err
+	}
+	return listEach(objects, startAfter, fn)
+}
+
+// Stat returns an object without its content
+func (m *Memory) Stat(ctx context.Context, key string) (*Object, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	obj, ok := m.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("failed to get metadata for %s: %w", key, ErrNotFound)
+	}
+	object := obj.object(key)
+	return &object, nil
+}
+
+// Get writes the content of an object to localPath
+func (m *Memory) Get(ctx context.Context, key string, localPath string) error {
+	m.mu.RLock()
+	obj, ok := m.objects[key]
+	m.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("failed to get %s: %w", key, ErrNotFound)
+	}
+
+	attrs := obj.attrs
+	if attrs == nil {
+		attrs = &Attrs{ModTime: obj.modified, UID: -1, GID: -1}
+	}
+	switch {
+	case attrs.Link != "":
+		return WriteLink(localPath, attrs.Link)
+	case strings.HasSuffix(key, "/"):
+		if err := os.MkdirAll(localPath, 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", localPath, err)
+		}
+		return RestoreAttrs(localPath, attrs, false)
+	}
+	return writeFile(ctx, localPath, bytes.NewReader(obj.data), key, int64(len(obj.data)), attrs)
+}
+
+// Put stores the content of localPath and its attributes under key, modified now
+func (m *Memory) Put(ctx context.Context, localPath string, key string) (*Object, error) {
+	info, err := os.Lstat(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat local file %s: %w", localPath, err)
+	}
+	attrs, err := ReadAttrs(localPath, info)
+	if err != nil {
+		return nil, err
+	}
+
+	// Links and directories are stored without content
+	var data []byte
+	if info.Mode().IsRegular() {
+		data, err = os.ReadFile(localPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read local file %s: %w", localPath, err)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	m.PutBytes(key, data, time.Now())
+	m.mu.Lock()
+	obj := m.objects[key]
+	obj.attrs = attrs
+	m.objects[key] = obj
+	m.mu.Unlock()
+	return m.Stat(ctx, key)
+}
+
+// Delete removes an object
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.objects, key)
+	return nil
+}
+
+// Copy copies an object to another key, keeping its modification time
+func (m *Memory) Copy(ctx context.Context, srcKey string, dstKey string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	obj, ok := m.objects[srcKey]
+	if !ok {
+		return fmt.Errorf("failed to copy %s to %s: %w", srcKey, dstKey, ErrNotFound)
+	}
+	m.objects[dstKey] = obj
+	return nil
+}
+
+// object returns the metadata of an object stored under key
+func (o memoryObject) object(key string) Object {
+	return Object{
+		Key:          key,
+		Size:         int64(len(o.data)),
+		LastModified: o.modified,
+		ETag:         o.etag,
+		Attrs:        o.attrs,
+		HasMetadata:  true,
+	}
+}
@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"os"
+	"strings"
+)
+
+const (
+	// PartialSuffix is appended to the local path of a download in progress
+	PartialSuffix = ".part"
+
+	// PartialMetaSuffix is appended to the partial file path for the sidecar
+	// recording how much of which object version has been received
+	PartialMetaSuffix = ".json"
+)
+
+// IsPartialDownload reports whether a local path is an unfinished download
+// or its sidecar, which must never be treated as a workspace file
+func IsPartialDownload(localPath string) bool {
+	if strings.HasSuffix(localPath, PartialSuffix+PartialMetaSuffix) {
+		_, err := os.Stat(strings.TrimSuffix(localPath, PartialMetaSuffix))
+		return err == nil
+	}
+	if strings.HasSuffix(localPath, PartialSuffix) {
+		_, err := os.Stat(localPath + PartialMetaSuffix)
+		return err == nil
+	}
+	return false
+}
+
+// SyncDir flushes a directory entry so a rename survives a crash
+func SyncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
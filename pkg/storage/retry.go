@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy controls how storage operations failing with a retryable
+// error are retried. Delays grow exponentially with random jitter so that
+// parallel transfers throttled at the same time do not retry in lockstep.
+type RetryPolicy struct {
+	// Retries is the number of times a failed operation is retried,
+	// 0 disables retries
+	Retries int
+
+	// BaseDelay is the delay before the first retry, it doubles on every
+	// further retry
+	BaseDelay time.Duration
+
+	// MaxDelay caps the delay between retries, 0 for no cap
+	MaxDelay time.Duration
+
+	// OnRetry, when not nil, is called before waiting to retry op on key
+	OnRetry func(op string, key string, attempt int, err error, delay time.Duration)
+}
+
+// DefaultRetryPolicy retries an operation four times over about eight seconds
+var DefaultRetryPolicy = RetryPolicy{
+	Retries:   4,
+	BaseDelay: 500 * time.Millisecond,
+	MaxDelay:  30 * time.Second,
+}
+
+// retryableError marks an error as transient
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// Retryable marks err as transient, so that IsRetryable reports true for it.
+// Implementations use it for backend errors such as throttling responses.
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &retryableError{err: err}
+}
+
+// IsRetryable reports whether an operation failing with err may succeed if
+// tried again: errors marked with Retryable, timeouts, dropped connections
+// and truncated responses. Cancellation and missing objects are permanent.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || IsNotFound(err) {
+		return false
+	}
+
+	var marked *retryableError
+	if errors.As(err, &marked) {
+		return true
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	// Requests that never got a response, e.g. because the connection was
+	// closed before the headers were received
+	var urlErr *url.Error
+	return errors.As(err, &urlErr) && errors.Is(urlErr.Err, io.EOF)
+}
+
+// Do calls fn until it succeeds, fails with an error that is not
+// retryable, the retries are exhausted or ctx is cancelled, and returns the
+// last error. op and key describe the operation to OnRetry.
+func (p RetryPolicy) Do(ctx context.Context, op string, key string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt > p.Retries || !IsRetryable(err) || ctx.Err() != nil {
+			return err
+		}
+
+		delay := p.delay(attempt)
+		if p.OnRetry != nil {
+			p.OnRetry(op, key, attempt, err, delay)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+// delay returns how long to wait before retry n, counting from 1: a random
+// duration between half and all of BaseDelay doubled n-1 times
+func (p RetryPolicy) delay(n int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < n && (p.MaxDelay == 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// retrying is a Storage retrying the operations of another one
+type retrying struct {
+	s      Storage
+	policy RetryPolicy
+}
+
+// WithRetry returns a Storage retrying the operations of s that fail with a
+// retryable error according to policy. Interrupted downloads resume where
+// the failed attempt stopped if s supports it.
+func WithRetry(s Storage, policy RetryPolicy) Storage {
+	if policy.Retries <= 0 {
+		return s
+	}
+	return &retrying{s: s, policy: policy}
+}
+
+func (r *retrying) List(ctx context.Context, prefix string, recursive bool) ([]Object, error) {
+	var objects []Object
+	err := r.policy.Do(ctx, "list", prefix, func() (err error) {
+		objects, err = r.s.List(ctx, prefix, recursive)
+		return err
+	})
+	return objects, err
+}
+
+// ListEach resumes a listing that failed part way after the last object
+// passed to fn, so that no object is listed twice
+func (r *retrying) ListEach(ctx context.Context, prefix string, recursive bool, startAfter string, fn func(Object) error) error {
+	var fnErr error
+	err := r.policy.Do(ctx, "list", prefix, func() error {
+		return r.s.ListEach(ctx, prefix, recursive, startAfter, func(obj Object) error {
+			if err := fn(obj); err != nil {
+				fnErr = err
+				return errListingStopped
+			}
+			startAfter = obj.Key
+			return nil
+		})
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	return err
+}
+
+// errListingStopped ends a listing stopped by its callback, which must not
+// be retried even if the callback's error is retryable
+var errListingStopped = errors.New("listing stopped")
+
+func (r *retrying) Stat(ctx context.Context, key string) (*Object, error) {
+	var obj *Object
+	err := r.policy.Do(ctx, "stat", key, func() (err error) {
+		obj, err = r.s.Stat(ctx, key)
+		return err
+	})
+	return obj, err
+}
+
+func (r *retrying) Get(ctx context.Context, key string, localPath string) error {
+	return r.policy.Do(ctx, "download", key, func() error {
+		return r.s.Get(ctx, key, localPath)
+	})
+}
+
+func (r *retrying) Put(ctx context.Context, localPath string, key string) (*Object, error) {
+	var obj *Object
+	err := r.policy.Do(ctx, "upload", key, func() (err error) {
+		obj, err = r.s.Put(ctx, localPath, key)
+		return err
+	})
+	return obj, err
+}
+
+func (r *retrying) Delete(ctx context.Context, key string) error {
+	return r.policy.Do(ctx, "delete", key, func() error {
+		return r.s.Delete(ctx, key)
+	})
+}
+
+func (r *retrying) Copy(ctx context.Context, srcKey string, dstKey string) error {
+	return r.policy.Do(ctx, "copy", srcKey, func() error {
+		return r.s.Copy(ctx, srcKey, dstKey)
+	})
+}
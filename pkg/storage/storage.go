@@ -0,0 +1,221 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned, possibly wrapped, for a key that does not exist
+var ErrNotFound = errors.New("object not found")
+
+// Object represents a file stored under a key
+type Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+
+	// ETag is the S3 ETag of the object, or the one S3 would report for
+	// the same content uploaded in a single part
+	ETag string
+
+	// PartSize is the multipart part size recorded at upload time.
+	// It is 0 when unknown or when the metadata was not read.
+	PartSize int64
+
+	// Attrs are the attributes of the file the object was uploaded from.
+	// They are nil when unknown or when the metadata was not read.
+	Attrs *Attrs
+
+	// HasMetadata reports whether PartSize and Attrs were read with the
+	// object, so that their zero values mean the object has none. Stat and
+	// Put always read them, listings only on servers that include them.
+	HasMetadata bool
+}
+
+// Storage is a flat namespace of objects addressed by slash-separated keys,
+// such as an S3 bucket. Objects are transferred to and from local files so
+// implementations can download atomically and resume interrupted transfers.
+type Storage interface {
+	// List returns the objects whose key starts with prefix, sorted by key.
+	// Without recursive, keys are grouped at the first "/" after the prefix
+	// and each group is returned once as an object whose key ends in "/".
+	List(ctx context.Context, prefix string, recursive bool) ([]Object, error)
+
+	// ListEach calls fn with the objects List would return that sort after
+	// startAfter, or all of them if startAfter is empty, in key order.
+	// Unlike List it does not hold the whole listing in memory, so it suits
+	// buckets of any size. Listing stops with the error of fn.
+	ListEach(ctx context.Context, prefix string, recursive bool, startAfter string, fn func(Object) error) error
+
+	// Stat returns an object without its content
+	Stat(ctx context.Context, key string) (*Object, error)
+
+	// Get writes the content of an object to localPath, replacing it only
+	// once the whole object has been received, and restores the attributes
+	// recorded at upload. Objects without attributes keep the default
+	// permissions and get the object's modification time. Objects stored
+	// from a symbolic link are restored as a link, and directory markers,
+	// whose key ends in "/", as a directory.
+	Get(ctx context.Context, key string, localPath string) error
+
+	// Put stores the content of localPath under key along with the file's
+	// attributes and returns the stored object. A symbolic link is stored
+	// as an empty object recording its target instead of being followed,
+	// and a directory as an empty marker whose key should end in "/".
+	Put(ctx context.Context, localPath string, key string) (*Object, error)
+
+	// Delete removes an object, deleting a missing key is not an error
+	Delete(ctx context.Context, key string) error
+
+	// Copy copies an object to another key
+	Copy(ctx context.Context, srcKey string, dstKey string) error
+}
+
+// IsNotFound reports whether an error means the object does not exist
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+// ProgressFunc receives the number of bytes of an object transferred so far
+type ProgressFunc func(key string, transferred int64, total int64)
+
+// progressKey is the context key of the ProgressFunc set by WithProgress
+type progressKey struct{}
+
+// WithProgress returns a context whose transfers of large files report
+// their progress to fn
+func WithProgress(ctx context.Context, fn ProgressFunc) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+// ProgressFromContext returns the ProgressFunc set on ctx, nil if there is none
+func ProgressFromContext(ctx context.Context) ProgressFunc {
+	fn, _ := ctx.Value(progressKey{}).(ProgressFunc)
+	return fn
+}
+
+// progressInterval is how many bytes are copied between progress reports
+// of the local and in-memory storages, files smaller than it are not reported
+const progressInterval = 10 * 1024 * 1024
+
+// copyContent copies src to dst, stopping when ctx is cancelled and
+// reporting the progress of large objects to the ProgressFunc of ctx
+func copyContent(ctx context.Context, dst io.Writer, src io.Reader, key string, size int64) error {
+	report := ProgressFromContext(ctx)
+	if size <= progressInterval {
+		report = nil
+	}
+
+	buf := make([]byte, 1024*1024)
+	var copied, reported int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			copied += int64(n)
+			if report != nil && copied-reported >= progressInterval {
+				reported = copied
+				report(key, copied, size)
+			}
+		}
+		if err == io.EOF {
+			if report != nil && reported != copied {
+				report(key, copied, size)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// writeFile atomically replaces path with the content of src. The data is
+// written to a partial file next to path, given the attributes attrs,
+// flushed to disk and renamed over it once complete, so that a crash never
+// leaves an empty or truncated file under path.
+func writeFile(ctx context.Context, path string, src io.Reader, key string, size int64, attrs *Attrs) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+
+	partPath := path + PartialSuffix
+	file, err := os.Create(partPath)
+	if err != nil {
+		return fmt.Errorf("failed to create local file %s: %w", partPath, err)
+	}
+
+	err = copyContent(ctx, file, src, key, size)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = RestoreAttrs(partPath, attrs, false)
+	}
+	if err == nil {
+		err = os.Rename(partPath, path)
+	}
+	if err != nil {
+		os.Remove(partPath)
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	SyncDir(dir)
+	return nil
+}
+
+// listEach calls fn with the objects of a sorted listing that sort after
+// startAfter, for storages that hold their listing in memory anyway
+func listEach(objects []Object, startAfter string, fn func(Object) error) error {
+	for _, obj := range objects {
+		if obj.Key <= startAfter {
+			continue
+		}
+		if err := fn(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listing filters objects by prefix, groups them by directory unless
+// recursive, and sorts them by key as S3 does
+func listing(objects []Object, prefix string, recursive bool) []Object {
+	var result []Object
+	groups := make(map[string]bool)
+	for _, obj := range objects {
+		if !strings.HasPrefix(obj.Key, prefix) {
+			continue
+		}
+		if !recursive {
+			if i := strings.Index(obj.Key[len(prefix):], "/"); i >= 0 {
+				group := obj.Key[:len(prefix)+i+1]
+				if !groups[group] {
+					groups[group] = true
+					result = append(result, Object{Key: group})
+				}
+				continue
+			}
+		}
+		result = append(result, obj)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result
+}
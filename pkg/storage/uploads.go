@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// Upload is a multipart upload that was started but neither completed nor
+// aborted, such as one left behind by an interrupted Put. Its parts take up
+// space in the bucket until it is aborted.
+type Upload struct {
+	Key       string    `json:"key"`
+	ID        string    `json:"upload_id"`
+	Initiated time.Time `json:"initiated"`
+
+	// Size is the total size of the parts uploaded so far
+	Size int64 `json:"size"`
+}
+
+// Uploads is implemented by storages that upload large objects in parts
+// and can list and abort the uploads that were never completed
+type Uploads interface {
+	// ListUploads returns the incomplete uploads whose key starts with
+	// prefix, sorted by key then initiation time
+	ListUploads(ctx context.Context, prefix string) ([]Upload, error)
+
+	// AbortUpload aborts an incomplete upload and deletes its parts
+	AbortUpload(ctx context.Context, upload Upload) error
+}
+
+// UploadsOf returns the Uploads of s, looking through the retrying storage
+// returned by WithRetry, or nil if s has no incomplete uploads to manage
+func UploadsOf(s Storage) Uploads {
+	switch s := s.(type) {
+	case Uploads:
+		return s
+	case *retrying:
+		if u := UploadsOf(s.s); u != nil {
+			return &retryingUploads{u: u, policy: s.policy}
+		}
+	}
+	return nil
+}
+
+// retryingUploads retries the operations of the Uploads of a retrying storage
+type retryingUploads struct {
+	u      Uploads
+	policy RetryPolicy
+}
+
+func (r *retryingUploads) ListUploads(ctx context.Context, prefix string) ([]Upload, error) {
+	var uploads []Upload
+	err := r.policy.Do(ctx, "list uploads", prefix, func() (err error) {
+		uploads, err = r.u.ListUploads(ctx, prefix)
+		return err
+	})
+	return uploads, err
+}
+
+func (r *retryingUploads) AbortUpload(ctx context.Context, upload Upload) error {
+	return r.policy.Do(ctx, "abort upload", upload.Key, func() error {
+		return r.u.AbortUpload(ctx, upload)
+	})
+}
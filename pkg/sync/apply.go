@@ -0,0 +1,222 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vngcloud/aiplatform-util/pkg/storage"
+)
+
+// ApplyOptions contains options for applying a plan
+type ApplyOptions struct {
+	MountPath string
+
+	// Parallel is the number of files transferred concurrently
+	Parallel int
+
+	// Trash moves deleted files to the trash instead of removing them
+	Trash bool
+
+	// Observer receives the progress of every file, nil to ignore it
+	Observer Observer
+}
+
+// ApplyStats contains statistics about applying a plan.
+// Counters are safe to update from concurrent transfers.
+type ApplyStats struct {
+	Uploaded      int `json:"uploaded"`
+	Downloaded    int `json:"downloaded"`
+	DeletedLocal  int `json:"deleted_local"`
+	DeletedRemote int `json:"deleted_remote"`
+	Refused       int `json:"refused"`
+	Failed        int `json:"failed"`
+
+	// FailedKeys lists the keys that failed, sorted
+	FailedKeys []string `json:"failed_keys,omitempty"`
+
+	mu sync.Mutex
+}
+
+// inc increments one of the stats counters
+func (s *ApplyStats) inc(counter *int) {
+	s.mu.Lock()
+	*counter++
+	s.mu.Unlock()
+}
+
+// fail counts a failed action on key
+func (s *ApplyStats) fail(key string) {
+	s.mu.Lock()
+	s.Failed++
+	s.FailedKeys = append(s.FailedKeys, key)
+	s.mu.Unlock()
+}
+
+// Apply performs exactly the actions of a plan. Before each action the
+// local file and remote object are checked against the state recorded in
+// the plan, and actions whose preconditions changed are refused instead of
+// performed, so nothing is applied that was not reviewed. If the run is
+// interrupted, the stats of the work done so far are returned with the error.
+func Apply(ctx context.Context, client storage.Storage, plan *Plan, opts ApplyOptions) (*ApplyStats, error) {
+	stats := &ApplyStats{}
+
+	if filepath.Clean(plan.MountPath) != filepath.Clean(opts.MountPath) {
+		return nil, fmt.Errorf("plan was made for workspace %s, not %s", plan.MountPath, opts.MountPath)
+	}
+
+	// Load the record of previously synced files
+	state, err := LoadState(opts.MountPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var trash *Trash
+	if opts.Trash {
+		trash = NewTrash(client, opts.MountPath, time.Now())
+	}
+
+	observer := observerOrNop(opts.Observer)
+	ctx = storage.WithProgress(ctx, observer.Progress)
+	for _, step := range plan.Steps {
+		schedule(observer, step)
+	}
+	decided(observer)
+
+	p := newPool(ctx, opts.Parallel, observer)
+	for _, step := range plan.Steps {
+		if p.Err() != nil {
+			break
+		}
+
+		p.Go(func(ctx context.Context, obs Observer) error {
+			return applyStep(ctx, obs, client, trash, state, stats, step, opts.MountPath, plan.Symlinks)
+		})
+	}
+	err = p.Wait()
+
+	// Persist progress even if the run was interrupted
+	if saveErr := state.Save(); saveErr != nil {
+		observer.Warning(saveErr)
+	}
+	sort.Strings(stats.FailedKeys)
+	if err != nil {
+		return stats, fmt.Errorf("apply interrupted: %w", err)
+	}
+	return stats, nil
+}
+
+// applyStep checks the preconditions of a planned action and performs it.
+// Uploads planned with followed links read the file the link points to.
+func applyStep(ctx context.Context, obs Observer, client storage.Storage, trash *Trash, state *State, stats *ApplyStats, step PlanStep, mountPath string, links SymlinkPolicy) error {
+	key := step.Key
+	localPath := filepath.Join(mountPath, filepath.FromSlash(key))
+	if step.Action == PlanUpload && links == FollowSymlinks {
+		if target, err := filepath.EvalSymlinks(localPath); err == nil {
+			localPath = target
+		}
+	}
+
+	localInfo, err := checkPreconditions(ctx, client, step, localPath)
+	if err != nil {
+		obs.Failed(FileEvent{Action: EventRefuse, Key: key, Reason: string(step.Action), Size: step.Size}, err)
+		stats.inc(&stats.Refused)
+		return ctx.Err()
+	}
+
+	event := FileEvent{Key: key, Reason: step.Reason, Size: step.Size}
+	switch step.Action {
+	case PlanUpload:
+		event.Action = EventUpload
+	case PlanDownload:
+		event.Action = EventDownload
+	case PlanDeleteLocal:
+		event.Action = EventDeleteLocal
+	case PlanDeleteRemote:
+		event.Action = EventDeleteRemote
+	}
+	obs.Planned(event)
+	obs.Started(event)
+
+	switch step.Action {
+	case PlanUpload:
+		uploaded, err := client.Put(ctx, localPath, key)
+		if err != nil {
+			obs.Failed(event, err)
+			stats.fail(key)
+			return ctx.Err()
+		}
+		state.Record(key, localInfo, uploaded.ETag)
+		stats.inc(&stats.Uploaded)
+		obs.Completed(event)
+
+	case PlanDownload:
+		if err := client.Get(ctx, key, localPath); err != nil {
+			obs.Failed(event, err)
+			stats.fail(key)
+			return ctx.Err()
+		}
+		if info, err := os.Lstat(localPath); err == nil {
+			state.Record(key, info, step.RemoteETag)
+		}
+		stats.inc(&stats.Downloaded)
+		obs.Completed(event)
+
+	case PlanDeleteLocal:
+		if err := deleteLocal(trash, key, localPath); err != nil {
+			obs.Failed(event, err)
+			stats.fail(key)
+			return nil
+		}
+		state.Delete(key)
+		stats.inc(&stats.DeletedLocal)
+		obs.Deleted(event)
+
+	case PlanDeleteRemote:
+		if err := deleteRemote(ctx, client, trash, key); err != nil {
+			obs.Failed(event, err)
+			stats.fail(key)
+			return ctx.Err()
+		}
+		state.Delete(key)
+		stats.inc(&stats.DeletedRemote)
+		obs.Deleted(event)
+	}
+
+	return nil
+}
+
+// checkPreconditions verifies that the local file and remote object of a
+// step are still in the state recorded in the plan and returns the current
+// local file info, nil if the file does not exist
+func checkPreconditions(ctx context.Context, client storage.Storage, step PlanStep, localPath string) (os.FileInfo, error) {
+	meta, err := client.Stat(ctx, step.Key)
+	switch {
+	case err != nil && !storage.IsNotFound(err):
+		return nil, err
+	case err != nil && step.RemoteETag != "":
+		return nil, fmt.Errorf("remote file was deleted since the plan was made")
+	case err == nil && step.RemoteETag == "":
+		return nil, fmt.Errorf("remote file was created since the plan was made")
+	case err == nil && meta.ETag != step.RemoteETag:
+		return nil, fmt.Errorf("remote file changed since the plan was made")
+	}
+
+	info, err := os.Lstat(localPath)
+	switch {
+	case err != nil && !os.IsNotExist(err):
+		return nil, err
+	case err != nil && step.Local != nil:
+		return nil, fmt.Errorf("local file was deleted since the plan was made")
+	case err == nil && step.Local == nil:
+		return nil, fmt.Errorf("local file was created since the plan was made")
+	case err == nil && (info.Size() != step.Local.Size || !info.ModTime().Equal(step.Local.ModTime)):
+		return nil, fmt.Errorf("local file changed since the plan was made")
+	}
+
+	return info, nil
+}
@@ -0,0 +1,694 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vngcloud/aiplatform-util/pkg/ignore"
+	"github.com/vngcloud/aiplatform-util/pkg/storage"
+)
+
+// ConflictPolicy selects how a path changed on both sides is resolved
+type ConflictPolicy string
+
+const (
+	// PreferLocal keeps the local version of a conflicting path
+	PreferLocal ConflictPolicy = "local"
+
+	// PreferRemote keeps the remote version of a conflicting path
+	PreferRemote ConflictPolicy = "remote"
+
+	// PreferNewer keeps whichever version was modified last
+	PreferNewer ConflictPolicy = "newer"
+
+	// KeepBoth keeps the remote version at the original path and the local
+	// version under a .conflict-<timestamp> suffix on both sides
+	KeepBoth ConflictPolicy = "both"
+)
+
+// ParseConflictPolicy validates a conflict policy name
+func ParseConflictPolicy(name string) (ConflictPolicy, error) {
+	switch policy := ConflictPolicy(name); policy {
+	case PreferLocal, PreferRemote, PreferNewer, KeepBoth:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid conflict policy %q (expected local, remote, newer or both)", name)
+}
+
+// SyncOptions contains options for bidirectional sync operations
+type SyncOptions struct {
+	Prefix       string
+	DryRun       bool
+	IncludeGlobs []string
+	ExcludeGlobs []string
+	MountPath    string
+
+	// Parallel is the number of files transferred concurrently
+	Parallel int
+
+	// Checksum compares file content to detect local changes when only
+	// the modification time differs from the last sync
+	Checksum bool
+
+	// Prefer resolves paths changed on both sides
+	Prefer ConflictPolicy
+
+	// Limits guards the deletions propagated from either side
+	Limits DeleteLimits
+
+	// Trash moves deleted files to the trash instead of removing them
+	Trash bool
+
+	// Keys, when not nil, restricts the run to these keys, e.g. the keys
+	// that failed in an earlier run. Delete limits still count every file.
+	Keys []string
+
+	// Symlinks is how local symbolic links are synced, skipped if empty
+	Symlinks SymlinkPolicy
+
+	// Observer receives the progress of every file, nil to ignore it
+	Observer Observer
+}
+
+// Conflict describes a path that changed on both sides and how it was resolved
+type Conflict struct {
+	Key        string `json:"key"`
+	Reason     string `json:"reason"`
+	Resolution string `json:"resolution"`
+}
+
+// SyncStats contains statistics about a bidirectional sync operation.
+// Counters are safe to update from concurrent transfers.
+type SyncStats struct {
+	Uploaded      int        `json:"uploaded"`
+	Downloaded    int        `json:"downloaded"`
+	DeletedLocal  int        `json:"deleted_local"`
+	DeletedRemote int        `json:"deleted_remote"`
+	Skipped       int        `json:"skipped"`
+	Failed        int        `json:"failed"`
+	Conflicts     []Conflict `json:"conflicts"`
+
+	// FailedKeys lists the keys that failed, sorted
+	FailedKeys []string `json:"failed_keys,omitempty"`
+
+	mu sync.Mutex
+}
+
+// inc increments one of the stats counters
+func (s *SyncStats) inc(counter *int) {
+	s.mu.Lock()
+	*counter++
+	s.mu.Unlock()
+}
+
+// fail counts a failed action on key
+func (s *SyncStats) fail(key string) {
+	s.mu.Lock()
+	s.Failed++
+	s.FailedKeys = append(s.FailedKeys, key)
+	s.mu.Unlock()
+}
+
+// syncAction is the operation chosen for a single path
+type syncAction int
+
+const (
+	actionNone syncAction = iota
+	actionUpload
+	actionDownload
+	actionDeleteLocal
+	actionDeleteRemote
+	actionKeepBoth
+	actionForget
+)
+
+// syncDecision is the action chosen for a path and why. Conflicts carry a
+// description of how they were resolved for the summary, and warning is why
+// the two sides could not be compared.
+type syncDecision struct {
+	action     syncAction
+	reason     string
+	conflict   bool
+	resolution string
+	warning    error
+}
+
+// syncPath holds everything known about a path on both sides
+type syncPath struct {
+	key       string
+	localPath string
+	local     os.FileInfo
+	remote    *storage.Object
+	baseline  *StateEntry
+}
+
+// Bidirectional reconciles the local workspace and the network volume using
+// the last synced state as a baseline. Each path is classified as changed
+// locally, changed remotely, changed on both sides (a conflict resolved by
+// opts.Prefer) or deleted on either side. The local files, the remote
+// listing and the baseline are merged in key order, and each path is
+// reconciled as the merge reaches it. The baseline is held in memory and
+// its keys are sorted for each merge, so memory use is not bounded by the
+// streaming of the listings but grows with the number of synced files.
+// If the run is interrupted once transfers started, the stats of the work
+// done so far are returned with the error.
+func Bidirectional(ctx context.Context, client storage.Storage, opts SyncOptions) (*SyncStats, error) {
+	stats := &SyncStats{}
+	observer := observerOrNop(opts.Observer)
+
+	// Load the baseline of the last sync
+	state, err := LoadState(opts.MountPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Count the deletions before changing anything, so that exceeding a
+	// delete limit aborts the run with nothing changed
+	counts, err := mergeSync(ctx, client, opts, state, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := counts.check(opts.Limits); err != nil {
+		return nil, err
+	}
+	localBudget := &deletionBudget{left: counts.deleteLocal}
+	remoteBudget := &deletionBudget{left: counts.deleteRemote}
+
+	var trash *Trash
+	if opts.Trash {
+		trash = NewTrash(client, opts.MountPath, time.Now())
+	}
+	ctx = storage.WithProgress(ctx, observer.Progress)
+
+	p := newPool(ctx, opts.Parallel, observer)
+	sched := &scheduler{obs: observer}
+
+	// Warnings of the walk are reported in order with the files
+	warn := func(err error) {
+		p.Go(func(_ context.Context, obs Observer) error {
+			obs.Warning(err)
+			return nil
+		})
+	}
+
+	reconcile := func(path *syncPath) error {
+		sched.submit(p, func(ctx context.Context, obs Observer) error {
+			decision := classify(ctx, client, path, opts)
+			if decision.warning != nil {
+				obs.Warning(decision.warning)
+			}
+			if (decision.action == actionDeleteLocal && !localBudget.take()) || (decision.action == actionDeleteRemote && !remoteBudget.take()) {
+				sched.decide()
+				obs.Warning(errOverBudget(path.key))
+				return nil
+			}
+			sched.decide(syncSteps(path, decision)...)
+			return applySyncAction(ctx, obs, client, trash, state, stats, path, decision, opts)
+		})
+		return p.Err()
+	}
+
+	if _, err := mergeSync(ctx, client, opts, state, warn, reconcile); err != nil {
+		p.fail(err)
+	}
+	sched.done()
+	err = p.Wait()
+
+	// Persist progress even if the run was interrupted
+	if !opts.DryRun {
+		if saveErr := state.Save(); saveErr != nil {
+			observer.Warning(saveErr)
+		}
+	}
+	sort.Slice(stats.Conflicts, func(i, j int) bool {
+		return stats.Conflicts[i].Key < stats.Conflicts[j].Key
+	})
+	sort.Strings(stats.FailedKeys)
+	if err != nil {
+		return stats, fmt.Errorf("sync interrupted: %w", err)
+	}
+	return stats, nil
+}
+
+// syncCounts are the numbers the delete limits of a sync are checked
+// against: the files to delete on each side and the files on each side
+type syncCounts struct {
+	local        int
+	remote       int
+	deleteLocal  int
+	deleteRemote int
+}
+
+// check checks the deletions on each side against the delete limits
+func (c syncCounts) check(limits DeleteLimits) error {
+	if err := limits.check(c.deleteLocal, c.local, c.remote == 0); err != nil {
+		return fmt.Errorf("local side: %w", err)
+	}
+	if err := limits.check(c.deleteRemote, c.remote, c.local == 0); err != nil {
+		return fmt.Errorf("remote side: %w", err)
+	}
+	return nil
+}
+
+// mergeSync merges the local files, the remote listing and the baseline of
+// the last sync in key order, and calls reconcile with every path to
+// reconcile. reconcile may be nil to only count the files, warn receives
+// the warnings of the walk.
+func mergeSync(ctx context.Context, client storage.Storage, opts SyncOptions, state *State, warn func(error), reconcile func(path *syncPath) error) (syncCounts, error) {
+	var counts syncCounts
+
+	// Walk local directory, skipping excluded and ignored files
+	filter := ignore.NewFilter(opts.Prefix, opts.IncludeGlobs, opts.ExcludeGlobs)
+	matcher := ignore.New()
+	only := newKeySet(opts.Keys)
+	skippedLinks := make(map[string]bool)
+	walk := walkFiles(opts.MountPath, opts.Prefix, matcher, filter, walkOptions{links: opts.Symlinks, warn: warn, skipped: skippedLinks})
+	list := func(fn func(storage.Object) error) error {
+		if err := client.ListEach(ctx, opts.Prefix, true, "", fn); err != nil {
+			return fmt.Errorf("failed to list remote objects: %w", err)
+		}
+		return nil
+	}
+
+	// visit counts a path and passes it on. Skipped links are left alone
+	// on both sides. When only counting, just the paths that may be
+	// deleted are classified, which needs no request.
+	visit := func(path *syncPath) error {
+		if path.local != nil {
+			counts.local++
+		}
+		if path.remote != nil {
+			counts.remote++
+		}
+		if !only.selected(path.key) || skippedLinks[path.key] {
+			return nil
+		}
+		if reconcile != nil {
+			return reconcile(path)
+		}
+		if path.baseline != nil && (path.local == nil) != (path.remote == nil) {
+			switch classify(ctx, client, path, opts).action {
+			case actionDeleteLocal:
+				counts.deleteLocal++
+			case actionDeleteRemote:
+				counts.deleteRemote++
+			}
+		}
+		return nil
+	}
+
+	// baseline returns the entry recorded for key at the last sync, after
+	// visiting the keys before it recorded only in the baseline, which were
+	// deleted on both sides. An empty key visits all the remaining ones.
+	// The walk has passed these keys, so the .nvignore files that apply to
+	// them are known.
+	synced := state.Keys(opts.Prefix)
+	baseline := func(key string) (*StateEntry, error) {
+		for len(synced) > 0 && (key == "" || synced[0] <= key) {
+			next := synced[0]
+			synced = synced[1:]
+			entry, ok := state.Get(next)
+			if !ok || !filter.Selected(next, false) || matcher.Match(next, false) {
+				continue
+			}
+			if next == key {
+				return &entry, nil
+			}
+			err := visit(&syncPath{key: next, localPath: filepath.Join(opts.MountPath, filepath.FromSlash(next)), baseline: &entry})
+			if err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	}
+
+	err := joinListings(ctx, walk, list, func(local *localFile, remote *storage.Object) error {
+		// Remote files are filtered with the patterns loaded while walking
+		if remote != nil && (strings.HasSuffix(remote.Key, "/") || isInternalPath(remote.Key) || !filter.Selected(remote.Key, false) || matcher.Match(remote.Key, false)) {
+			remote = nil
+		}
+		if local == nil && remote == nil {
+			return nil
+		}
+
+		path := &syncPath{}
+		if local != nil {
+			// Files reached through followed links are read and written
+			// where the link points to
+			path.key, path.local, path.localPath = local.key, local.info, local.path
+		}
+		if remote != nil {
+			obj := *remote
+			path.key, path.remote = obj.Key, &obj
+			if local == nil {
+				path.localPath = filepath.Join(opts.MountPath, filepath.FromSlash(obj.Key))
+			}
+		}
+
+		entry, err := baseline(path.key)
+		if err != nil {
+			return err
+		}
+		path.baseline = entry
+		return visit(path)
+	})
+	if err != nil {
+		return counts, err
+	}
+	_, err = baseline("")
+	return counts, err
+}
+
+// syncSteps lists the transfers and deletions of the decision made for a
+// path. Keeping both versions of a path uploads the local one and
+// downloads the remote one.
+func syncSteps(path *syncPath, decision syncDecision) []PlanStep {
+	var localSize, remoteSize int64
+	if path.local != nil {
+		localSize = path.local.Size()
+	}
+	if path.remote != nil {
+		remoteSize = path.remote.Size
+	}
+
+	switch decision.action {
+	case actionUpload:
+		return []PlanStep{{Action: PlanUpload, Key: path.key, Size: localSize}}
+	case actionDownload:
+		return []PlanStep{{Action: PlanDownload, Key: path.key, Size: remoteSize}}
+	case actionDeleteLocal:
+		return []PlanStep{{Action: PlanDeleteLocal, Key: path.key, Size: localSize}}
+	case actionDeleteRemote:
+		return []PlanStep{{Action: PlanDeleteRemote, Key: path.key, Size: remoteSize}}
+	case actionKeepBoth:
+		return []PlanStep{
+			{Action: PlanUpload, Key: path.key, Size: localSize},
+			{Action: PlanDownload, Key: path.key, Size: remoteSize},
+		}
+	}
+	return nil
+}
+
+// classify decides what to do with a path based on its local, remote and
+// baseline state
+func classify(ctx context.Context, client storage.Storage, path *syncPath, opts SyncOptions) syncDecision {
+	local, remote, baseline := path.local, path.remote, path.baseline
+
+	// Without a baseline the path was never synced
+	if baseline == nil {
+		switch {
+		case local != nil && remote == nil:
+			return syncDecision{action: actionUpload, reason: "new local file"}
+		case local == nil && remote != nil:
+			return syncDecision{action: actionDownload, reason: "new remote file"}
+		}
+
+		// Present on both sides: identical content is simply recorded
+		same, err := sameContent(ctx, client, path)
+		if same {
+			return syncDecision{action: actionNone}
+		}
+		decision := resolveConflict(ctx, client, path, "created on both sides", opts.Prefer)
+		decision.warning = errors.Join(err, decision.warning)
+		return decision
+	}
+
+	localChanged := local != nil && changedSince(path.localPath, local, *baseline, opts.Checksum)
+	remoteChanged := remote != nil && remote.ETag != baseline.ETag
+
+	switch {
+	case local == nil && remote == nil:
+		return syncDecision{action: actionForget}
+
+	case local == nil:
+		if !remoteChanged {
+			return syncDecision{action: actionDeleteRemote, reason: "deleted locally"}
+		}
+		// Only an explicit preference for the local side loses remote changes
+		reason := "deleted locally, modified remotely"
+		if opts.Prefer == PreferLocal {
+			return syncDecision{action: actionDeleteRemote, reason: reason, conflict: true, resolution: "deleted remote"}
+		}
+		return syncDecision{action: actionDownload, reason: reason, conflict: true, resolution: "kept remote"}
+
+	case remote == nil:
+		if !localChanged {
+			return syncDecision{action: actionDeleteLocal, reason: "deleted remotely"}
+		}
+		// Only an explicit preference for the remote side loses local changes
+		reason := "deleted remotely, modified locally"
+		if opts.Prefer == PreferRemote {
+			return syncDecision{action: actionDeleteLocal, reason: reason, conflict: true, resolution: "deleted local"}
+		}
+		return syncDecision{action: actionUpload, reason: reason, conflict: true, resolution: "kept local"}
+
+	case localChanged && remoteChanged:
+		// Both sides may have converged on the same content
+		same, err := sameContent(ctx, client, path)
+		if same {
+			return syncDecision{action: actionNone}
+		}
+		decision := resolveConflict(ctx, client, path, "changed on both sides", opts.Prefer)
+		decision.warning = errors.Join(err, decision.warning)
+		return decision
+
+	case localChanged:
+		return syncDecision{action: actionUpload, reason: "local changed"}
+
+	case remoteChanged:
+		return syncDecision{action: actionDownload, reason: "remote changed"}
+	}
+
+	return syncDecision{action: actionNone}
+}
+
+// sameContent reports whether the local file and remote object of a path
+// present on both sides hold identical content, or links to the same target.
+// The error tells why they could not be compared.
+func sameContent(ctx context.Context, client storage.Storage, path *syncPath) (bool, error) {
+	if isLink(path.local) {
+		return linkMatches(ctx, client, path.localPath, *path.remote)
+	}
+	if path.local.Size() != path.remote.Size {
+		return false, nil
+	}
+	return contentMatches(ctx, client, path.localPath, *path.remote)
+}
+
+// resolveConflict picks the action for a path present and changed on both
+// sides according to the conflict policy
+func resolveConflict(ctx context.Context, client storage.Storage, path *syncPath, reason string, prefer ConflictPolicy) syncDecision {
+	keepLocal := syncDecision{action: actionUpload, reason: reason, conflict: true, resolution: "kept local"}
+	keepRemote := syncDecision{action: actionDownload, reason: reason, conflict: true, resolution: "kept remote"}
+
+	switch prefer {
+	case PreferLocal:
+		return keepLocal
+	case PreferRemote:
+		return keepRemote
+	case PreferNewer:
+		// Objects whose metadata cannot be read are dated by their upload
+		remoteTime, err := remoteModTime(ctx, client, *path.remote)
+		if path.local.ModTime().After(remoteTime) {
+			keepLocal.warning = err
+			return keepLocal
+		}
+		keepRemote.warning = err
+		return keepRemote
+	default:
+		return syncDecision{action: actionKeepBoth, reason: reason, conflict: true, resolution: "kept both"}
+	}
+}
+
+// changedSince reports whether a local file differs from its baseline.
+// A file whose size matches but whose mtime moved is only considered
+// changed, when checksums are enabled, if its content does not reproduce
+// the ETag it was synced with.
+func changedSince(localPath string, info os.FileInfo, baseline StateEntry, checksum bool) bool {
+	if info.Size() != baseline.Size {
+		return true
+	}
+	if info.ModTime().Equal(baseline.ModTime) {
+		return false
+	}
+	if !checksum || isLink(info) {
+		return true
+	}
+
+	// Multipart ETags are reproduced with the part size files are uploaded
+	// with, anything else cannot be verified
+	partSize, ok := storage.ETagPartSize(baseline.ETag, info.Size(), storage.PartSize(info.Size()))
+	if !ok {
+		return true
+	}
+	etag, err := storage.FileETag(localPath, partSize)
+	return err != nil || !strings.EqualFold(etag, baseline.ETag)
+}
+
+// applySyncAction performs the action chosen for a path and updates the
+// state and statistics accordingly. Deleted files are moved to trash when
+// it is not nil.
+func applySyncAction(ctx context.Context, obs Observer, client storage.Storage, trash *Trash, state *State, stats *SyncStats, path *syncPath, decision syncDecision, opts SyncOptions) error {
+	key, reason := path.key, decision.reason
+
+	// Conflicts are reported in the summary with their resolution
+	if decision.conflict {
+		reason = "conflict: " + reason
+		stats.mu.Lock()
+		stats.Conflicts = append(stats.Conflicts, Conflict{
+			Key:        key,
+			Reason:     decision.reason,
+			Resolution: decision.resolution,
+		})
+		stats.mu.Unlock()
+	}
+
+	var localSize, remoteSize int64
+	if path.local != nil {
+		localSize = path.local.Size()
+	}
+	if path.remote != nil {
+		remoteSize = path.remote.Size
+	}
+
+	switch decision.action {
+	case actionNone:
+		if !opts.DryRun {
+			// Refresh the baseline so identical content is not compared again
+			if path.local != nil && path.remote != nil {
+				state.Record(key, path.local, path.remote.ETag)
+			}
+			stats.inc(&stats.Skipped)
+		}
+		obs.Skipped(FileEvent{Action: EventSkip, Key: key, Reason: "in sync", Size: localSize})
+
+	case actionForget:
+		if !opts.DryRun {
+			state.Delete(key)
+		}
+
+	case actionUpload:
+		event := FileEvent{Action: EventUpload, Key: key, Reason: reason, Size: localSize}
+		obs.Planned(event)
+		if !opts.DryRun {
+			obs.Started(event)
+			uploaded, err := client.Put(ctx, path.localPath, key)
+			if err != nil {
+				obs.Failed(event, err)
+				stats.fail(key)
+				return ctx.Err()
+			}
+			state.Record(key, path.local, uploaded.ETag)
+			stats.inc(&stats.Uploaded)
+			obs.Completed(event)
+		}
+
+	case actionDownload:
+		event := FileEvent{Action: EventDownload, Key: key, Reason: reason, Size: remoteSize}
+		obs.Planned(event)
+		if !opts.DryRun {
+			obs.Started(event)
+			if err := client.Get(ctx, key, path.localPath); err != nil {
+				obs.Failed(event, err)
+				stats.fail(key)
+				return ctx.Err()
+			}
+			if info, err := os.Lstat(path.localPath); err == nil {
+				state.Record(key, info, path.remote.ETag)
+			}
+			stats.inc(&stats.Downloaded)
+			obs.Completed(event)
+		}
+
+	case actionDeleteLocal:
+		event := FileEvent{Action: EventDeleteLocal, Key: key, Reason: reason, Size: localSize}
+		obs.Planned(event)
+		if !opts.DryRun {
+			obs.Started(event)
+			if err := deleteLocal(trash, key, path.localPath); err != nil {
+				obs.Failed(event, err)
+				stats.fail(key)
+				return nil
+			}
+			state.Delete(key)
+			stats.inc(&stats.DeletedLocal)
+			obs.Deleted(event)
+		}
+
+	case actionDeleteRemote:
+		event := FileEvent{Action: EventDeleteRemote, Key: key, Reason: reason, Size: remoteSize}
+		obs.Planned(event)
+		if !opts.DryRun {
+			obs.Started(event)
+			if err := deleteRemote(ctx, client, trash, key); err != nil {
+				obs.Failed(event, err)
+				stats.fail(key)
+				return ctx.Err()
+			}
+			state.Delete(key)
+			stats.inc(&stats.DeletedRemote)
+			obs.Deleted(event)
+		}
+
+	case actionKeepBoth:
+		conflictKey := conflictName(key, time.Now())
+		conflictPath := filepath.Join(opts.MountPath, filepath.FromSlash(conflictKey))
+		obs.Planned(FileEvent{
+			Action: EventKeepBoth,
+			Key:    key,
+			Reason: fmt.Sprintf("%s, local copy saved as %s", reason, conflictKey),
+			Size:   remoteSize,
+		})
+		if !opts.DryRun {
+			// Move the local version aside, then publish it and fetch the
+			// remote version into the original path
+			upload := FileEvent{Action: EventUpload, Key: conflictKey, Reason: reason, Size: localSize}
+			obs.Started(upload)
+			if err := os.Rename(path.localPath, conflictPath); err != nil {
+				obs.Failed(upload, err)
+				stats.fail(key)
+				return nil
+			}
+			uploaded, err := client.Put(ctx, conflictPath, conflictKey)
+			if err != nil {
+				obs.Failed(upload, err)
+				stats.fail(key)
+				return ctx.Err()
+			}
+			if info, err := os.Lstat(conflictPath); err == nil {
+				state.Record(conflictKey, info, uploaded.ETag)
+			}
+			stats.inc(&stats.Uploaded)
+			obs.Completed(upload)
+
+			download := FileEvent{Action: EventDownload, Key: key, Reason: reason, Size: remoteSize}
+			obs.Started(download)
+			if err := client.Get(ctx, key, path.localPath); err != nil {
+				obs.Failed(download, err)
+				stats.fail(key)
+				return ctx.Err()
+			}
+			if info, err := os.Lstat(path.localPath); err == nil {
+				state.Record(key, info, path.remote.ETag)
+			}
+			stats.inc(&stats.Downloaded)
+			obs.Completed(download)
+		}
+	}
+
+	return nil
+}
+
+// conflictName returns the key under which the local version of a
+// conflicting path is kept, e.g.
+// "data/a.csv.conflict-20060102T150405.000000000"
+func conflictName(key string, now time.Time) string {
+	return key + ".conflict-" + now.UTC().Format(stampFormat)
+}
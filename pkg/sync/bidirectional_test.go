@@ -0,0 +1,84 @@
+package sync
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vngcloud/aiplatform-util/pkg/storage"
+)
+
+func TestConflictName(t *testing.T) {
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	if got, want := conflictName("data/a.csv", now), "data/a.csv.conflict-20240102T150405.000000000"; got != want {
+		t.Errorf("conflictName = %q, want %q", got, want)
+	}
+
+	// Conflicts of one file in the same second are kept apart
+	if conflictName("a.csv", now) == conflictName("a.csv", now.Add(time.Millisecond)) {
+		t.Error("conflicts in the same second got the same name")
+	}
+}
+
+func TestChangedSince(t *testing.T) {
+	mountPath := t.TempDir()
+	small := "hello world"
+	big := strings.Repeat("x", 17<<20)
+	writeFile(t, mountPath, "small", small, past)
+	writeFile(t, mountPath, "big", big, past)
+
+	baseline := func(key string, etag string) StateEntry {
+		info, err := os.Stat(filepath.Join(mountPath, key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return StateEntry{Size: info.Size(), ModTime: info.ModTime(), ETag: etag}
+	}
+	smallETag := "5eb63bbbe01eeed093cb22bb8f5acdc3"
+	bigETag, err := storage.FileETag(filepath.Join(mountPath, "big"), storage.PartSize(int64(len(big))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	smallBase, bigBase := baseline("small", smallETag), baseline("big", bigETag)
+
+	// Touched files keep their size but not their mtime
+	writeFile(t, mountPath, "small", small, time.Now())
+	writeFile(t, mountPath, "big", big, time.Now())
+	writeFile(t, mountPath, "edited", "hello World", time.Now())
+	writeFile(t, mountPath, "resized", small+"!", past)
+
+	tests := []struct {
+		name     string
+		key      string
+		baseline StateEntry
+		checksum bool
+		want     bool
+	}{
+		{"size differs", "resized", smallBase, true, true},
+		{"touched", "small", smallBase, false, true},
+		{"touched with the same content", "small", smallBase, true, false},
+		{"touched multipart with the same content", "big", bigBase, true, false},
+		{"same size with other content", "edited", smallBase, true, true},
+		{"ETag that cannot be reproduced", "small", StateEntry{Size: smallBase.Size, ModTime: past, ETag: smallETag + "-3"}, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(mountPath, tt.key)
+			info, err := os.Lstat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := changedSince(path, info, tt.baseline, tt.checksum); got != tt.want {
+				t.Errorf("changedSince = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// An untouched file is unchanged without reading it
+	info, _ := os.Lstat(filepath.Join(mountPath, "resized"))
+	if changedSince(filepath.Join(mountPath, "resized"), info, StateEntry{Size: info.Size(), ModTime: info.ModTime()}, true) {
+		t.Error("an untouched file was reported changed")
+	}
+}
@@ -0,0 +1,89 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/vngcloud/aiplatform-util/pkg/storage"
+)
+
+// contentMatches reports whether a local file has the same content as a
+// remote object by comparing the object's ETag against one computed locally
+func contentMatches(ctx context.Context, client storage.Storage, localPath string, obj storage.Object) (bool, error) {
+	// Multipart ETags depend on the part size, which is recorded in
+	// metadata at upload time
+	if strings.Contains(obj.ETag, "-") && !obj.HasMetadata {
+		meta, err := client.Stat(ctx, obj.Key)
+		if err != nil {
+			return false, err
+		}
+		obj.PartSize = meta.PartSize
+	}
+
+	// Objects whose ETag cannot be reproduced are treated as different
+	partSize, ok := storage.ETagPartSize(obj.ETag, obj.Size, obj.PartSize)
+	if !ok {
+		return false, nil
+	}
+
+	etag, err := storage.FileETag(localPath, partSize)
+	if err != nil {
+		return false, err
+	}
+	return strings.EqualFold(etag, obj.ETag), nil
+}
+
+// remoteAttrs returns the attributes of the file an object was uploaded
+// from, or nil if it was uploaded without them. They are only read with a
+// Stat when the listing did not include them.
+func remoteAttrs(ctx context.Context, client storage.Storage, obj storage.Object) (*storage.Attrs, error) {
+	if obj.HasMetadata {
+		return obj.Attrs, nil
+	}
+	meta, err := client.Stat(ctx, obj.Key)
+	if err != nil {
+		return nil, err
+	}
+	return meta.Attrs, nil
+}
+
+// remoteModTime returns the modification time of the file an object was
+// uploaded from, falling back to the time the object was stored for
+// objects uploaded without it or whose metadata cannot be read
+func remoteModTime(ctx context.Context, client storage.Storage, obj storage.Object) (time.Time, error) {
+	attrs, err := remoteAttrs(ctx, client, obj)
+	obj.Attrs = attrs
+	return obj.ModTime(), err
+}
+
+// remoteLink returns the target of the symbolic link an object was stored
+// from, empty if it was not stored from a link
+func remoteLink(ctx context.Context, client storage.Storage, obj storage.Object) (string, error) {
+	// Links are stored without content
+	if obj.Size != 0 {
+		return "", nil
+	}
+	attrs, err := remoteAttrs(ctx, client, obj)
+	if err != nil || attrs == nil {
+		return "", err
+	}
+	return attrs.Link, nil
+}
+
+// isLink reports whether a local file is a symbolic link
+func isLink(info os.FileInfo) bool {
+	return info.Mode()&os.ModeSymlink != 0
+}
+
+// linkMatches reports whether the local link at localPath points to the
+// same target as the link an object was stored from
+func linkMatches(ctx context.Context, client storage.Storage, localPath string, obj storage.Object) (bool, error) {
+	target, err := os.Readlink(localPath)
+	if err != nil {
+		return false, nil
+	}
+	remote, err := remoteLink(ctx, client, obj)
+	return err == nil && target == remote, err
+}
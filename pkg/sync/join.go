@@ -0,0 +1,121 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"os"
+
+	"github.com/vngcloud/aiplatform-util/pkg/ignore"
+	"github.com/vngcloud/aiplatform-util/pkg/storage"
+)
+
+// errCursorStopped ends a listing whose cursor was stopped early
+var errCursorStopped = errors.New("cursor stopped")
+
+// cursor reads a callback driven listing one entry at a time. The listing
+// runs as a coroutine of its reader, never concurrently with it, so that
+// state it updates, like the .nvignore patterns loaded by walkLocal, can be
+// used between reads.
+type cursor[T any] struct {
+	next func() (T, error, bool)
+	stop func()
+
+	// value is the current entry, valid while ok
+	value T
+	ok    bool
+}
+
+// newCursor creates a cursor over the entries passed to the callback of
+// each, positioned on the first one
+func newCursor[T any](each func(fn func(T) error) error) (*cursor[T], error) {
+	next, stop := iter.Pull2(func(yield func(T, error) bool) {
+		err := each(func(value T) error {
+			if !yield(value, nil) {
+				return errCursorStopped
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, errCursorStopped) {
+			var zero T
+			yield(zero, err)
+		}
+	})
+	c := &cursor[T]{next: next, stop: stop}
+	return c, c.advance()
+}
+
+// advance moves the cursor to the next entry
+func (c *cursor[T]) advance() error {
+	value, err, ok := c.next()
+	if err != nil {
+		return err
+	}
+	c.value, c.ok = value, ok
+	return nil
+}
+
+// joinListings merges a local walk and a remote listing, both in key order,
+// and calls fn once per key with the local file and the remote object
+// stored under it, either of which is nil if the key exists on one side
+// only. Only the current entry of each side is held in memory, so fn must
+// copy the entries it keeps.
+func joinListings(ctx context.Context, walk func(fn func(localFile) error) error, list func(fn func(storage.Object) error) error, fn func(local *localFile, remote *storage.Object) error) error {
+	locals, err := newCursor(walk)
+	if err != nil {
+		return err
+	}
+	defer locals.stop()
+	remotes, err := newCursor(list)
+	if err != nil {
+		return err
+	}
+	defer remotes.stop()
+
+	for locals.ok || remotes.ok {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var local *localFile
+		var remote *storage.Object
+		switch {
+		case !remotes.ok || (locals.ok && locals.value.key < remotes.value.Key):
+			local = &locals.value
+		case !locals.ok || remotes.value.Key < locals.value.key:
+			remote = &remotes.value
+		default:
+			local, remote = &locals.value, &remotes.value
+		}
+		if err := fn(local, remote); err != nil {
+			return err
+		}
+
+		if local != nil {
+			if err := locals.advance(); err != nil {
+				return err
+			}
+		}
+		if remote != nil {
+			if err := remotes.advance(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// walkFiles returns the walk of joinListings for the files walkLocal
+// reports with the given arguments
+func walkFiles(mountPath string, prefix string, matcher *ignore.Matcher, filter *ignore.Filter, opts walkOptions) func(fn func(localFile) error) error {
+	return func(fn func(localFile) error) error {
+		err := walkLocal(mountPath, prefix, matcher, filter, opts, func(key string, path string, info os.FileInfo) error {
+			return fn(localFile{key: key, path: path, info: info})
+		})
+		if err != nil {
+			return fmt.Errorf("failed to walk directory: %w", err)
+		}
+		return nil
+	}
+}
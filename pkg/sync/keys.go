@@ -0,0 +1,64 @@
+package sync
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// keySet restricts a run to a list of keys, a nil set selects every key
+type keySet map[string]bool
+
+// newKeySet creates the set of keys, nil if keys is nil
+func newKeySet(keys []string) keySet {
+	if keys == nil {
+		return nil
+	}
+	set := make(keySet, len(keys))
+	for _, key := range keys {
+		set[key] = true
+	}
+	return set
+}
+
+// selected reports whether key is in the set
+func (s keySet) selected(key string) bool {
+	return s == nil || s[key]
+}
+
+// ReadKeys reads a list of keys written by WriteKeys, one key per line.
+// Blank lines are ignored.
+func ReadKeys(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key list: %w", err)
+	}
+	defer file.Close()
+
+	keys := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if key := strings.TrimRight(scanner.Text(), "\r"); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read key list %s: %w", path, err)
+	}
+	return keys, nil
+}
+
+// WriteKeys writes a list of keys, such as the keys that failed to
+// transfer, one key per line
+func WriteKeys(path string, keys []string) error {
+	var b strings.Builder
+	for _, key := range keys {
+		b.WriteString(key)
+		b.WriteByte('\n')
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write key list: %w", err)
+	}
+	return nil
+}
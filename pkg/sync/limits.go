@@ -0,0 +1,78 @@
+package sync
+
+import (
+	"fmt"
+	"sync"
+)
+
+// DeleteLimits guards destructive --delete operations. Deletions are counted
+// in full and checked against the limits before anything is changed, so an
+// aborted run leaves nothing half-deleted.
+type DeleteLimits struct {
+	// MaxDelete is the maximum number of files deleted in one run, 0 for no limit
+	MaxDelete int
+
+	// MaxDeletePercent is the maximum share of files on the target side
+	// deleted in one run, 0 for no limit
+	MaxDeletePercent float64
+
+	// Force allows deleting every file on the target side when the source
+	// side is empty, which usually means a mistyped prefix or wrong bucket
+	Force bool
+}
+
+// check validates a deletion plan of count files out of total files on the
+// target side. sourceEmpty reports whether the side being mirrored has no files.
+func (l DeleteLimits) check(count int, total int, sourceEmpty bool) error {
+	if count == 0 {
+		return nil
+	}
+
+	if sourceEmpty && !l.Force {
+		return fmt.Errorf("refusing to delete %d files because the source is empty (check the prefix and bucket, or use --force)", count)
+	}
+
+	if l.MaxDelete > 0 && count > l.MaxDelete {
+		return fmt.Errorf("refusing to delete %d files: exceeds --max-delete %d", count, l.MaxDelete)
+	}
+
+	if l.MaxDeletePercent > 0 && total > 0 {
+		percent := float64(count) / float64(total) * 100
+		if percent > l.MaxDeletePercent {
+			return fmt.Errorf("refusing to delete %d of %d files (%.1f%%): exceeds --max-delete-percent %g", count, total, percent, l.MaxDeletePercent)
+		}
+	}
+
+	return nil
+}
+
+// Check validates deleting count files out of total files where there is no
+// source side being mirrored, e.g. the objects selected by "nv rm --prefix"
+func (l DeleteLimits) Check(count int, total int) error {
+	return l.check(count, total, false)
+}
+
+// deletionBudget caps the deletions of a run at the number checked against
+// the delete limits. Files are counted and deleted in separate passes, so
+// files removed in between would otherwise be deleted unchecked.
+type deletionBudget struct {
+	mu   sync.Mutex
+	left int
+}
+
+// take uses up one deletion, false if none is left
+func (b *deletionBudget) take() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.left == 0 {
+		return false
+	}
+	b.left--
+	return true
+}
+
+// errOverBudget is the warning for a deletion exceeding the checked count
+func errOverBudget(key string) error {
+	return fmt.Errorf("not deleting %s: it was not counted when the delete limits were checked", key)
+}
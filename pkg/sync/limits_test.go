@@ -0,0 +1,53 @@
+package sync
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDeleteLimitsCheck(t *testing.T) {
+	tests := []struct {
+		name        string
+		limits      DeleteLimits
+		count       int
+		total       int
+		sourceEmpty bool
+		wantErr     string
+	}{
+		{"no limits", DeleteLimits{}, 100, 100, false, ""},
+		{"nothing to delete", DeleteLimits{MaxDelete: 1, MaxDeletePercent: 1}, 0, 100, true, ""},
+
+		// Deleting everything because the source is empty needs --force
+		{"empty source", DeleteLimits{}, 3, 3, true, "refusing to delete 3 files because the source is empty"},
+		{"empty source forced", DeleteLimits{Force: true}, 3, 3, true, ""},
+		{"empty source forced over count", DeleteLimits{Force: true, MaxDelete: 2}, 3, 3, true, "exceeds --max-delete 2"},
+
+		{"under count", DeleteLimits{MaxDelete: 5}, 4, 100, false, ""},
+		{"at count", DeleteLimits{MaxDelete: 5}, 5, 100, false, ""},
+		{"over count", DeleteLimits{MaxDelete: 5}, 6, 100, false, "refusing to delete 6 files: exceeds --max-delete 5"},
+
+		{"under percent", DeleteLimits{MaxDeletePercent: 10}, 9, 100, false, ""},
+		{"at percent", DeleteLimits{MaxDeletePercent: 10}, 10, 100, false, ""},
+		{"over percent", DeleteLimits{MaxDeletePercent: 10}, 11, 100, false, "refusing to delete 11 of 100 files (11.0%): exceeds --max-delete-percent 10"},
+		{"fractional percent", DeleteLimits{MaxDeletePercent: 0.5}, 1, 150, false, "refusing to delete 1 of 150 files (0.7%): exceeds --max-delete-percent 0.5"},
+		{"percent without total", DeleteLimits{MaxDeletePercent: 10}, 5, 0, false, ""},
+
+		// The count is checked before the percentage
+		{"over both", DeleteLimits{MaxDelete: 5, MaxDeletePercent: 10}, 50, 100, false, "exceeds --max-delete 5"},
+		{"over percent only", DeleteLimits{MaxDelete: 50, MaxDeletePercent: 10}, 20, 100, false, "exceeds --max-delete-percent 10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.limits.check(tt.count, tt.total, tt.sourceEmpty)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("check(%d, %d, %v) = %v, want nil", tt.count, tt.total, tt.sourceEmpty, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("check(%d, %d, %v) = %v, want an error containing %q", tt.count, tt.total, tt.sourceEmpty, err, tt.wantErr)
+			}
+		})
+	}
+}
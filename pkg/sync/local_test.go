@@ -0,0 +1,138 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vngcloud/aiplatform-util/pkg/storage"
+)
+
+// assertFile checks the content of a file under root
+func assertFile(t *testing.T, root string, key string, want string) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(key)))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if string(data) != want {
+		t.Errorf("%s = %q, want %q", key, data, want)
+	}
+}
+
+// assertMissing checks that a path under root does not exist
+func assertMissing(t *testing.T, root string, key string) {
+	t.Helper()
+	if _, err := os.Lstat(filepath.Join(root, filepath.FromSlash(key))); !os.IsNotExist(err) {
+		t.Errorf("%s exists: %v", key, err)
+	}
+}
+
+// assertLink checks the target of a link under root
+func assertLink(t *testing.T, root string, key string, want string) {
+	t.Helper()
+	target, err := os.Readlink(filepath.Join(root, filepath.FromSlash(key)))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if target != want {
+		t.Errorf("%s links to %q, want %q", key, target, want)
+	}
+}
+
+// assertEmptyDir checks that a directory under root exists and is empty
+func assertEmptyDir(t *testing.T, root string, key string) {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Join(root, filepath.FromSlash(key)))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(entries) != 0 {
+		t.Errorf("%s has %d entries, want none", key, len(entries))
+	}
+}
+
+func TestPushToLocalDirectory(t *testing.T) {
+	ctx := context.Background()
+	workspace, target := t.TempDir(), t.TempDir()
+	writeFile(t, workspace, "a.txt", "alpha", past)
+	writeFile(t, workspace, "dir/b.txt", "beta", past)
+	if err := os.Mkdir(filepath.Join(workspace, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("a.txt", filepath.Join(workspace, "link")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, target, "stale/c.txt", "gone", past)
+
+	opts := PushOptions{MountPath: workspace, Delete: true, Symlinks: PreserveSymlinks, KeepEmptyDirs: true, Parallel: 4}
+	stats, err := Push(ctx, storage.NewLocal(target), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Uploaded != 4 || stats.Deleted != 1 || stats.Failed != 0 {
+		t.Errorf("stats = %+v, want 4 uploaded and 1 deleted", stats)
+	}
+	assertFile(t, target, "a.txt", "alpha")
+	assertFile(t, target, "dir/b.txt", "beta")
+	assertLink(t, target, "link", "a.txt")
+	assertEmptyDir(t, target, "empty")
+	assertMissing(t, target, "stale")
+
+	// Files keep their modification time, so nothing is pushed again
+	stats, err = Push(ctx, storage.NewLocal(target), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Uploaded != 0 || stats.Deleted != 0 {
+		t.Errorf("second push = %+v, want nothing to do", stats)
+	}
+}
+
+func TestPullFromLocalDirectory(t *testing.T) {
+	ctx := context.Background()
+	source, workspace := t.TempDir(), t.TempDir()
+	writeFile(t, source, "a.txt", "alpha", past)
+	writeFile(t, source, "dir/b.txt", "beta", past)
+	writeFile(t, source, "other/c.txt", "gamma", past)
+	if err := os.MkdirAll(filepath.Join(source, "dir", "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("b.txt", filepath.Join(source, "dir", "link")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, workspace, "dir/stale.txt", "gone", past)
+	writeFile(t, workspace, "outside.txt", "kept", past)
+
+	stats, err := Pull(ctx, storage.NewLocal(source), PullOptions{MountPath: workspace, Prefix: "dir/", Delete: true, Parallel: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Downloaded != 3 || stats.Deleted != 1 || stats.Failed != 0 {
+		t.Errorf("stats = %+v, want 3 downloaded and 1 deleted", stats)
+	}
+	assertFile(t, workspace, "dir/b.txt", "beta")
+	assertLink(t, workspace, "dir/link", "b.txt")
+	assertEmptyDir(t, workspace, "dir/empty")
+	assertMissing(t, workspace, "dir/stale.txt")
+	assertMissing(t, workspace, "a.txt")
+	assertMissing(t, workspace, "other")
+	assertFile(t, workspace, "outside.txt", "kept")
+
+	// Removed source files are deleted on the next pull
+	if err := os.Remove(filepath.Join(source, "dir", "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	stats, err = Pull(ctx, storage.NewLocal(source), PullOptions{MountPath: workspace, Prefix: "dir/", Delete: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Downloaded != 0 || stats.Deleted != 1 {
+		t.Errorf("second pull = %+v, want only the removed file deleted", stats)
+	}
+	assertMissing(t, workspace, "dir/b.txt")
+}
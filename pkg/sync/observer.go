@@ -0,0 +1,238 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/vngcloud/aiplatform-util/pkg/config"
+)
+
+// File event actions
+const (
+	EventUpload       = "upload"
+	EventDownload     = "download"
+	EventDeleteLocal  = "delete-local"
+	EventDeleteRemote = "delete-remote"
+	EventKeepBoth     = "keep-both"
+	EventSkip         = "skip"
+	EventRefuse       = "refuse"
+)
+
+// FileEvent describes an action on a single file
+type FileEvent struct {
+	Action string `json:"action"`
+	Key    string `json:"key"`
+	Reason string `json:"reason,omitempty"`
+	Size   int64  `json:"size"`
+}
+
+// Observer receives what happens to each file during a pull, push, sync or
+// apply. Calls made for a file are delivered in the order the files were
+// scheduled, so a parallel run reports the same sequence as a sequential
+// one, and are never made concurrently. Progress is the exception: it is
+// delivered as soon as data is transferred and may be called concurrently.
+type Observer interface {
+	// Planned is called when an action is chosen for a file. In a dry run
+	// it is the only call made for that file.
+	Planned(event FileEvent)
+
+	// Started is called when an action begins
+	Started(event FileEvent)
+
+	// Progress reports the bytes transferred so far for a large file
+	Progress(key string, transferred int64, total int64)
+
+	// Completed is called when an upload or download finished
+	Completed(event FileEvent)
+
+	// Skipped is called for a file that is already up to date
+	Skipped(event FileEvent)
+
+	// Failed is called when an action failed or was refused
+	Failed(event FileEvent, err error)
+
+	// Deleted is called when a deletion finished
+	Deleted(event FileEvent)
+
+	// Warning reports a problem that does not fail the run
+	Warning(err error)
+}
+
+// ProgressObserver is an Observer that also follows the overall progress
+// of a run. Like Progress, its methods are delivered as soon as they happen
+// rather than in schedule order, and may be called concurrently.
+type ProgressObserver interface {
+	Observer
+
+	// Scheduled is called with each step of a run once it is decided,
+	// before it starts. Steps are decided while earlier ones run, so the
+	// totals of a run grow until Decided is called.
+	Scheduled(step PlanStep)
+
+	// Decided is called once every step of a run has been scheduled
+	Decided()
+
+	// Finished is called as soon as an action completed, or failed with err
+	Finished(event FileEvent, err error)
+}
+
+// schedule passes a step of a run to obs if it follows the progress
+func schedule(obs Observer, step PlanStep) {
+	if progress, ok := obs.(ProgressObserver); ok {
+		progress.Scheduled(step)
+	}
+}
+
+// decided tells obs that every step of a run has been scheduled if it
+// follows the progress
+func decided(obs Observer) {
+	if progress, ok := obs.(ProgressObserver); ok {
+		progress.Decided()
+	}
+}
+
+// scheduler passes the steps of a run to obs as files are decided, and
+// makes the totals final once every file has been decided. Files are
+// decided concurrently while the merge of the listings goes on, so the
+// totals are only final when both are done.
+type scheduler struct {
+	obs Observer
+
+	mu        sync.Mutex
+	undecided int
+	merged    bool
+}
+
+// submit runs fn on p to decide the steps of a file, which fn passes to
+// decide. A file the pool rejects because the run stopped is decided with
+// no steps, so that the totals are still made final.
+func (s *scheduler) submit(p *pool, fn func(ctx context.Context, obs Observer) error) {
+	s.mu.Lock()
+	s.undecided++
+	s.mu.Unlock()
+
+	if !p.Go(fn) {
+		s.decide()
+	}
+}
+
+// decide schedules the steps decided for a file, none if it is skipped
+func (s *scheduler) decide(steps ...PlanStep) {
+	for _, step := range steps {
+		schedule(s.obs, step)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.undecided--
+	s.check()
+}
+
+// done tells that the merge is complete and no more files will be decided
+func (s *scheduler) done() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.merged = true
+	s.check()
+}
+
+// check makes the totals final once everything is decided
+func (s *scheduler) check() {
+	if s.merged && s.undecided == 0 {
+		decided(s.obs)
+	}
+}
+
+// finished tells obs that an action ended if it follows the progress
+func finished(obs Observer, event FileEvent, err error) {
+	if progress, ok := obs.(ProgressObserver); ok {
+		progress.Finished(event, err)
+	}
+}
+
+// NopObserver ignores all events
+type NopObserver struct{}
+
+func (NopObserver) Planned(FileEvent)             {}
+func (NopObserver) Started(FileEvent)             {}
+func (NopObserver) Progress(string, int64, int64) {}
+func (NopObserver) Completed(FileEvent)           {}
+func (NopObserver) Skipped(FileEvent)             {}
+func (NopObserver) Failed(FileEvent, error)       {}
+func (NopObserver) Deleted(FileEvent)             {}
+func (NopObserver) Warning(error)                 {}
+
+// observerOrNop returns obs, or an observer ignoring all events if it is nil
+func observerOrNop(obs Observer) Observer {
+	if obs == nil {
+		return NopObserver{}
+	}
+	return obs
+}
+
+// ConsoleObserver prints the human-readable log of the nv commands
+type ConsoleObserver struct {
+	w io.Writer
+}
+
+// NewConsoleObserver creates an observer printing to w
+func NewConsoleObserver(w io.Writer) *ConsoleObserver {
+	return &ConsoleObserver{w: w}
+}
+
+// Planned prints the action chosen for a file
+func (c *ConsoleObserver) Planned(event FileEvent) {
+	switch event.Action {
+	case EventUpload:
+		fmt.Fprintf(c.w, "Uploading: %s (%s)\n", event.Key, event.Reason)
+	case EventDownload:
+		fmt.Fprintf(c.w, "Downloading: %s (%s)\n", event.Key, event.Reason)
+	case EventDeleteLocal:
+		fmt.Fprintf(c.w, "Deleting local: %s (%s)\n", event.Key, event.Reason)
+	case EventDeleteRemote:
+		fmt.Fprintf(c.w, "Deleting remote: %s (%s)\n", event.Key, event.Reason)
+	case EventKeepBoth:
+		fmt.Fprintf(c.w, "Keeping both: %s (%s)\n", event.Key, event.Reason)
+	}
+}
+
+// Started prints nothing, the action was printed when it was planned
+func (c *ConsoleObserver) Started(FileEvent) {}
+
+// Progress prints the progress of a large transfer
+func (c *ConsoleObserver) Progress(key string, transferred int64, total int64) {
+	percent := float64(transferred) / float64(total) * 100
+	fmt.Fprintf(c.w, "  Progress: %s - %.2f%% (%s / %s)\n",
+		key,
+		percent,
+		config.FormatSize(transferred),
+		config.FormatSize(total))
+}
+
+// Completed prints nothing
+func (c *ConsoleObserver) Completed(FileEvent) {}
+
+// Skipped prints nothing, up to date files are only counted
+func (c *ConsoleObserver) Skipped(FileEvent) {}
+
+// Failed prints why an action failed
+func (c *ConsoleObserver) Failed(event FileEvent, err error) {
+	switch event.Action {
+	case EventRefuse:
+		fmt.Fprintf(c.w, "Refusing: %s (%s: %v)\n", event.Key, event.Reason, err)
+	case EventDeleteLocal, EventDeleteRemote:
+		fmt.Fprintf(c.w, "  Failed to delete: %v\n", err)
+	default:
+		fmt.Fprintf(c.w, "  Failed: %v\n", err)
+	}
+}
+
+// Deleted prints nothing
+func (c *ConsoleObserver) Deleted(FileEvent) {}
+
+// Warning prints a warning
+func (c *ConsoleObserver) Warning(err error) {
+	fmt.Fprintf(c.w, "Warning: %v\n", err)
+}
@@ -0,0 +1,173 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// planVersion is the version of the plan file format
+const planVersion = 1
+
+// PlanAction is an operation recorded in a plan
+type PlanAction string
+
+const (
+	// PlanUpload uploads a local file
+	PlanUpload PlanAction = "upload"
+
+	// PlanDownload downloads a remote object
+	PlanDownload PlanAction = "download"
+
+	// PlanDeleteLocal deletes a local file
+	PlanDeleteLocal PlanAction = "delete-local"
+
+	// PlanDeleteRemote deletes a remote object
+	PlanDeleteRemote PlanAction = "delete-remote"
+)
+
+// LocalCondition is the state a local file must still be in when a plan is applied
+type LocalCondition struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// PlanStep is a single planned action together with the preconditions
+// under which it was planned
+type PlanStep struct {
+	Action PlanAction `json:"action"`
+	Key    string     `json:"key"`
+	Reason string     `json:"reason"`
+
+	// Size is the size of the transferred or deleted file
+	Size int64 `json:"size"`
+
+	// RemoteETag is the ETag the object must still have, empty if the
+	// object must not exist
+	RemoteETag string `json:"remote_etag,omitempty"`
+
+	// Local is the state the local file must still be in, nil if the file
+	// must not exist
+	Local *LocalCondition `json:"local,omitempty"`
+}
+
+// Plan is the serializable list of actions computed by a dry run of pull or
+// push. Applying it performs exactly these actions, refusing those whose
+// preconditions no longer hold. It is safe for concurrent use while planning.
+type Plan struct {
+	Version   int       `json:"version"`
+	Command   string    `json:"command"`
+	Bucket    string    `json:"bucket"`
+	MountPath string    `json:"mount_path"`
+	Prefix    string    `json:"prefix"`
+	Created   time.Time `json:"created"`
+
+	// Symlinks is the symlink policy uploads were planned with
+	Symlinks SymlinkPolicy `json:"symlinks,omitempty"`
+
+	Steps []PlanStep `json:"steps"`
+
+	mu sync.Mutex
+}
+
+// NewPlan creates an empty plan for command run against bucket and mountPath
+func NewPlan(command string, bucket string, mountPath string, prefix string) *Plan {
+	return &Plan{
+		Version:   planVersion,
+		Command:   command,
+		Bucket:    bucket,
+		MountPath: mountPath,
+		Prefix:    prefix,
+		Created:   time.Now().UTC(),
+	}
+}
+
+// add appends a step to the plan
+func (p *Plan) add(step PlanStep) {
+	p.mu.Lock()
+	p.Steps = append(p.Steps, step)
+	p.mu.Unlock()
+}
+
+// localCondition returns the precondition for a local file, nil if it does not exist
+func localCondition(info os.FileInfo) *LocalCondition {
+	if info == nil {
+		return nil
+	}
+	return &LocalCondition{Size: info.Size(), ModTime: info.ModTime()}
+}
+
+// sort orders the steps with transfers before deletions and by key, so the
+// plan reads the same regardless of the order transfers were planned in
+func (p *Plan) sort() {
+	rank := func(action PlanAction) int {
+		if action == PlanDeleteLocal || action == PlanDeleteRemote {
+			return 1
+		}
+		return 0
+	}
+	sort.SliceStable(p.Steps, func(i, j int) bool {
+		if ri, rj := rank(p.Steps[i].Action), rank(p.Steps[j].Action); ri != rj {
+			return ri < rj
+		}
+		return p.Steps[i].Key < p.Steps[j].Key
+	})
+}
+
+// Save writes the plan as indented JSON to path
+func (p *Plan) Save(path string) error {
+	p.mu.Lock()
+	p.sort()
+	data, err := json.MarshalIndent(p, "", "  ")
+	p.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode plan: %w", err)
+	}
+
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", dir, err)
+		}
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write plan %s: %w", path, err)
+	}
+	return nil
+}
+
+// LoadPlan reads a plan written by Save
+func LoadPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan %s: %w", path, err)
+	}
+
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("failed to parse plan %s: %w", path, err)
+	}
+	if plan.Version != planVersion {
+		return nil, fmt.Errorf("unsupported plan version %d in %s", plan.Version, path)
+	}
+
+	for _, step := range plan.Steps {
+		switch step.Action {
+		case PlanUpload, PlanDownload, PlanDeleteLocal, PlanDeleteRemote:
+		default:
+			return nil, fmt.Errorf("invalid action %q for %s in plan %s", step.Action, step.Key, path)
+		}
+		// Keys must stay inside the workspace and out of its metadata.
+		// Empty directory markers keep their trailing slash.
+		name := strings.TrimSuffix(step.Key, "/")
+		if name == "" || pathpkg.Clean(name) != name || name == ".." || strings.HasPrefix(name, "../") || pathpkg.IsAbs(name) || isInternalPath(step.Key) {
+			return nil, fmt.Errorf("invalid key %q in plan %s", step.Key, path)
+		}
+	}
+	return &plan, nil
+}
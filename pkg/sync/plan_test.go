@@ -0,0 +1,92 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vngcloud/aiplatform-util/pkg/storage"
+)
+
+func TestPlanRoundTripWithEmptyDirectory(t *testing.T) {
+	mountPath := t.TempDir()
+	remote := storage.NewMemory()
+	writeFile(t, mountPath, "a.txt", "a", past)
+	if err := os.MkdirAll(filepath.Join(mountPath, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	plan := NewPlan("push", "bucket", mountPath, "")
+	_, err := Push(context.Background(), remote, PushOptions{MountPath: mountPath, DryRun: true, KeepEmptyDirs: true, Parallel: 4, Plan: plan})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "plan.json")
+	if err := plan.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadPlan(path)
+	if err != nil {
+		t.Fatalf("failed to load a plan with an empty directory: %v", err)
+	}
+	var keys []string
+	for _, step := range loaded.Steps {
+		keys = append(keys, step.Key)
+	}
+	if len(keys) != 2 || keys[0] != "a.txt" || keys[1] != "empty/" {
+		t.Fatalf("plan keys = %q, want [a.txt empty/]", keys)
+	}
+
+	stats, err := Apply(context.Background(), remote, loaded, ApplyOptions{MountPath: mountPath, Parallel: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Uploaded != 2 || stats.Failed != 0 || stats.Refused != 0 {
+		t.Errorf("applied %+v, want both steps uploaded", stats)
+	}
+	if _, err := remote.Stat(context.Background(), "empty/"); err != nil {
+		t.Errorf("empty directory marker was not uploaded: %v", err)
+	}
+}
+
+func TestLoadPlanRejectsUnsafeKeys(t *testing.T) {
+	tests := []struct {
+		key string
+		ok  bool
+	}{
+		{"a.txt", true},
+		{"dir/a.txt", true},
+		{"dir/", true},
+		{"dir/sub/", true},
+		{"", false},
+		{"/", false},
+		{"dir//", false},
+		{"./a.txt", false},
+		{"dir/../a.txt", false},
+		{"..", false},
+		{"../", false},
+		{"../a.txt", false},
+		{"/etc/passwd", false},
+		{".aiplatform/state.db", false},
+		{".aiplatform/", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			plan := NewPlan("push", "bucket", t.TempDir(), "")
+			plan.add(PlanStep{Action: PlanUpload, Key: tt.key})
+			path := filepath.Join(t.TempDir(), "plan.json")
+			if err := plan.Save(path); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadPlan(path)
+			if tt.ok && err != nil {
+				t.Errorf("LoadPlan rejected %q: %v", tt.key, err)
+			}
+			if !tt.ok && err == nil {
+				t.Errorf("LoadPlan accepted %q", tt.key)
+			}
+		})
+	}
+}
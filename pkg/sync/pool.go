@@ -0,0 +1,182 @@
+package sync
+
+import (
+	"context"
+	"sync"
+)
+
+// pool runs per-file transfers on a bounded number of goroutines.
+// Each task reports to a private observer whose calls are buffered and
+// replayed to the pool observer in submission order, so a parallel run
+// reports the same events in the same order as a sequential one.
+// Progress is not buffered and reaches the pool observer immediately, as
+// does the end of each action if the pool observer is a ProgressObserver.
+type pool struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	obs    Observer
+
+	sem chan struct{}
+	wg  sync.WaitGroup
+
+	// window bounds the tasks scheduled but not replayed yet, so that a
+	// slow task does not make the tasks finished after it pile up
+	window chan struct{}
+
+	mu      sync.Mutex
+	pending []*poolTask
+	err     error
+}
+
+// poolWindow is how many finished tasks may wait for the replay of an
+// earlier task that is still running
+const poolWindow = 1024
+
+// poolTask buffers the observer calls of a single scheduled task
+type poolTask struct {
+	obs   Observer
+	calls []func(Observer)
+	done  bool
+}
+
+// newPool creates a pool running at most parallel tasks at once
+func newPool(ctx context.Context, parallel int, obs Observer) *pool {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	return &pool{
+		ctx:    ctx,
+		cancel: cancel,
+		obs:    obs,
+		sem:    make(chan struct{}, parallel),
+		window: make(chan struct{}, parallel+poolWindow),
+	}
+}
+
+// Go schedules fn on the pool, blocking while all workers are busy or
+// while too many finished tasks wait for an earlier one.
+// A non-nil error returned by fn is treated as fatal: the pool context is
+// cancelled so that running and queued transfers stop early.
+// Go reports whether fn was scheduled, false once the pool is stopped.
+func (p *pool) Go(fn func(ctx context.Context, obs Observer) error) bool {
+	if p.ctx.Err() != nil {
+		return false
+	}
+	select {
+	case p.window <- struct{}{}:
+	case <-p.ctx.Done():
+		return false
+	}
+	select {
+	case p.sem <- struct{}{}:
+	case <-p.ctx.Done():
+		<-p.window
+		return false
+	}
+
+	task := &poolTask{obs: p.obs}
+	p.mu.Lock()
+	p.pending = append(p.pending, task)
+	p.mu.Unlock()
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer func() { <-p.sem }()
+
+		err := fn(p.ctx, task)
+		if err != nil {
+			p.fail(err)
+		}
+		p.complete(task)
+	}()
+	return true
+}
+
+// Wait blocks until all scheduled tasks have finished and returns the
+// first fatal error, or the context error if the run was cancelled
+func (p *pool) Wait() error {
+	p.wg.Wait()
+	defer p.cancel()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	return p.ctx.Err()
+}
+
+// Err returns a non-nil error once the pool has been cancelled
+func (p *pool) Err() error {
+	return p.ctx.Err()
+}
+
+// fail records a fatal error and cancels the remaining tasks
+func (p *pool) fail(err error) {
+	p.mu.Lock()
+	if p.err == nil {
+		p.err = err
+	}
+	p.mu.Unlock()
+	p.cancel()
+}
+
+// complete marks a task as done and replays the calls of every finished
+// task at the head of the queue
+func (p *pool) complete(task *poolTask) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	task.done = true
+	for len(p.pending) > 0 && p.pending[0].done {
+		for _, call := range p.pending[0].calls {
+			call(p.obs)
+		}
+		p.pending = p.pending[1:]
+		<-p.window
+	}
+}
+
+// record buffers an observer call until the task is flushed
+func (t *poolTask) record(call func(Observer)) {
+	t.calls = append(t.calls, call)
+}
+
+func (t *poolTask) Planned(e FileEvent) {
+	t.record(func(o Observer) { o.Planned(e) })
+}
+
+func (t *poolTask) Started(e FileEvent) {
+	t.record(func(o Observer) { o.Started(e) })
+}
+
+func (t *poolTask) Completed(e FileEvent) {
+	finished(t.obs, e, nil)
+	t.record(func(o Observer) { o.Completed(e) })
+}
+
+func (t *poolTask) Skipped(e FileEvent) {
+	t.record(func(o Observer) { o.Skipped(e) })
+}
+
+func (t *poolTask) Failed(e FileEvent, err error) {
+	finished(t.obs, e, err)
+	t.record(func(o Observer) { o.Failed(e, err) })
+}
+
+func (t *poolTask) Deleted(e FileEvent) {
+	finished(t.obs, e, nil)
+	t.record(func(o Observer) { o.Deleted(e) })
+}
+
+func (t *poolTask) Warning(err error) {
+	t.record(func(o Observer) { o.Warning(err) })
+}
+
+// Progress is delivered immediately so long transfers report while running
+func (t *poolTask) Progress(key string, transferred int64, total int64) {
+	t.obs.Progress(key, transferred, total)
+}
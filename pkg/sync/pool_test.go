@@ -0,0 +1,158 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// recordingObserver records the events it receives
+type recordingObserver struct {
+	NopObserver
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recordingObserver) record(event string) {
+	r.mu.Lock()
+	r.events = append(r.events, event)
+	r.mu.Unlock()
+}
+
+func (r *recordingObserver) recorded() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.events)
+}
+
+func (r *recordingObserver) Started(e FileEvent)   { r.record("started " + e.Key) }
+func (r *recordingObserver) Completed(e FileEvent) { r.record("completed " + e.Key) }
+func (r *recordingObserver) Failed(e FileEvent, err error) {
+	r.record(fmt.Sprintf("failed %s: %v", e.Key, err))
+}
+
+func TestPoolReplaysInSubmissionOrder(t *testing.T) {
+	const tasks = 8
+	obs := &recordingObserver{}
+	p := newPool(context.Background(), 4, obs)
+
+	started := make([]chan struct{}, tasks)
+	release := make([]chan struct{}, tasks)
+	for i := range tasks {
+		started[i] = make(chan struct{})
+		release[i] = make(chan struct{})
+	}
+	go func() {
+		for i := range tasks {
+			p.Go(func(_ context.Context, obs Observer) error {
+				e := FileEvent{Action: EventUpload, Key: fmt.Sprint(i)}
+				obs.Started(e)
+				close(started[i])
+				<-release[i]
+				obs.Completed(e)
+				return nil
+			})
+		}
+	}()
+
+	// Tasks finishing before the first one are held back until it finishes
+	for i := range 4 {
+		<-started[i]
+	}
+	for i := 3; i > 0; i-- {
+		close(release[i])
+	}
+	for i := 4; i < 7; i++ {
+		<-started[i]
+	}
+	if events := obs.recorded(); len(events) != 0 {
+		t.Fatalf("events %v were replayed before the first task finished", events)
+	}
+
+	close(release[0])
+	<-started[7]
+	for i := tasks - 1; i >= 4; i-- {
+		close(release[i])
+	}
+	if err := p.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	var want []string
+	for i := range tasks {
+		want = append(want, fmt.Sprintf("started %d", i), fmt.Sprintf("completed %d", i))
+	}
+	if events := obs.recorded(); !slices.Equal(events, want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+}
+
+func TestPoolStopsOnFailure(t *testing.T) {
+	obs := &recordingObserver{}
+	p := newPool(context.Background(), 2, obs)
+	errBoom := errors.New("boom")
+
+	// The second task runs until the pool is cancelled by the first one
+	running := make(chan struct{})
+	p.Go(func(ctx context.Context, obs Observer) error {
+		<-running
+		e := FileEvent{Action: EventUpload, Key: "a"}
+		obs.Failed(e, errBoom)
+		return errBoom
+	})
+	p.Go(func(ctx context.Context, obs Observer) error {
+		close(running)
+		<-ctx.Done()
+		obs.Failed(FileEvent{Action: EventUpload, Key: "b"}, ctx.Err())
+		return ctx.Err()
+	})
+
+	if err := p.Wait(); !errors.Is(err, errBoom) {
+		t.Errorf("Wait() = %v, want the error of the failed task", err)
+	}
+	if p.Err() == nil {
+		t.Error("pool not cancelled after a task failed")
+	}
+	want := []string{"failed a: boom", "failed b: context canceled"}
+	if events := obs.recorded(); !slices.Equal(events, want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+}
+
+// decidedObserver counts the calls of Decided
+type decidedObserver struct {
+	recordingObserver
+	decided int
+}
+
+func (d *decidedObserver) Scheduled(PlanStep)        {}
+func (d *decidedObserver) Decided()                  { d.decided++ }
+func (d *decidedObserver) Finished(FileEvent, error) {}
+
+func TestSchedulerDecidesRejectedFiles(t *testing.T) {
+	obs := &decidedObserver{}
+	ctx, cancel := context.WithCancel(context.Background())
+	p := newPool(ctx, 1, obs)
+	sched := &scheduler{obs: obs}
+
+	sched.submit(p, func(context.Context, Observer) error {
+		sched.decide(PlanStep{Action: PlanUpload, Key: "a"})
+		return nil
+	})
+
+	// Files submitted once the run stopped are never run
+	cancel()
+	sched.submit(p, func(context.Context, Observer) error {
+		t.Error("stopped pool ran a task")
+		return nil
+	})
+	sched.done()
+	p.Wait()
+
+	if obs.decided != 1 {
+		t.Errorf("Decided called %d times, want once", obs.decided)
+	}
+}
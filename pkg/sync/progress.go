@@ -0,0 +1,270 @@
+package sync
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vngcloud/aiplatform-util/pkg/config"
+)
+
+// redrawInterval is how often the progress line is redrawn on a terminal
+const redrawInterval = 100 * time.Millisecond
+
+// ProgressDisplay wraps an Observer and shows the overall progress of a run
+// instead of the progress of single large files: the files and bytes done
+// out of the totals of the scheduled steps, the current throughput and,
+// once every step is scheduled, the estimated time left. On a terminal the
+// progress line is redrawn in place below the log of the wrapped observer,
+// otherwise it is logged every interval so that e.g. a notebook cell shows
+// progress without flooding.
+type ProgressDisplay struct {
+	obs      Observer
+	w        io.Writer
+	terminal bool
+	interval time.Duration
+
+	mu sync.Mutex
+
+	// Totals of the scheduled steps and what has been done of them, final
+	// once decided. transferring holds the bytes of the files still being
+	// transferred.
+	files, filesDone, filesFailed int
+	bytes, bytesDone              int64
+	transferring                  map[string]int64
+	decided                       bool
+
+	start    time.Time
+	lastDraw time.Time
+	drawn    bool
+
+	// rate is the throughput in bytes per second, smoothed between samples
+	rate        float64
+	sampleTime  time.Time
+	sampleBytes int64
+}
+
+var _ ProgressObserver = (*ProgressDisplay)(nil)
+
+// NewProgressDisplay creates a display writing to w on top of obs, which is
+// expected to write to w as well. When terminal is false, progress is
+// logged every interval, never if interval is 0.
+func NewProgressDisplay(obs Observer, w io.Writer, terminal bool, interval time.Duration) *ProgressDisplay {
+	return &ProgressDisplay{
+		obs:          observerOrNop(obs),
+		w:            w,
+		terminal:     terminal,
+		interval:     interval,
+		transferring: make(map[string]int64),
+	}
+}
+
+// Scheduled adds a step to the totals of the run, starting to follow a
+// new run if the previous one was decided
+func (d *ProgressDisplay) Scheduled(step PlanStep) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.decided || d.start.IsZero() {
+		d.reset()
+	}
+	d.files++
+	if step.Action == PlanUpload || step.Action == PlanDownload {
+		d.bytes += step.Size
+	}
+}
+
+// Decided makes the totals final, ending the run if every step is done
+func (d *ProgressDisplay) Decided() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// A run without steps has nothing to show
+	if d.decided || d.start.IsZero() {
+		d.reset()
+	}
+	d.decided = true
+	if d.filesDone == d.files {
+		d.update(true)
+	}
+}
+
+// reset starts following a new run
+func (d *ProgressDisplay) reset() {
+	now := time.Now()
+	d.files, d.filesDone, d.filesFailed = 0, 0, 0
+	d.bytes, d.bytesDone = 0, 0
+	clear(d.transferring)
+	d.decided = false
+	d.start, d.lastDraw = now, now
+	d.rate, d.sampleTime, d.sampleBytes = 0, now, 0
+}
+
+// Progress records the bytes of a large file transferred so far
+func (d *ProgressDisplay) Progress(key string, transferred int64, total int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.transferring[key] = transferred
+	d.update(false)
+}
+
+// Finished counts a completed or failed step. The bytes of a failed
+// transfer are taken out of the total.
+func (d *ProgressDisplay) Finished(event FileEvent, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if event.Action == EventUpload || event.Action == EventDownload {
+		delete(d.transferring, event.Key)
+		if err != nil {
+			d.bytes -= event.Size
+		} else {
+			d.bytesDone += event.Size
+		}
+	}
+	d.filesDone++
+	if err != nil {
+		d.filesFailed++
+	}
+	d.update(d.decided && d.filesDone == d.files)
+}
+
+// update shows the progress if it is due, or if the run is done
+func (d *ProgressDisplay) update(done bool) {
+	now := time.Now()
+	switch {
+	case d.files == 0:
+		return
+
+	case d.terminal:
+		if !done && now.Sub(d.lastDraw) < redrawInterval {
+			return
+		}
+		fmt.Fprintf(d.w, "\r\033[K%s", d.line(now, done))
+		d.drawn = !done
+		if done {
+			fmt.Fprintln(d.w)
+		}
+
+	default:
+		// Runs shorter than the interval are not logged at all
+		since := d.lastDraw
+		if done {
+			since = d.start
+		}
+		if d.interval <= 0 || now.Sub(since) < d.interval {
+			return
+		}
+		fmt.Fprintln(d.w, d.line(now, done))
+	}
+	d.lastDraw = now
+}
+
+// line formats the progress, e.g.
+// "Progress: 120/5000 files, 1.20 GB / 5.00 GB (24%), 12.50 MB/s, ETA 5m36s",
+// with totals marked as growing, e.g. "120/800+ files", until decided
+func (d *ProgressDisplay) line(now time.Time, done bool) string {
+	bytesDone := d.bytesDone
+	for _, transferred := range d.transferring {
+		bytesDone += transferred
+	}
+	more := ""
+	if !d.decided {
+		more = "+"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Progress: %d/%d%s files", d.filesDone, d.files, more)
+	if d.filesFailed > 0 {
+		fmt.Fprintf(&b, " (%d failed)", d.filesFailed)
+	}
+	switch {
+	case d.bytes > 0 && d.decided:
+		fmt.Fprintf(&b, ", %s / %s (%d%%)", config.FormatSize(bytesDone), config.FormatSize(d.bytes), bytesDone*100/d.bytes)
+	case d.bytes > 0:
+		fmt.Fprintf(&b, ", %s / %s+", config.FormatSize(bytesDone), config.FormatSize(d.bytes))
+	}
+
+	// A finished run reports its average throughput and how long it took
+	elapsed := now.Sub(d.start)
+	if done {
+		if seconds := elapsed.Seconds(); seconds > 0 && d.bytes > 0 {
+			fmt.Fprintf(&b, ", %s/s", config.FormatSize(int64(float64(bytesDone)/seconds)))
+		}
+		fmt.Fprintf(&b, ", done in %s", elapsed.Round(time.Second))
+		return b.String()
+	}
+
+	// The time left is only known once the totals are final
+	switch rate := d.throughput(now, bytesDone); {
+	case rate > 0 && d.decided:
+		eta := time.Duration(float64(d.bytes-bytesDone) / rate * float64(time.Second))
+		fmt.Fprintf(&b, ", %s/s, ETA %s", config.FormatSize(int64(rate)), eta.Round(time.Second))
+	case rate > 0:
+		fmt.Fprintf(&b, ", %s/s", config.FormatSize(int64(rate)))
+	}
+	return b.String()
+}
+
+// throughput returns the transfer rate, sampled at most once a second and
+// smoothed so that a single slow or fast second does not swing the ETA
+func (d *ProgressDisplay) throughput(now time.Time, bytesDone int64) float64 {
+	elapsed := now.Sub(d.sampleTime)
+	if elapsed < time.Second {
+		return d.rate
+	}
+	current := float64(bytesDone-d.sampleBytes) / elapsed.Seconds()
+	if d.rate == 0 {
+		d.rate = current
+	} else {
+		d.rate = 0.7*d.rate + 0.3*current
+	}
+	d.sampleTime, d.sampleBytes = now, bytesDone
+	return d.rate
+}
+
+// log runs a call of the wrapped observer, which may write to the output,
+// with the progress line cleared and redrawn afterwards
+func (d *ProgressDisplay) log(call func(Observer)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.drawn {
+		call(d.obs)
+		return
+	}
+	fmt.Fprint(d.w, "\r\033[K")
+	call(d.obs)
+	fmt.Fprint(d.w, d.line(time.Now(), false))
+}
+
+func (d *ProgressDisplay) Planned(e FileEvent) {
+	d.log(func(o Observer) { o.Planned(e) })
+}
+
+func (d *ProgressDisplay) Started(e FileEvent) {
+	d.log(func(o Observer) { o.Started(e) })
+}
+
+func (d *ProgressDisplay) Completed(e FileEvent) {
+	d.log(func(o Observer) { o.Completed(e) })
+}
+
+func (d *ProgressDisplay) Skipped(e FileEvent) {
+	d.log(func(o Observer) { o.Skipped(e) })
+}
+
+func (d *ProgressDisplay) Failed(e FileEvent, err error) {
+	d.log(func(o Observer) { o.Failed(e, err) })
+}
+
+func (d *ProgressDisplay) Deleted(e FileEvent) {
+	d.log(func(o Observer) { o.Deleted(e) })
+}
+
+func (d *ProgressDisplay) Warning(err error) {
+	d.log(func(o Observer) { o.Warning(err) })
+}
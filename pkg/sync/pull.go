@@ -5,36 +5,88 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/vngcloud/aiplatform-util/pkg/s3client"
+	"github.com/vngcloud/aiplatform-util/pkg/ignore"
+	"github.com/vngcloud/aiplatform-util/pkg/storage"
 )
 
 // PullOptions contains options for pull operations
 type PullOptions struct {
-	Prefix    string
-	DryRun    bool
-	Delete    bool
-	MountPath string
+	Prefix       string
+	DryRun       bool
+	Delete       bool
+	IncludeGlobs []string
+	ExcludeGlobs []string
+	MountPath    string
+
+	// Parallel is the number of files transferred concurrently
+	Parallel int
+
+	// Checksum compares file content against the object ETag instead of
+	// relying on modification times
+	Checksum bool
+
+	// Limits guards the deletions made with Delete
+	Limits DeleteLimits
+
+	// Trash moves deleted files to the trash instead of removing them
+	Trash bool
+
+	// Plan, when not nil, records every planned action with its
+	// preconditions so it can be applied later. Use with DryRun.
+	Plan *Plan
+
+	// Keys, when not nil, restricts the run to these keys, e.g. the keys
+	// that failed in an earlier run. Delete limits still count every file.
+	Keys []string
+
+	// Observer receives the progress of every file, nil to ignore it
+	Observer Observer
 }
 
-// PullStats contains statistics about a pull operation
+// PullStats contains statistics about a pull operation.
+// Counters are safe to update from concurrent transfers.
 type PullStats struct {
-	Downloaded int
-	Skipped    int
-	Deleted    int
-	Failed     int
+	Downloaded int `json:"downloaded"`
+	Skipped    int `json:"skipped"`
+	Deleted    int `json:"deleted"`
+	Failed     int `json:"failed"`
+
+	// FailedKeys lists the keys that failed, sorted
+	FailedKeys []string `json:"failed_keys,omitempty"`
+
+	mu sync.Mutex
 }
 
-// Pull syncs files from S3 to local workspace
-func Pull(ctx context.Context, client *s3client.Client, opts PullOptions) (*PullStats, error) {
-	stats := &PullStats{}
+// inc increments one of the stats counters
+func (s *PullStats) inc(counter *int) {
+	s.mu.Lock()
+	*counter++
+	s.mu.Unlock()
+}
 
-	// List all objects in S3
-	objects, err := client.ListObjects(ctx, opts.Prefix, true)
-	if err != nil {
-		return nil, fmt.Errorf("failed to list objects: %w", err)
-	}
+// fail counts a failed action on key
+func (s *PullStats) fail(key string) {
+	s.mu.Lock()
+	s.Failed++
+	s.FailedKeys = append(s.FailedKeys, key)
+	s.mu.Unlock()
+}
+
+// Pull syncs files from S3 to local workspace. The remote listing is read
+// as a stream, merged with the local files when deleting, and each object
+// is compared and transferred as the merge reaches it, so memory use does
+// not grow with the size of the bucket or workspace. If the run is
+// interrupted, the stats of the work done so far are returned along with
+// the error.
+func Pull(ctx context.Context, client storage.Storage, opts PullOptions) (*PullStats, error) {
+	stats := &PullStats{}
+	observer := observerOrNop(opts.Observer)
+	ctx = storage.WithProgress(ctx, observer.Progress)
 
 	// Create mount path if it doesn't exist
 	if !opts.DryRun {
@@ -43,109 +95,365 @@ func Pull(ctx context.Context, client *s3client.Client, opts PullOptions) (*Pull
 		}
 	}
 
-	// Download files that need updating
-	for _, obj := range objects {
-		// Skip directories
-		if strings.HasSuffix(obj.Key, "/") {
-			continue
+	// Load the record of previously synced files
+	state, err := LoadState(opts.MountPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Count the deletions before changing anything, so that exceeding a
+	// delete limit aborts the run with nothing changed
+	budget := &deletionBudget{}
+	if opts.Delete {
+		counts, err := mergePull(ctx, client, opts, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := opts.Limits.check(counts.deletions, counts.local, counts.remote == 0); err != nil {
+			return nil, err
 		}
+		budget.left = counts.deletions
+	}
+
+	var trash *Trash
+	if opts.Trash {
+		trash = NewTrash(client, opts.MountPath, time.Now())
+	}
 
-		localPath := filepath.Join(opts.MountPath, obj.Key)
+	p := newPool(ctx, opts.Parallel, observer)
+	sched := &scheduler{obs: observer}
 
-		// Check if local file exists and is up to date
-		needsDownload, reason := needsDownload(obj, localPath)
+	// Download files that need updating
+	download := func(obj storage.Object) error {
+		sched.submit(p, func(ctx context.Context, obs Observer) error {
+			localPath := filepath.Join(opts.MountPath, obj.Key)
+			decision := decideDownload(ctx, client, obj, localPath, state, opts.Checksum)
+			if decision.warning != nil {
+				obs.Warning(decision.warning)
+			}
+			if decision.step == nil {
+				sched.decide()
+				if !opts.DryRun {
+					// A checksum comparison proved the content identical
+					if decision.verified {
+						if info, err := os.Lstat(localPath); err == nil {
+							state.Record(obj.Key, info, obj.ETag)
+						}
+					}
+					stats.inc(&stats.Skipped)
+				}
+				obs.Skipped(FileEvent{Action: EventSkip, Key: obj.Key, Reason: decision.reason, Size: obj.Size})
+				return nil
+			}
 
-		if needsDownload {
-			fmt.Printf("Downloading: %s (%s)\n", obj.Key, reason)
+			sched.decide(*decision.step)
+			event := FileEvent{Action: EventDownload, Key: obj.Key, Reason: decision.step.Reason, Size: obj.Size}
+			obs.Planned(event)
+			if opts.Plan != nil {
+				opts.Plan.add(*decision.step)
+			}
 			if !opts.DryRun {
-				if err := client.DownloadFile(ctx, obj.Key, localPath); err != nil {
-					fmt.Printf("  Failed: %v\n", err)
-					stats.Failed++
-					continue
+				obs.Started(event)
+				if err := client.Get(ctx, obj.Key, localPath); err != nil {
+					obs.Failed(event, err)
+					stats.fail(obj.Key)
+					return ctx.Err()
 				}
-				stats.Downloaded++
+				if info, err := os.Lstat(localPath); err == nil {
+					state.Record(obj.Key, info, obj.ETag)
+				}
+				stats.inc(&stats.Downloaded)
+				obs.Completed(event)
+			}
+			return nil
+		})
+		return p.Err()
+	}
+
+	// Delete local files missing from the remote
+	remove := func(file localFile) error {
+		if !budget.take() {
+			p.Go(func(_ context.Context, obs Observer) error {
+				obs.Warning(errOverBudget(file.key))
+				return nil
+			})
+			return p.Err()
+		}
+
+		// Files recorded in the sync state were deleted remotely,
+		// others were created locally and never pushed
+		reason := "not in remote"
+		if _, synced := state.Get(file.key); synced {
+			reason = "deleted remotely"
+		}
+
+		sched.submit(p, func(ctx context.Context, obs Observer) error {
+			step := PlanStep{
+				Action: PlanDeleteLocal,
+				Key:    file.key,
+				Reason: reason,
+				Size:   file.info.Size(),
+				Local:  localCondition(file.info),
+			}
+			sched.decide(step)
+			event := FileEvent{Action: EventDeleteLocal, Key: file.key, Reason: reason, Size: file.info.Size()}
+			obs.Planned(event)
+			if opts.Plan != nil {
+				opts.Plan.add(step)
 			}
-		} else {
 			if !opts.DryRun {
-				stats.Skipped++
+				obs.Started(event)
+				if err := deleteLocal(trash, file.key, file.path); err != nil {
+					obs.Failed(event, err)
+					stats.fail(file.key)
+					return nil
+				}
+				state.Delete(file.key)
+				stats.inc(&stats.Deleted)
+				obs.Deleted(event)
 			}
+			return nil
+		})
+		return p.Err()
+	}
+
+	if _, err := mergePull(ctx, client, opts, download, remove); err != nil {
+		p.fail(err)
+	}
+	sched.done()
+	err = p.Wait()
+
+	// Persist progress even if the run was interrupted
+	if !opts.DryRun {
+		if saveErr := state.Save(); saveErr != nil {
+			observer.Warning(saveErr)
 		}
 	}
+	sort.Strings(stats.FailedKeys)
+	if err != nil {
+		return stats, fmt.Errorf("pull interrupted: %w", err)
+	}
+	return stats, nil
+}
+
+// pullCounts are the numbers the delete limits of a pull are checked
+// against: the local files to delete out of the local files, and the
+// remote files
+type pullCounts struct {
+	deletions int
+	local     int
+	remote    int
+}
+
+// mergePull reads the remote listing, merged with the local files when
+// deleting. download is called with every remote object to pull, and
+// remove with every local file to delete when deleting. Both may be nil to
+// only count the files.
+func mergePull(ctx context.Context, client storage.Storage, opts PullOptions, download func(obj storage.Object) error, remove func(file localFile) error) (pullCounts, error) {
+	// Include/exclude patterns are relative to the prefix
+	filter := ignore.NewFilter(opts.Prefix, opts.IncludeGlobs, opts.ExcludeGlobs)
+	only := newKeySet(opts.Keys)
+	deletions := &deletionPlanner{only: only, remove: remove}
 
-	// Handle deletions if requested
+	// The local files are only needed to find the ones to delete. Excluded
+	// files and files ignored by .nvignore are local-only and never deleted.
+	walk := func(fn func(localFile) error) error { return nil }
 	if opts.Delete {
-		// Build set of remote keys for quick lookup
-		remoteKeys := make(map[string]bool)
-		for _, obj := range objects {
-			if !strings.HasSuffix(obj.Key, "/") {
-				remoteKeys[obj.Key] = true
-			}
+		walk = walkFiles(opts.MountPath, opts.Prefix, ignore.New(), filter, walkOptions{links: PreserveSymlinks})
+	}
+	list := func(fn func(storage.Object) error) error {
+		if err := client.ListEach(ctx, opts.Prefix, true, "", fn); err != nil {
+			return fmt.Errorf("failed to list objects: %w", err)
 		}
+		return nil
+	}
 
-		// Walk local directory and find files to delete
-		prefixPath := filepath.Join(opts.MountPath, opts.Prefix)
-		err := filepath.Walk(prefixPath, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
+	err := joinListings(ctx, walk, list, func(local *localFile, remote *storage.Object) error {
+		if local != nil {
+			if err := deletions.local(*local, remote != nil); err != nil {
 				return err
 			}
+		}
+		if remote == nil {
+			return nil
+		}
 
-			// Skip directories
-			if info.IsDir() {
-				return nil
-			}
-
-			// Get relative path
-			relPath, err := filepath.Rel(opts.MountPath, path)
-			if err != nil {
+		// Skip the workspace metadata directory and excluded files.
+		// Directory markers are created as empty directories.
+		name, isDir := strings.CutSuffix(remote.Key, "/")
+		if name == "" || isInternalPath(remote.Key) || !filter.Selected(name, isDir) {
+			return nil
+		}
+		if !isDir {
+			if err := deletions.remote(remote.Key); err != nil {
 				return err
 			}
+		}
+		if download == nil || !only.selected(remote.Key) {
+			return nil
+		}
+		return download(*remote)
+	})
+	if err != nil {
+		return deletions.counts, err
+	}
+	return deletions.counts, deletions.done()
+}
 
-			// Convert to forward slashes for S3 key comparison
-			relPath = filepath.ToSlash(relPath)
+// deletionPlanner finds the local files missing from the remote as pull
+// merges the local files with the remote listing, and passes them to remove
+// unless it is nil
+type deletionPlanner struct {
+	only   keySet
+	remove func(file localFile) error
 
-			// Check if file exists in remote
-			if !remoteKeys[relPath] {
-				fmt.Printf("Deleting local: %s (not in remote)\n", relPath)
-				if !opts.DryRun {
-					if err := os.Remove(path); err != nil {
-						fmt.Printf("  Failed to delete: %v\n", err)
-						stats.Failed++
-					} else {
-						stats.Deleted++
-					}
-				}
+	// links are the local links waiting for the remote keys below them.
+	// A link to a directory pushed by following it is kept.
+	links []localFile
+
+	counts pullCounts
+}
+
+// local records a local file, inRemote tells whether an object is stored
+// under its key
+func (d *deletionPlanner) local(file localFile, inRemote bool) error {
+	d.counts.local++
+	if inRemote || !d.only.selected(file.key) {
+		return nil
+	}
+	if isLink(file.info) {
+		d.links = append(d.links, file)
+		return nil
+	}
+	return d.delete(file)
+}
+
+// remote records a remote file, which is compared with the links waiting
+// for it. Keys below a link sort right after its key, so a link is deleted
+// once a key past them is listed.
+func (d *deletionPlanner) remote(key string) error {
+	d.counts.remote++
+	links := d.links[:0]
+	for _, link := range d.links {
+		switch dir := link.key + "/"; {
+		case strings.HasPrefix(key, dir):
+			// The link is kept
+		case key > dir:
+			if err := d.delete(link); err != nil {
+				return err
 			}
+		default:
+			links = append(links, link)
+		}
+	}
+	d.links = links
+	return nil
+}
 
-			return nil
-		})
-		if err != nil && !os.IsNotExist(err) {
-			return nil, fmt.Errorf("failed to walk directory: %w", err)
+// done deletes the links still waiting once the listing is complete
+func (d *deletionPlanner) done() error {
+	for _, link := range d.links {
+		if err := d.delete(link); err != nil {
+			return err
 		}
 	}
+	d.links = nil
+	return nil
+}
 
-	return stats, nil
+// delete counts a local file to delete and passes it to remove
+func (d *deletionPlanner) delete(file localFile) error {
+	d.counts.deletions++
+	if d.remove == nil {
+		return nil
+	}
+	return d.remove(file)
 }
 
-// needsDownload checks if a file needs to be downloaded
-func needsDownload(obj s3client.S3Object, localPath string) (bool, string) {
-	info, err := os.Stat(localPath)
+// decideDownload decides whether pull downloads a remote object to localPath
+func decideDownload(ctx context.Context, client storage.Storage, obj storage.Object, localPath string, state *State, checksum bool) transferDecision {
+	// Files untouched on both sides since the last sync need no comparison
+	localInfo, err := os.Lstat(localPath)
+	if err == nil && state.Unchanged(obj.Key, localInfo, obj.ETag) {
+		return transferDecision{reason: "unchanged since last sync"}
+	}
+
+	download, reason, err := needsDownload(ctx, client, obj, localPath, checksum)
+	if !download {
+		return transferDecision{reason: "up to date", verified: checksum}
+	}
+	return transferDecision{step: &PlanStep{
+		Action:     PlanDownload,
+		Key:        obj.Key,
+		Reason:     reason,
+		Size:       obj.Size,
+		RemoteETag: obj.ETag,
+		Local:      localCondition(localInfo),
+	}, warning: err}
+}
+
+// needsDownload checks if a file needs to be downloaded. The error tells
+// why the file could not be compared, it is then downloaded.
+func needsDownload(ctx context.Context, client storage.Storage, obj storage.Object, localPath string, checksum bool) (bool, string, error) {
+	info, err := os.Lstat(localPath)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return true, "new file"
+			if strings.HasSuffix(obj.Key, "/") {
+				return true, "empty directory", nil
+			}
+			return true, "new file", nil
+		}
+		return true, "stat error", nil
+	}
+
+	// Directory markers only need the directory to exist
+	if strings.HasSuffix(obj.Key, "/") {
+		return false, "", nil
+	}
+
+	// Local links are compared by target with links, and followed otherwise
+	if isLink(info) {
+		target, err := remoteLink(ctx, client, obj)
+		if err != nil {
+			return true, "metadata error", err
+		}
+		if target != "" {
+			if local, err := os.Readlink(localPath); err != nil || local != target {
+				return true, "link target changed", nil
+			}
+			return false, "", nil
+		}
+		if info, err = os.Stat(localPath); err != nil {
+			return true, "broken link", nil
 		}
-		return true, "stat error"
 	}
 
 	// Compare size
 	if info.Size() != obj.Size {
-		return true, "size differs"
+		return true, "size differs", nil
+	}
+
+	// Compare content when requested, ignoring modification times
+	if checksum {
+		matches, err := contentMatches(ctx, client, localPath, obj)
+		if err != nil {
+			return true, "checksum error", err
+		}
+		if !matches {
+			return true, "content differs", nil
+		}
+		return false, "", nil
 	}
 
 	// Compare modification time (with some tolerance for filesystem differences)
-	// If remote is newer, download
-	if obj.LastModified.After(info.ModTime().Add(1 * 1e9)) { // 1 second tolerance
-		return true, "remote is newer"
+	// of the uploaded file. If remote is newer, download
+	remoteTime, err := remoteModTime(ctx, client, obj)
+	if err != nil {
+		return true, "metadata error", err
+	}
+	if remoteTime.After(info.ModTime().Add(1 * 1e9)) { // 1 second tolerance
+		return true, "remote is newer", nil
 	}
 
-	return false, ""
+	return false, "", nil
 }
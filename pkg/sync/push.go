@@ -5,9 +5,13 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/vngcloud/aiplatform-util/pkg/s3client"
+	"github.com/vngcloud/aiplatform-util/pkg/ignore"
+	"github.com/vngcloud/aiplatform-util/pkg/storage"
 )
 
 // PushOptions contains options for push operations
@@ -15,167 +19,445 @@ type PushOptions struct {
 	Prefix       string
 	DryRun       bool
 	Delete       bool
+	IncludeGlobs []string
 	ExcludeGlobs []string
 	MountPath    string
+
+	// Parallel is the number of files transferred concurrently
+	Parallel int
+
+	// Checksum compares file content against the object ETag instead of
+	// relying on modification times
+	Checksum bool
+
+	// Limits guards the deletions made with Delete
+	Limits DeleteLimits
+
+	// Trash moves deleted files to the trash instead of removing them
+	Trash bool
+
+	// Plan, when not nil, records every planned action with its
+	// preconditions so it can be applied later. Use with DryRun.
+	Plan *Plan
+
+	// Keys, when not nil, restricts the run to these keys, e.g. the keys
+	// that failed in an earlier run. Delete limits still count every file,
+	// and directory markers kept with KeepEmptyDirs are always updated.
+	Keys []string
+
+	// StaleUploads, when positive, aborts the incomplete multipart uploads
+	// initiated longer ago than this of every key before it is uploaded
+	StaleUploads time.Duration
+
+	// Symlinks is how symbolic links are pushed, skipped if empty
+	Symlinks SymlinkPolicy
+
+	// KeepEmptyDirs uploads a marker object, whose key ends in "/", for
+	// every directory without files so that pull recreates it. With Delete,
+	// markers of directories that are gone or no longer empty are deleted.
+	KeepEmptyDirs bool
+
+	// Observer receives the progress of every file, nil to ignore it
+	Observer Observer
+}
+
+// localFile is a local file found while walking the workspace
+type localFile struct {
+	key  string
+	path string
+	info os.FileInfo
 }
 
-// PushStats contains statistics about a push operation
+// transferDecision is whether pull or push transfers a file. step is nil
+// when the file is skipped for reason, verified tells that the content was
+// proved identical by a checksum comparison. warning is why the file could
+// not be compared, it is then transferred.
+type transferDecision struct {
+	step     *PlanStep
+	reason   string
+	verified bool
+	warning  error
+}
+
+// PushStats contains statistics about a push operation.
+// Counters are safe to update from concurrent transfers.
 type PushStats struct {
-	Uploaded int
-	Skipped  int
-	Deleted  int
-	Failed   int
+	Uploaded int `json:"uploaded"`
+	Skipped  int `json:"skipped"`
+	Deleted  int `json:"deleted"`
+	Failed   int `json:"failed"`
+
+	// AbortedUploads counts the stale incomplete uploads aborted
+	AbortedUploads int `json:"aborted_uploads,omitempty"`
+
+	// FailedKeys lists the keys that failed, sorted
+	FailedKeys []string `json:"failed_keys,omitempty"`
+
+	mu sync.Mutex
+}
+
+// inc increments one of the stats counters
+func (s *PushStats) inc(counter *int) {
+	s.mu.Lock()
+	*counter++
+	s.mu.Unlock()
+}
+
+// fail counts a failed action on key
+func (s *PushStats) fail(key string) {
+	s.mu.Lock()
+	s.Failed++
+	s.FailedKeys = append(s.FailedKeys, key)
+	s.mu.Unlock()
+}
+
+// Push syncs files from local workspace to S3. The local files and the
+// remote listing are merged as they are read, and each file is compared and
+// transferred as the merge reaches it, so memory use does not grow with the
+// size of the workspace or bucket. If the run is interrupted, the stats of
+// the work done so far are returned along with the error.
+func Push(ctx context.Context, client storage.Storage, opts PushOptions) (*PushStats, error) {
+	stats, _, err := runPush(ctx, client, opts, mergePush)
+	return stats, err
 }
 
-// Push syncs files from local workspace to S3
-func Push(ctx context.Context, client *s3client.Client, opts PushOptions) (*PushStats, error) {
+// pushMerge reports the files a push compares, see mergePush
+type pushMerge func(ctx context.Context, client storage.Storage, opts PushOptions, warn func(error), upload func(file localFile, remote *storage.Object) error, remove func(obj storage.Object) error) (pushCounts, error)
+
+// runPush pushes the files reported by merge and also returns the counts
+// the delete limits were checked against, zero when not deleting
+func runPush(ctx context.Context, client storage.Storage, opts PushOptions, merge pushMerge) (*PushStats, pushCounts, error) {
 	stats := &PushStats{}
+	observer := observerOrNop(opts.Observer)
+	ctx = storage.WithProgress(ctx, observer.Progress)
 
-	// List all objects in S3 (for comparison and deletion)
-	remoteObjects, err := client.ListObjects(ctx, opts.Prefix, true)
+	// Load the record of previously synced files
+	state, err := LoadState(opts.MountPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list remote objects: %w", err)
+		return nil, pushCounts{}, err
 	}
 
-	// Build map of remote objects for quick lookup
-	remoteFiles := make(map[string]s3client.S3Object)
-	for _, obj := range remoteObjects {
-		if !strings.HasSuffix(obj.Key, "/") {
-			remoteFiles[obj.Key] = obj
-		}
-	}
-
-	// Walk local directory and upload files
-	localFiles := make(map[string]bool)
-	prefixPath := filepath.Join(opts.MountPath, opts.Prefix)
-
 	// Check if prefix path exists
+	prefixPath := filepath.Join(opts.MountPath, opts.Prefix)
 	if _, err := os.Stat(prefixPath); os.IsNotExist(err) {
-		// If prefix path doesn't exist and we're not deleting, just skip
+		// If prefix path doesn't exist and we're not deleting, just skip.
+		// If deleting, we still need to process remote deletions.
 		if !opts.Delete {
-			fmt.Printf("Local path %s does not exist, nothing to push\n", prefixPath)
-			return stats, nil
+			observer.Warning(fmt.Errorf("local path %s does not exist, nothing to push", prefixPath))
+			return stats, pushCounts{}, nil
 		}
-		// If deleting, we still need to process remote deletions
 	} else if err != nil {
-		return nil, fmt.Errorf("failed to stat prefix path: %w", err)
-	} else {
-		// Walk the directory
-		err = filepath.Walk(prefixPath, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
+		return nil, pushCounts{}, fmt.Errorf("failed to stat prefix path: %w", err)
+	}
 
-			// Skip directories
-			if info.IsDir() {
-				return nil
-			}
+	// Count the deletions before changing anything, so that exceeding a
+	// delete limit aborts the run with nothing changed
+	budget := &deletionBudget{}
+	var counts pushCounts
+	if opts.Delete {
+		counts, err = merge(ctx, client, opts, nil, nil, nil)
+		if err != nil {
+			return nil, pushCounts{}, err
+		}
+		if err := opts.Limits.check(counts.deletions, counts.selected, counts.local == 0); err != nil {
+			return nil, pushCounts{}, err
+		}
+		budget.left = counts.deletions
+	}
 
-			// Get relative path
-			relPath, err := filepath.Rel(opts.MountPath, path)
-			if err != nil {
-				return err
-			}
+	// Incomplete uploads left behind by earlier runs are aborted before
+	// their key is uploaded again
+	var stale map[string][]storage.Upload
+	multipart := storage.UploadsOf(client)
+	if opts.StaleUploads > 0 && !opts.DryRun && multipart != nil {
+		stale, err = staleUploads(ctx, multipart, opts.Prefix, time.Now().Add(-opts.StaleUploads))
+		if err != nil {
+			observer.Warning(err)
+		}
+	}
 
-			// Convert to forward slashes for S3 key
-			s3Key := filepath.ToSlash(relPath)
+	// Applying the plan must read linked files the same way
+	if opts.Plan != nil {
+		opts.Plan.Symlinks = opts.Symlinks
+	}
 
-			// Check if file should be excluded
-			if shouldExclude(s3Key, opts.ExcludeGlobs) {
-				return nil
-			}
+	var trash *Trash
+	if opts.Trash {
+		trash = NewTrash(client, opts.MountPath, time.Now())
+	}
 
-			// Mark as seen
-			localFiles[s3Key] = true
+	p := newPool(ctx, opts.Parallel, observer)
+	sched := &scheduler{obs: observer}
 
-			// Check if file needs uploading
-			needsUpload, reason := needsUpload(path, info, remoteFiles[s3Key])
+	// Warnings of the walk are reported in order with the files
+	warn := func(err error) {
+		p.Go(func(_ context.Context, obs Observer) error {
+			obs.Warning(err)
+			return nil
+		})
+	}
 
-			if needsUpload {
-				fmt.Printf("Uploading: %s (%s)\n", s3Key, reason)
+	upload := func(file localFile, remote *storage.Object) error {
+		var remoteObj storage.Object
+		if remote != nil {
+			remoteObj = *remote
+		}
+		sched.submit(p, func(ctx context.Context, obs Observer) error {
+			s3Key, path, info := file.key, file.path, file.info
+			decision := decideUpload(ctx, client, file, remoteObj, state, opts.Checksum)
+			if decision.warning != nil {
+				obs.Warning(decision.warning)
+			}
+			if decision.step == nil {
+				sched.decide()
 				if !opts.DryRun {
-					if err := client.UploadFile(ctx, path, s3Key); err != nil {
-						fmt.Printf("  Failed: %v\n", err)
-						stats.Failed++
-						return nil
+					// A checksum comparison proved the content identical
+					if decision.verified {
+						state.Record(s3Key, info, remoteObj.ETag)
 					}
-					stats.Uploaded++
+					stats.inc(&stats.Skipped)
 				}
-			} else {
-				if !opts.DryRun {
-					stats.Skipped++
+				obs.Skipped(FileEvent{Action: EventSkip, Key: s3Key, Reason: decision.reason, Size: storedSize(info)})
+				return nil
+			}
+
+			sched.decide(*decision.step)
+			event := FileEvent{Action: EventUpload, Key: s3Key, Reason: decision.step.Reason, Size: storedSize(info)}
+			obs.Planned(event)
+			if opts.Plan != nil {
+				opts.Plan.add(*decision.step)
+			}
+			if opts.DryRun {
+				return nil
+			}
+
+			for _, upload := range stale[s3Key] {
+				if err := multipart.AbortUpload(ctx, upload); err != nil {
+					obs.Warning(err)
+					continue
 				}
+				stats.inc(&stats.AbortedUploads)
+			}
+			obs.Started(event)
+			uploaded, err := client.Put(ctx, path, s3Key)
+			if err != nil {
+				obs.Failed(event, err)
+				stats.fail(s3Key)
+				return ctx.Err()
 			}
+			// Record the file as it was before the upload started,
+			// so later edits are never mistaken for synced content
+			state.Record(s3Key, info, uploaded.ETag)
+			stats.inc(&stats.Uploaded)
+			obs.Completed(event)
+			return nil
+		})
+		return p.Err()
+	}
+
+	// Delete remote objects missing locally
+	remove := func(obj storage.Object) error {
+		if !budget.take() {
+			warn(errOverBudget(obj.Key))
+			return p.Err()
+		}
 
+		// Files recorded in the sync state were deleted locally,
+		// others were created remotely and never pulled
+		reason := "not in local"
+		if _, synced := state.Get(obj.Key); synced {
+			reason = "deleted locally"
+		}
+
+		sched.submit(p, func(ctx context.Context, obs Observer) error {
+			step := PlanStep{
+				Action:     PlanDeleteRemote,
+				Key:        obj.Key,
+				Reason:     reason,
+				Size:       obj.Size,
+				RemoteETag: obj.ETag,
+			}
+			sched.decide(step)
+			event := FileEvent{Action: EventDeleteRemote, Key: obj.Key, Reason: reason, Size: obj.Size}
+			obs.Planned(event)
+			if opts.Plan != nil {
+				opts.Plan.add(step)
+			}
+			if !opts.DryRun {
+				obs.Started(event)
+				if err := deleteRemote(ctx, client, trash, obj.Key); err != nil {
+					obs.Failed(event, err)
+					stats.fail(obj.Key)
+					return ctx.Err()
+				}
+				state.Delete(obj.Key)
+				stats.inc(&stats.Deleted)
+				obs.Deleted(event)
+			}
 			return nil
 		})
-		if err != nil {
-			return nil, fmt.Errorf("failed to walk directory: %w", err)
+		return p.Err()
+	}
+
+	if _, err := merge(ctx, client, opts, warn, upload, remove); err != nil {
+		p.fail(err)
+	}
+	sched.done()
+	err = p.Wait()
+
+	// Persist progress even if the run was interrupted
+	if !opts.DryRun {
+		if saveErr := state.Save(); saveErr != nil {
+			observer.Warning(saveErr)
 		}
 	}
+	sort.Strings(stats.FailedKeys)
+	if err != nil {
+		return stats, counts, fmt.Errorf("push interrupted: %w", err)
+	}
+	return stats, counts, nil
+}
 
-	// Handle deletions if requested
-	if opts.Delete {
-		for key := range remoteFiles {
-			if !localFiles[key] {
-				fmt.Printf("Deleting remote: %s (not in local)\n", key)
-				if !opts.DryRun {
-					if err := client.DeleteObject(ctx, key); err != nil {
-						fmt.Printf("  Failed to delete: %v\n", err)
-						stats.Failed++
-					} else {
-						stats.Deleted++
+// pushCounts are the numbers the delete limits of a push are checked
+// against: the remote objects to delete out of the remote objects selected
+// by the filters, and the local files
+type pushCounts struct {
+	deletions int
+	selected  int
+	local     int
+}
+
+// mergePush merges the local files with the remote listing. upload is
+// called with every local file to push and the remote object stored under
+// its key, nil if there is none, and remove with every remote object to
+// delete when deleting. Both may be nil to only count the files, warn
+// receives the warnings of the walk.
+func mergePush(ctx context.Context, client storage.Storage, opts PushOptions, warn func(error), upload func(file localFile, remote *storage.Object) error, remove func(obj storage.Object) error) (pushCounts, error) {
+	var counts pushCounts
+
+	// Include/exclude patterns are relative to the prefix, .nvignore files
+	// found while walking are relative to their directory
+	filter := ignore.NewFilter(opts.Prefix, opts.IncludeGlobs, opts.ExcludeGlobs)
+	matcher := ignore.New()
+	only := newKeySet(opts.Keys)
+	skippedLinks := make(map[string]bool)
+
+	walk := walkFiles(opts.MountPath, opts.Prefix, matcher, filter, walkOptions{links: opts.Symlinks, emptyDirs: opts.KeepEmptyDirs, warn: warn, skipped: skippedLinks})
+	list := func(fn func(storage.Object) error) error {
+		if err := client.ListEach(ctx, opts.Prefix, true, "", fn); err != nil {
+			return fmt.Errorf("failed to list remote objects: %w", err)
+		}
+		return nil
+	}
+
+	err := joinListings(ctx, walk, list, func(local *localFile, remote *storage.Object) error {
+		// Directory markers are only compared when they are kept
+		if remote != nil && ((strings.HasSuffix(remote.Key, "/") && !opts.KeepEmptyDirs) || isInternalPath(remote.Key)) {
+			remote = nil
+		}
+
+		// Excluded and ignored files are never deleted, skipped links are
+		// left alone. The walk has passed the key, so the .nvignore files
+		// and links that apply to it are known.
+		if opts.Delete && remote != nil {
+			name, isDir := strings.CutSuffix(remote.Key, "/")
+			if filter.Selected(name, isDir) && !matcher.Match(name, isDir) {
+				counts.selected++
+				if local == nil && !skippedLinks[remote.Key] && (isDir || only.selected(remote.Key)) {
+					counts.deletions++
+					if remove != nil {
+						if err := remove(*remote); err != nil {
+							return err
+						}
 					}
 				}
 			}
 		}
+
+		if local == nil {
+			return nil
+		}
+		counts.local++
+		if upload == nil || (!only.selected(local.key) && !strings.HasSuffix(local.key, "/")) {
+			return nil
+		}
+		return upload(*local, remote)
+	})
+	return counts, err
+}
+
+// decideUpload decides whether push uploads a local file
+func decideUpload(ctx context.Context, client storage.Storage, file localFile, remoteObj storage.Object, state *State, checksum bool) transferDecision {
+	// Files untouched on both sides since the last sync need no comparison
+	if remoteObj.Key != "" && state.Unchanged(file.key, file.info, remoteObj.ETag) {
+		return transferDecision{reason: "unchanged since last sync"}
 	}
 
-	return stats, nil
+	upload, reason, err := needsUpload(ctx, client, file.path, file.info, remoteObj, checksum)
+	if !upload {
+		return transferDecision{reason: "up to date", verified: checksum}
+	}
+	return transferDecision{step: &PlanStep{
+		Action:     PlanUpload,
+		Key:        file.key,
+		Reason:     reason,
+		Size:       storedSize(file.info),
+		RemoteETag: remoteObj.ETag,
+		Local:      localCondition(file.info),
+	}, warning: err}
 }
 
-// needsUpload checks if a file needs to be uploaded
-func needsUpload(_ string, localInfo os.FileInfo, remoteObj s3client.S3Object) (bool, string) {
+// needsUpload checks if a file needs to be uploaded. The error tells why
+// the file could not be compared, it is then uploaded.
+func needsUpload(ctx context.Context, client storage.Storage, localPath string, localInfo os.FileInfo, remoteObj storage.Object, checksum bool) (bool, string, error) {
+	// Empty directories only need their marker to exist
+	if localInfo.IsDir() {
+		return remoteObj.Key == "", "empty directory", nil
+	}
+
 	// If remote doesn't exist, upload
 	if remoteObj.Key == "" {
-		return true, "new file"
+		return true, "new file", nil
 	}
 
-	// Compare size
-	if localInfo.Size() != remoteObj.Size {
-		return true, "size differs"
+	// Links are compared by their target
+	if isLink(localInfo) {
+		matches, err := linkMatches(ctx, client, localPath, remoteObj)
+		if err != nil {
+			return true, "metadata error", err
+		}
+		if !matches {
+			return true, "link target changed", nil
+		}
+		return false, "", nil
 	}
 
-	// Compare modification time (with some tolerance)
-	// If local is newer, upload
-	if localInfo.ModTime().After(remoteObj.LastModified.Add(1 * 1e9)) { // 1 second tolerance
-		return true, "local is newer"
+	// Compare size
+	if localInfo.Size() != remoteObj.Size {
+		return true, "size differs", nil
 	}
 
-	return false, ""
-}
-
-// shouldExclude checks if a path matches any exclude patterns
-func shouldExclude(path string, patterns []string) bool {
-	for _, pattern := range patterns {
-		// Simple glob matching (could be enhanced with filepath.Match)
-		matched, err := filepath.Match(pattern, path)
-		if err == nil && matched {
-			return true
+	// Compare content when requested, ignoring modification times
+	if checksum {
+		matches, err := contentMatches(ctx, client, localPath, remoteObj)
+		if err != nil {
+			return true, "checksum error", err
 		}
-
-		// Check if pattern matches as prefix (for directory patterns)
-		if strings.HasSuffix(pattern, "/*") {
-			prefix := strings.TrimSuffix(pattern, "/*")
-			if strings.HasPrefix(path, prefix+"/") {
-				return true
-			}
+		if !matches {
+			return true, "content differs", nil
 		}
+		return false, "", nil
+	}
 
-		// Exact match
-		if path == pattern {
-			return true
-		}
+	// Compare modification time (with some tolerance) against the one of
+	// the uploaded file. If local is newer, upload
+	remoteTime, err := remoteModTime(ctx, client, remoteObj)
+	if err != nil {
+		return true, "metadata error", err
+	}
+	if localInfo.ModTime().After(remoteTime.Add(1 * 1e9)) { // 1 second tolerance
+		return true, "local is newer", nil
 	}
-	return false
+
+	return false, "", nil
 }
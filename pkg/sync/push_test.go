@@ -0,0 +1,97 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vngcloud/aiplatform-util/pkg/storage"
+)
+
+// past is a modification time old enough to never look newer than a transfer
+var past = time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+
+// writeFile writes a workspace file with the given modification time
+func writeFile(t *testing.T, mountPath string, key string, data string, modified time.Time) {
+	t.Helper()
+	path := filepath.Join(mountPath, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// removingListing removes a local path once its listing reaches a key, to
+// change the workspace while push walks it
+type removingListing struct {
+	storage.Storage
+	key  string
+	path string
+}
+
+func (s *removingListing) ListEach(ctx context.Context, prefix string, recursive bool, startAfter string, fn func(storage.Object) error) error {
+	return s.Storage.ListEach(ctx, prefix, recursive, startAfter, func(obj storage.Object) error {
+		if obj.Key == s.key {
+			if err := os.RemoveAll(s.path); err != nil {
+				return err
+			}
+		}
+		return fn(obj)
+	})
+}
+
+func TestPushDeleteSkipsDirectoryRemovedDuringWalk(t *testing.T) {
+	mountPath := t.TempDir()
+	remote := storage.NewMemory()
+	for _, key := range []string{"a.txt", "b/x.txt", "c/1"} {
+		writeFile(t, mountPath, key, key, past)
+		remote.PutBytes(key, []byte(key), past)
+	}
+
+	// b is removed after the walk read the workspace root
+	client := &removingListing{Storage: remote, key: "a.txt", path: filepath.Join(mountPath, "b")}
+	stats, err := Push(context.Background(), client, PushOptions{MountPath: mountPath, Delete: true, Parallel: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Deleted != 1 {
+		t.Errorf("deleted %d objects, want only the one of the removed directory", stats.Deleted)
+	}
+	if _, err := remote.GetBytes("c/1"); err != nil {
+		t.Errorf("push deleted a file walked after the removed directory: %v", err)
+	}
+	if _, err := remote.GetBytes("b/x.txt"); err == nil {
+		t.Error("push kept the file of the removed directory")
+	}
+}
+
+func TestPushFailsOnUnreadableDirectory(t *testing.T) {
+	if os.Getuid() == 0 {
+		t.Skip("directory permissions do not apply to root")
+	}
+	mountPath := t.TempDir()
+	remote := storage.NewMemory()
+	for _, key := range []string{"a.txt", "b/x.txt"} {
+		writeFile(t, mountPath, key, key, past)
+		remote.PutBytes(key, []byte(key), past)
+	}
+	dir := filepath.Join(mountPath, "b")
+	if err := os.Chmod(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(dir, 0755) })
+
+	if _, err := Push(context.Background(), remote, PushOptions{MountPath: mountPath, Delete: true}); err == nil {
+		t.Fatal("push --delete succeeded without reading a directory")
+	}
+	if _, err := remote.GetBytes("b/x.txt"); err != nil {
+		t.Errorf("failed push deleted a file: %v", err)
+	}
+}
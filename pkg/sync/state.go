@@ -0,0 +1,186 @@
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// stateDir is the workspace directory holding tool metadata, it is never
+	// pushed to or deleted by a pull from the network volume
+	stateDir = ".aiplatform"
+
+	// stateFile is the name of the sync state database inside stateDir
+	stateFile = "state.db"
+)
+
+// StateEntry records a file as it was after its last successful transfer.
+// Files are compared with it by size, mtime and ETag. No hash of the local
+// content is kept: it matched the object after the transfer, so checksum
+// comparisons reproduce the ETag from the local file instead.
+type StateEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	ETag    string    `json:"etag"`
+}
+
+// State is the per-workspace record of synced files, stored under
+// MountPath/.aiplatform/state.db. It lets pull and push skip unchanged
+// files without hashing them and tell files deleted since the last sync
+// apart from files that were never synced. It is safe for concurrent use.
+// Unlike the listings, the whole state is held in memory, so its size grows
+// with the number of files synced.
+type State struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]StateEntry
+}
+
+// stateData is the on-disk representation of State
+type stateData struct {
+	Version int                   `json:"version"`
+	Entries map[string]StateEntry `json:"entries"`
+}
+
+// LoadState reads the sync state of the workspace at mountPath.
+// A missing state file yields an empty state.
+func LoadState(mountPath string) (*State, error) {
+	state := &State{
+		path:    filepath.Join(mountPath, stateDir, stateFile),
+		entries: make(map[string]StateEntry),
+	}
+
+	data, err := os.ReadFile(state.path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sync state %s: %w", state.path, err)
+	}
+
+	var stored stateData
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, fmt.Errorf("failed to parse sync state %s: %w", state.path, err)
+	}
+	if stored.Entries != nil {
+		state.entries = stored.Entries
+	}
+
+	return state, nil
+}
+
+// Get returns the entry recorded for key
+func (s *State) Get(key string) (StateEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[key]
+	return entry, ok
+}
+
+// Record stores the size and mtime of a local file together with the remote
+// ETag it was synced with. Directories are not recorded, as their marker
+// objects have no content to compare.
+func (s *State) Record(key string, localInfo os.FileInfo, etag string) {
+	if localInfo.IsDir() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[key] = StateEntry{
+		Size:    localInfo.Size(),
+		ModTime: localInfo.ModTime(),
+		ETag:    etag,
+	}
+}
+
+// Keys returns the sorted keys recorded under prefix. They are copied and
+// sorted on every call, which costs time and memory in the number of keys.
+func (s *State) Keys(prefix string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []string
+	for key := range s.entries {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Delete forgets the entry recorded for key
+func (s *State) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+}
+
+// Unchanged reports whether neither the local file nor the remote object
+// changed since they were last synced, which avoids comparing or hashing them
+func (s *State) Unchanged(key string, localInfo os.FileInfo, etag string) bool {
+	entry, ok := s.Get(key)
+	if !ok || localInfo == nil {
+		return false
+	}
+	return entry.Size == localInfo.Size() &&
+		entry.ModTime.Equal(localInfo.ModTime()) &&
+		entry.ETag == etag
+}
+
+// Save atomically writes the state by replacing the state file with a
+// fully written and synced temporary file
+func (s *State) Save() error {
+	s.mu.Lock()
+	data, err := json.Marshal(stateData{Version: 1, Entries: s.entries})
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode sync state: %w", err)
+	}
+
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+
+	tmp, err := os.CreateTemp(dir, stateFile+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write sync state: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close state file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to replace sync state %s: %w", s.path, err)
+	}
+	return nil
+}
+
+// isInternalPath reports whether a workspace-relative slash path belongs to
+// the tool's own metadata directory or the remote trash, which are never synced
+func isInternalPath(relPath string) bool {
+	for _, dir := range []string{stateDir, trashDir} {
+		if relPath == dir || strings.HasPrefix(relPath, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
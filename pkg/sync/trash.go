@@ -0,0 +1,322 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vngcloud/aiplatform-util/pkg/storage"
+)
+
+const (
+	// trashDir is the remote prefix holding soft-deleted objects as
+	// .trash/<timestamp>/<key>, it is never pulled, pushed or synced
+	trashDir = ".trash"
+
+	// localTrashDir is the directory inside stateDir holding soft-deleted
+	// local files as trash/<timestamp>/<key>
+	localTrashDir = "trash"
+
+	// stampFormat names the directory shared by the files trashed in one
+	// run, and the copies kept of conflicting files. Nanoseconds keep runs
+	// started within the same second apart.
+	stampFormat = "20060102T150405.000000000"
+
+	// trashStampFormat parses trash directory names. Parsing accepts the
+	// fractional seconds of stampFormat, and directories named before they
+	// were added.
+	trashStampFormat = "20060102T150405"
+)
+
+// Trash location names reported in TrashEntry.Location
+const (
+	TrashRemote = "remote"
+	TrashLocal  = "local"
+)
+
+// Trash moves deleted files aside instead of destroying them. Remote objects
+// are copied on the server side to .trash/<timestamp>/ before removal and
+// local files are moved to MountPath/.aiplatform/trash/<timestamp>/.
+// All files trashed through one Trash share a timestamp.
+type Trash struct {
+	client    storage.Storage
+	mountPath string
+	stamp     string
+}
+
+// NewTrash creates a trash for files deleted at now
+func NewTrash(client storage.Storage, mountPath string, now time.Time) *Trash {
+	return &Trash{
+		client:    client,
+		mountPath: mountPath,
+		stamp:     now.UTC().Format(stampFormat),
+	}
+}
+
+// RemoveObject copies an object into the remote trash and then deletes it
+func (t *Trash) RemoveObject(ctx context.Context, key string) error {
+	if err := t.client.Copy(ctx, key, trashDir+"/"+t.stamp+"/"+key); err != nil {
+		return err
+	}
+	return t.client.Delete(ctx, key)
+}
+
+// RemoveLocal moves the local file of key into the local trash
+func (t *Trash) RemoveLocal(key string, path string) error {
+	dest := filepath.Join(t.mountPath, stateDir, localTrashDir, t.stamp, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("failed to create trash directory: %w", err)
+	}
+	if err := os.Rename(path, dest); err != nil {
+		return fmt.Errorf("failed to move %s to trash: %w", key, err)
+	}
+	return nil
+}
+
+// deleteRemote deletes an object, moving it to the trash if trash is not nil
+func deleteRemote(ctx context.Context, client storage.Storage, trash *Trash, key string) error {
+	if trash != nil {
+		return trash.RemoveObject(ctx, key)
+	}
+	return client.Delete(ctx, key)
+}
+
+// deleteLocal deletes a local file, moving it to the trash if trash is not nil
+func deleteLocal(trash *Trash, key string, path string) error {
+	if trash != nil {
+		return trash.RemoveLocal(key, path)
+	}
+	return os.Remove(path)
+}
+
+// IsTrashKey reports whether a remote key belongs to the trash
+func IsTrashKey(key string) bool {
+	return key == trashDir || strings.HasPrefix(key, trashDir+"/")
+}
+
+// TrashEntry is a file kept in the remote or local trash
+type TrashEntry struct {
+	// Location is TrashRemote or TrashLocal
+	Location string `json:"location"`
+
+	// Key is the key the file had before it was deleted
+	Key string `json:"key"`
+
+	// TrashKey is the remote key or workspace-relative path of the trashed file
+	TrashKey string `json:"trash_key"`
+
+	Size    int64     `json:"size"`
+	Deleted time.Time `json:"deleted"`
+}
+
+// ListTrash lists the remote and local trash, most recently deleted first
+func ListTrash(ctx context.Context, client storage.Storage, mountPath string) ([]TrashEntry, error) {
+	var entries []TrashEntry
+
+	objects, err := client.List(ctx, trashDir+"/", true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remote trash: %w", err)
+	}
+	for _, obj := range objects {
+		stamp, key, ok := strings.Cut(strings.TrimPrefix(obj.Key, trashDir+"/"), "/")
+		deleted, err := time.Parse(trashStampFormat, stamp)
+		if !ok || err != nil || key == "" || strings.HasSuffix(key, "/") {
+			continue
+		}
+		entries = append(entries, TrashEntry{
+			Location: TrashRemote,
+			Key:      key,
+			TrashKey: obj.Key,
+			Size:     obj.Size,
+			Deleted:  deleted,
+		})
+	}
+
+	localEntries, err := listLocalTrash(mountPath)
+	if err != nil {
+		return nil, err
+	}
+	entries = append(entries, localEntries...)
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if !entries[i].Deleted.Equal(entries[j].Deleted) {
+			return entries[i].Deleted.After(entries[j].Deleted)
+		}
+		if entries[i].Location != entries[j].Location {
+			return entries[i].Location > entries[j].Location
+		}
+		return entries[i].Key < entries[j].Key
+	})
+	return entries, nil
+}
+
+// listLocalTrash lists the files in the local trash
+func listLocalTrash(mountPath string) ([]TrashEntry, error) {
+	root := filepath.Join(mountPath, stateDir, localTrashDir)
+	stamps, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read local trash: %w", err)
+	}
+
+	var entries []TrashEntry
+	for _, stampDir := range stamps {
+		deleted, err := time.Parse(trashStampFormat, stampDir.Name())
+		if !stampDir.IsDir() || err != nil {
+			continue
+		}
+
+		dir := filepath.Join(root, stampDir.Name())
+		err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			key, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			trashKey, err := filepath.Rel(mountPath, path)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, TrashEntry{
+				Location: TrashLocal,
+				Key:      filepath.ToSlash(key),
+				TrashKey: filepath.ToSlash(trashKey),
+				Size:     info.Size(),
+				Deleted:  deleted,
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read local trash: %w", err)
+		}
+	}
+	return entries, nil
+}
+
+// RestoreTrash restores a trashed file to its original key. name is either
+// the original key, which restores its most recently deleted version, or the
+// trash key shown by ListTrash. Only the trash at location is searched.
+// An existing file at the original key is only replaced with overwrite.
+func RestoreTrash(ctx context.Context, client storage.Storage, mountPath string, location string, name string, overwrite bool) (*TrashEntry, error) {
+	entries, err := ListTrash(ctx, client, mountPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Entries are sorted newest first, so the first match is the latest version
+	var entry *TrashEntry
+	for i := range entries {
+		if entries[i].Location == location && (entries[i].Key == name || entries[i].TrashKey == name) {
+			entry = &entries[i]
+			break
+		}
+	}
+	if entry == nil {
+		return nil, fmt.Errorf("%s not found in %s trash", name, location)
+	}
+
+	if location == TrashLocal {
+		dest := filepath.Join(mountPath, filepath.FromSlash(entry.Key))
+		if _, err := os.Stat(dest); err == nil && !overwrite {
+			return nil, fmt.Errorf("%s already exists locally (use --force to overwrite)", entry.Key)
+		}
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create directory for %s: %w", dest, err)
+		}
+		if err := os.Rename(filepath.Join(mountPath, filepath.FromSlash(entry.TrashKey)), dest); err != nil {
+			return nil, fmt.Errorf("failed to restore %s: %w", entry.Key, err)
+		}
+		return entry, nil
+	}
+
+	if _, err := client.Stat(ctx, entry.Key); err == nil && !overwrite {
+		return nil, fmt.Errorf("%s already exists in the network volume (use --force to overwrite)", entry.Key)
+	} else if err != nil && !storage.IsNotFound(err) {
+		return nil, err
+	}
+	if err := client.Copy(ctx, entry.TrashKey, entry.Key); err != nil {
+		return nil, err
+	}
+	if err := client.Delete(ctx, entry.TrashKey); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// TrashStats contains statistics about emptying the trash
+type TrashStats struct {
+	Deleted int `json:"deleted"`
+	Failed  int `json:"failed"`
+}
+
+// EmptyTrash permanently deletes the trashed files deleted before cutoff
+// from both the remote and the local trash, logging each file to log if it
+// is not nil
+func EmptyTrash(ctx context.Context, client storage.Storage, mountPath string, cutoff time.Time, dryRun bool, log io.Writer) (*TrashStats, error) {
+	stats := &TrashStats{}
+	if log == nil {
+		log = io.Discard
+	}
+
+	entries, err := ListTrash(ctx, client, mountPath)
+	if err != nil {
+		return nil, err
+	}
+
+	expired := make(map[string]bool)
+	for _, entry := range entries {
+		if !entry.Deleted.Before(cutoff) {
+			continue
+		}
+
+		fmt.Fprintf(log, "Deleting %s: %s\n", entry.Location, entry.TrashKey)
+		if dryRun {
+			continue
+		}
+
+		if entry.Location == TrashLocal {
+			err = os.Remove(filepath.Join(mountPath, filepath.FromSlash(entry.TrashKey)))
+			stamp, _, _ := strings.Cut(strings.TrimPrefix(entry.TrashKey, stateDir+"/"+localTrashDir+"/"), "/")
+			expired[stamp] = true
+		} else {
+			err = client.Delete(ctx, entry.TrashKey)
+		}
+		if err != nil {
+			fmt.Fprintf(log, "  Failed to delete: %v\n", err)
+			stats.Failed++
+			continue
+		}
+		stats.Deleted++
+	}
+
+	// Remove the directories left behind by emptied local trash timestamps
+	for stamp := range expired {
+		dir := filepath.Join(mountPath, stateDir, localTrashDir, stamp)
+		if remaining, err := countFiles(dir); err == nil && remaining == 0 {
+			os.RemoveAll(dir)
+		}
+	}
+
+	return stats, nil
+}
+
+// countFiles counts the regular files below dir
+func countFiles(dir string) (int, error) {
+	count := 0
+	err := filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			count++
+		}
+		return err
+	})
+	return count, err
+}
@@ -0,0 +1,76 @@
+package sync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/vngcloud/aiplatform-util/pkg/storage"
+)
+
+// UploadStats contains statistics about aborting incomplete uploads
+type UploadStats struct {
+	Aborted int `json:"aborted"`
+	Failed  int `json:"failed"`
+
+	// Size is the total size of the parts of the aborted uploads
+	Size int64 `json:"size"`
+}
+
+// AbortUploads aborts the incomplete multipart uploads below prefix that
+// were initiated before cutoff, logging each upload to log if it is not
+// nil. If the run is interrupted, the stats of the uploads aborted so far
+// are returned along with the error.
+func AbortUploads(ctx context.Context, uploads storage.Uploads, prefix string, cutoff time.Time, dryRun bool, log io.Writer) (*UploadStats, error) {
+	stats := &UploadStats{}
+	if log == nil {
+		log = io.Discard
+	}
+
+	list, err := uploads.ListUploads(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, upload := range list {
+		if !upload.Initiated.Before(cutoff) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return stats, fmt.Errorf("abort interrupted: %w", err)
+		}
+
+		fmt.Fprintf(log, "Aborting upload of %s: %s\n", upload.Key, upload.ID)
+		if dryRun {
+			continue
+		}
+
+		if err := uploads.AbortUpload(ctx, upload); err != nil {
+			fmt.Fprintf(log, "  Failed to abort: %v\n", err)
+			stats.Failed++
+			continue
+		}
+		stats.Aborted++
+		stats.Size += upload.Size
+	}
+
+	return stats, nil
+}
+
+// staleUploads lists the incomplete uploads below prefix that were
+// initiated before cutoff, grouped by key
+func staleUploads(ctx context.Context, uploads storage.Uploads, prefix string, cutoff time.Time) (map[string][]storage.Upload, error) {
+	list, err := uploads.ListUploads(ctx, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list incomplete uploads: %w", err)
+	}
+
+	stale := make(map[string][]storage.Upload)
+	for _, upload := range list {
+		if upload.Initiated.Before(cutoff) {
+			stale[upload.Key] = append(stale[upload.Key], upload)
+		}
+	}
+	return stale, nil
+}
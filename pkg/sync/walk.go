@@ -0,0 +1,286 @@
+package sync
+
+import (
+	"fmt"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/vngcloud/aiplatform-util/pkg/ignore"
+	"github.com/vngcloud/aiplatform-util/pkg/storage"
+)
+
+// SymlinkPolicy selects how symbolic links in the workspace are pushed
+type SymlinkPolicy string
+
+const (
+	// SkipSymlinks leaves symbolic links out with a warning
+	SkipSymlinks SymlinkPolicy = "skip"
+
+	// FollowSymlinks pushes the file a link points to under the key of the
+	// link, and the files of a linked directory under the link's directory
+	FollowSymlinks SymlinkPolicy = "follow"
+
+	// PreserveSymlinks pushes links as empty objects recording their
+	// target, which pull recreates as links
+	PreserveSymlinks SymlinkPolicy = "preserve"
+)
+
+// ParseSymlinkPolicy validates a symbolic link policy name
+func ParseSymlinkPolicy(name string) (SymlinkPolicy, error) {
+	switch policy := SymlinkPolicy(name); policy {
+	case SkipSymlinks, FollowSymlinks, PreserveSymlinks:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid symlink policy %q (expected skip, follow or preserve)", name)
+}
+
+// walkOptions selects which entries besides regular files walkLocal reports
+type walkOptions struct {
+	// links is how symbolic links are treated, skipped if empty
+	links SymlinkPolicy
+
+	// emptyDirs reports directories without any reported entry under their
+	// key with a trailing "/", the key of their marker object
+	emptyDirs bool
+
+	// warn receives the entries that are skipped, nil to ignore them
+	warn func(error)
+
+	// skipped, when not nil, collects the keys of the skipped links, which
+	// must be left alone rather than treated as deleted
+	skipped map[string]bool
+}
+
+// localWalker holds the state of a walkLocal run
+type localWalker struct {
+	mountPath string
+	matcher   *ignore.Matcher
+	filter    *ignore.Filter
+	opts      walkOptions
+	fn        func(key string, path string, info os.FileInfo) error
+}
+
+// walkLocal walks the workspace files under prefix and calls fn with the S3
+// key, local path and file info of each regular file selected by filter,
+// and of links and empty directories as selected by opts. Special files
+// such as FIFOs, sockets and devices are skipped with a warning.
+// The workspace metadata directory and unfinished downloads are skipped.
+// When matcher is not nil, .nvignore files are loaded into it as directories
+// are visited. Directories excluded by either are pruned instead of walked.
+// Entries are reported in key order, the order of S3 listings, so that the
+// walk can be merged with a remote listing.
+// A missing prefix has no files, and directories removed while being walked
+// are skipped.
+func walkLocal(mountPath string, prefix string, matcher *ignore.Matcher, filter *ignore.Filter, opts walkOptions, fn func(key string, path string, info os.FileInfo) error) error {
+	if matcher != nil {
+		// Patterns from .nvignore files above the prefix still apply
+		if err := matcher.AddParents(mountPath, filepath.ToSlash(filepath.Clean(prefix))); err != nil {
+			return err
+		}
+	}
+
+	w := &localWalker{
+		mountPath: mountPath,
+		matcher:   matcher,
+		filter:    filter,
+		opts:      opts,
+		fn:        fn,
+	}
+	if w.opts.warn == nil {
+		w.opts.warn = func(error) {}
+	}
+
+	rootKey := filepath.ToSlash(filepath.Clean(prefix))
+	if rootKey == "." {
+		rootKey = ""
+	}
+	// A missing prefix has no files
+	root := filepath.Join(mountPath, prefix)
+	info, err := os.Lstat(root)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.walk(root, rootKey, info, nil)
+	return err
+}
+
+// walk reports the entry at path stored under key, or the entries below it
+// if it is a directory, and returns whether anything was reported.
+// ancestors are the real directories holding the links followed to reach
+// path, which a followed link must not point into again.
+func (w *localWalker) walk(path string, key string, info os.FileInfo, ancestors []string) (bool, error) {
+	// Never touch the workspace metadata directory
+	if isInternalPath(key) {
+		return false, nil
+	}
+
+	switch mode := info.Mode(); {
+	case mode.IsDir():
+		return w.dir(path, key, info, ancestors)
+
+	case mode&os.ModeSymlink != 0:
+		return w.link(key, path, info, ancestors)
+
+	case !mode.IsRegular():
+		w.opts.warn(fmt.Errorf("skipping special file %s", key))
+		return false, nil
+	}
+
+	return w.file(key, path, info)
+}
+
+// dir walks the entries of a directory in key order. A directory in which
+// nothing was reported is reported as its marker when empty directories
+// are kept.
+func (w *localWalker) dir(path string, key string, info os.FileInfo, ancestors []string) (bool, error) {
+	// Prune excluded directories
+	if key != "" && (w.filter.Pruned(key) || (w.matcher != nil && w.matcher.Match(key, true))) {
+		return false, nil
+	}
+	// Pick up the directory's ignore file before visiting its children
+	if w.matcher != nil {
+		if err := w.matcher.AddDir(w.mountPath, key); err != nil {
+			return false, err
+		}
+	}
+
+	entries, err := w.entries(path, key)
+	if os.IsNotExist(err) {
+		// Removed or renamed since its parent was read, the files below
+		// it are gone rather than unreadable
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	reported := false
+	for _, entry := range entries {
+		found, err := w.walk(entry.path, entry.key, entry.info, ancestors)
+		if err != nil {
+			return false, err
+		}
+		reported = reported || found
+	}
+
+	if reported || key == "" || !w.opts.emptyDirs {
+		return reported, nil
+	}
+	return true, w.fn(key+"/", path, info)
+}
+
+// entries lists a directory sorted by key. Keys below a directory sort
+// after its name followed by "/", so a directory, or a link followed to
+// one, is ordered as that.
+func (w *localWalker) entries(path string, key string) ([]localFile, error) {
+	dirEntries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	type sortedEntry struct {
+		localFile
+		order string
+	}
+	sorted := make([]sortedEntry, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		info, err := dirEntry.Info()
+		if os.IsNotExist(err) {
+			// Removed since the directory was read
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		entry := sortedEntry{localFile: localFile{key: pathpkg.Join(key, dirEntry.Name()), path: filepath.Join(path, dirEntry.Name()), info: info}}
+		entry.order = entry.key
+		if info.IsDir() || (w.opts.links == FollowSymlinks && info.Mode()&os.ModeSymlink != 0 && isDir(entry.path)) {
+			entry.order += "/"
+		}
+		sorted = append(sorted, entry)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].order < sorted[j].order })
+
+	entries := make([]localFile, len(sorted))
+	for i, entry := range sorted {
+		entries[i] = entry.localFile
+	}
+	return entries, nil
+}
+
+// isDir reports whether path is or points to a directory
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// link handles a symbolic link according to the symlink policy
+func (w *localWalker) link(key string, path string, info os.FileInfo, ancestors []string) (bool, error) {
+	switch w.opts.links {
+	case PreserveSymlinks:
+		return w.file(key, path, info)
+
+	case FollowSymlinks:
+		target, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			w.opts.warn(fmt.Errorf("skipping broken symbolic link %s: %w", key, err))
+			return false, nil
+		}
+		targetInfo, err := os.Stat(target)
+		if err != nil {
+			w.opts.warn(fmt.Errorf("skipping broken symbolic link %s: %w", key, err))
+			return false, nil
+		}
+		switch {
+		case targetInfo.Mode().IsRegular():
+			return w.file(key, target, targetInfo)
+		case !targetInfo.IsDir():
+			w.opts.warn(fmt.Errorf("skipping symbolic link %s to a special file", key))
+			return false, nil
+		}
+
+		// A link to a directory containing it would be walked forever
+		dir, err := filepath.EvalSymlinks(filepath.Dir(path))
+		if err != nil {
+			return false, err
+		}
+		ancestors = append(ancestors[:len(ancestors):len(ancestors)], dir)
+		for _, ancestor := range ancestors {
+			if rel, err := filepath.Rel(target, ancestor); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				w.opts.warn(fmt.Errorf("skipping symbolic link %s: it points to a directory containing it", key))
+				return false, nil
+			}
+		}
+		return w.dir(target, key, targetInfo, ancestors)
+
+	default:
+		if w.opts.skipped != nil {
+			w.opts.skipped[key] = true
+		}
+		w.opts.warn(fmt.Errorf("skipping symbolic link %s", key))
+		return false, nil
+	}
+}
+
+// file reports a file unless it is an unfinished download or excluded
+func (w *localWalker) file(key string, path string, info os.FileInfo) (bool, error) {
+	if storage.IsPartialDownload(path) || !w.filter.Selected(key, false) || (w.matcher != nil && w.matcher.Match(key, false)) {
+		return false, nil
+	}
+	return true, w.fn(key, path, info)
+}
+
+// storedSize returns the size of the object a walked entry is stored as,
+// which is 0 for links and directories
+func storedSize(info os.FileInfo) int64 {
+	if !info.Mode().IsRegular() {
+		return 0
+	}
+	return info.Size()
+}
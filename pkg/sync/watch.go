@@ -0,0 +1,621 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/vngcloud/aiplatform-util/pkg/ignore"
+	"github.com/vngcloud/aiplatform-util/pkg/storage"
+)
+
+// watchMaxWait is how long a file that never stops changing, such as a log
+// being appended to, waits before it is pushed anyway
+const watchMaxWait = 10 * time.Minute
+
+// watchMaxBackoff caps the delay before a failed push is tried again
+const watchMaxBackoff = 5 * time.Minute
+
+// WatchOptions contains options for watch operations
+type WatchOptions struct {
+	// Push holds the options of every push made while watching. Keys,
+	// DryRun and Plan are not supported.
+	Push PushOptions
+
+	// Debounce is how long a file must stay unchanged before it is pushed,
+	// so that a burst of writes such as a checkpoint being saved is
+	// uploaded once it is complete
+	Debounce time.Duration
+
+	// StatusInterval is how often Status is called, 0 to never call it
+	StatusInterval time.Duration
+
+	// Status, when not nil, receives the progress of the watch every
+	// StatusInterval
+	Status func(status WatchStats)
+}
+
+// WatchStats contains statistics about a watch operation
+type WatchStats struct {
+	Pushes   int `json:"pushes"`
+	Uploaded int `json:"uploaded"`
+	Deleted  int `json:"deleted"`
+	Failed   int `json:"failed"`
+
+	// Pending is the number of changed files not pushed yet
+	Pending int `json:"pending"`
+
+	// PendingKeys lists the changed files not pushed when the watch
+	// stopped, sorted
+	PendingKeys []string `json:"pending_keys,omitempty"`
+
+	// LastPush is when the last push finished, zero before the first one
+	LastPush time.Time `json:"last_push,omitzero"`
+
+	// LastError is the error of the last push if it failed
+	LastError string `json:"last_error,omitempty"`
+}
+
+// pendingFile is a changed file waiting to be pushed
+type pendingFile struct {
+	// first is when the file was first seen changing, changed when it was
+	// last seen changing
+	first   time.Time
+	changed time.Time
+
+	// The file as it was when it last changed, to tell whether it is stable
+	exists   bool
+	size     int64
+	modified time.Time
+}
+
+// watcher tracks the changes made to a workspace
+type watcher struct {
+	fs     *fsnotify.Watcher
+	opts   WatchOptions
+	filter *ignore.Filter
+	obs    Observer
+
+	pending map[string]*pendingFile
+
+	// dirs holds the watched directories
+	dirs map[string]bool
+
+	// matchers holds the .nvignore patterns applying to the files of each
+	// slash-separated directory, read once between full pushes
+	matchers map[string]*ignore.Matcher
+
+	// full is set when changes may have been missed, so that the next push
+	// compares the whole prefix
+	full bool
+
+	// remote is the number of remote files counted by the last full push,
+	// which --max-delete-percent is checked against between full pushes
+	remote int
+
+	// Failed pushes are tried again after a growing delay
+	retryAt time.Time
+	backoff time.Duration
+}
+
+// Watch pushes the changes made to the local workspace until ctx is done.
+// Everything is pushed once when the watch starts, then the files created,
+// modified or deleted under the prefix are pushed once they stay unchanged
+// for the debounce delay. Only the changed files are compared with the
+// bucket, unless changes may have been missed or .nvignore files changed.
+// Failed pushes are tried again with a growing delay. When ctx is done,
+// the changed files not pushed yet are returned in the stats.
+func Watch(ctx context.Context, client storage.Storage, opts WatchOptions) (*WatchStats, error) {
+	if opts.Push.DryRun || opts.Push.Plan != nil {
+		return nil, fmt.Errorf("watch does not support dry runs")
+	}
+
+	root := filepath.Join(opts.Push.MountPath, opts.Push.Prefix)
+	if info, err := os.Stat(root); err != nil {
+		return nil, fmt.Errorf("failed to watch %s: %w", root, err)
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("failed to watch %s: not a directory", root)
+	}
+
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch %s: %w", root, err)
+	}
+	defer fsw.Close()
+
+	w := newWatcher(fsw, opts)
+
+	stats := &WatchStats{}
+	tick := max(opts.Debounce/2, 10*time.Millisecond)
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	var status <-chan time.Time
+	if opts.StatusInterval > 0 && opts.Status != nil {
+		statusTicker := time.NewTicker(opts.StatusInterval)
+		defer statusTicker.Stop()
+		status = statusTicker.C
+	}
+
+	// Everything is pushed right away, changes are pushed on the next ticks
+	if err := w.push(ctx, client, stats, time.Now()); err != nil {
+		w.stop(stats)
+		return stats, nil
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			w.stop(stats)
+			return stats, nil
+
+		case event, ok := <-fsw.Events:
+			if ok {
+				w.handle(event)
+			}
+
+		case err, ok := <-fsw.Errors:
+			if !ok {
+				break
+			}
+			// Events were dropped, compare everything on the next push
+			if errors.Is(err, fsnotify.ErrEventOverflow) {
+				w.full = true
+			}
+			w.obs.Warning(fmt.Errorf("watch: %w", err))
+
+		case now := <-ticker.C:
+			if err := w.push(ctx, client, stats, now); err != nil {
+				w.stop(stats)
+				return stats, nil
+			}
+
+		case <-status:
+			stats.Pending = len(w.pending)
+			opts.Status(*stats)
+		}
+	}
+}
+
+// newWatcher returns a watcher whose first push compares the whole prefix
+func newWatcher(fsw *fsnotify.Watcher, opts WatchOptions) *watcher {
+	return &watcher{
+		fs:       fsw,
+		opts:     opts,
+		filter:   ignore.NewFilter(opts.Push.Prefix, opts.Push.IncludeGlobs, opts.Push.ExcludeGlobs),
+		obs:      observerOrNop(opts.Push.Observer),
+		pending:  make(map[string]*pendingFile),
+		dirs:     make(map[string]bool),
+		matchers: make(map[string]*ignore.Matcher),
+		full:     true,
+	}
+}
+
+// stop records the files left unpushed when the watch stops
+func (w *watcher) stop(stats *WatchStats) {
+	stats.PendingKeys = make([]string, 0, len(w.pending))
+	for key := range w.pending {
+		stats.PendingKeys = append(stats.PendingKeys, key)
+	}
+	sort.Strings(stats.PendingKeys)
+	stats.Pending = len(stats.PendingKeys)
+}
+
+// handle records a file system event
+func (w *watcher) handle(event fsnotify.Event) {
+	// Attribute changes alone do not change the content
+	if event.Op == fsnotify.Chmod {
+		return
+	}
+
+	key, ok := w.key(event.Name)
+	if !ok {
+		return
+	}
+
+	// Changed ignore files select different files
+	if filepath.Base(event.Name) == ignore.FileName {
+		w.full = true
+	}
+
+	if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+		// A directory that was removed or moved away takes its files with
+		// it, which only a full push notices
+		if w.dirs[event.Name] {
+			w.unwatchTree(event.Name)
+			w.full = true
+			return
+		}
+	}
+
+	info, err := os.Lstat(event.Name)
+	if err == nil && info.IsDir() {
+		// Files may have been created in a new directory before it was
+		// watched, so they are marked as changed too
+		if event.Has(fsnotify.Create) && w.selected(key, true) {
+			w.watchTree(event.Name, true)
+			if w.opts.Push.KeepEmptyDirs {
+				w.mark(key+"/", info, time.Now())
+			}
+		}
+		return
+	}
+	// Changes to excluded and ignored files are never pushed
+	if w.selected(key, false) {
+		w.mark(key, info, time.Now())
+	}
+}
+
+// selected reports whether a key is pushed, neither excluded by the
+// filters nor ignored by .nvignore files
+func (w *watcher) selected(key string, isDir bool) bool {
+	if !w.filter.Selected(key, isDir) {
+		return false
+	}
+
+	dir := pathpkg.Dir(key)
+	if dir == "." {
+		dir = ""
+	}
+	matcher, ok := w.matchers[dir]
+	if !ok {
+		// Patterns of the directory itself and of every directory above it
+		matcher = ignore.New()
+		err := matcher.AddParents(w.opts.Push.MountPath, dir)
+		if err == nil {
+			err = matcher.AddDir(w.opts.Push.MountPath, dir)
+		}
+		if err != nil {
+			w.obs.Warning(err)
+		}
+		w.matchers[dir] = matcher
+	}
+	return !matcher.Match(key, isDir)
+}
+
+// key returns the key of a local path, false if it is not pushed
+func (w *watcher) key(path string) (string, bool) {
+	relPath, err := filepath.Rel(w.opts.Push.MountPath, path)
+	if err != nil {
+		return "", false
+	}
+	key := filepath.ToSlash(relPath)
+	if key == "." || strings.HasPrefix(key, "../") || isInternalPath(key) || storage.IsPartialDownload(path) {
+		return "", false
+	}
+	return key, true
+}
+
+// unwatchTree stops watching dir and the directories below it
+func (w *watcher) unwatchTree(dir string) {
+	for path := range w.dirs {
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			// The watch of a removed directory is already gone
+			w.fs.Remove(path)
+			delete(w.dirs, path)
+		}
+	}
+}
+
+// watchTree watches dir and the directories below it that are not excluded
+// or ignored, marking the files found as changed if mark is set
+func (w *watcher) watchTree(dir string, mark bool) {
+	matcher := ignore.New()
+	now := time.Now()
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// Directories may disappear while being walked
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+
+		key, ok := w.key(path)
+		if !entry.IsDir() {
+			if ok && mark && w.filter.Selected(key, false) && !matcher.Match(key, false) {
+				info, _ := os.Lstat(path)
+				w.mark(key, info, now)
+			}
+			return nil
+		}
+		if !ok && path != filepath.Join(w.opts.Push.MountPath, w.opts.Push.Prefix) {
+			return filepath.SkipDir
+		}
+
+		if ok {
+			if path == dir {
+				// Patterns from .nvignore files above dir still apply
+				if err := matcher.AddParents(w.opts.Push.MountPath, key); err != nil {
+					return err
+				}
+			}
+			if w.filter.Pruned(key) || matcher.Match(key, true) {
+				return filepath.SkipDir
+			}
+			if err := matcher.AddDir(w.opts.Push.MountPath, key); err != nil {
+				return err
+			}
+		} else if err := matcher.AddDir(w.opts.Push.MountPath, ""); err != nil {
+			return err
+		}
+		if w.dirs[path] {
+			return nil
+		}
+		if err := w.fs.Add(path); err != nil {
+			return err
+		}
+		w.dirs[path] = true
+		return nil
+	})
+	if err != nil {
+		w.obs.Warning(fmt.Errorf("failed to watch %s: %w", dir, err))
+	}
+}
+
+// mark records a change of key at now, info is nil if the file is gone
+func (w *watcher) mark(key string, info os.FileInfo, now time.Time) {
+	p := w.pending[key]
+	if p == nil {
+		p = &pendingFile{first: now}
+		w.pending[key] = p
+	}
+	p.changed = now
+	p.exists = info != nil
+	if info != nil {
+		p.size, p.modified = info.Size(), info.ModTime()
+	}
+}
+
+// ready returns the changed files that have been stable for the debounce
+// delay, or that kept changing for longer than watchMaxWait
+func (w *watcher) ready(now time.Time) []string {
+	var keys []string
+	for key, p := range w.pending {
+		if now.Sub(p.changed) < w.opts.Debounce && now.Sub(p.first) < watchMaxWait {
+			continue
+		}
+
+		// Files written without generating events, e.g. through a memory
+		// mapping, wait until they stop changing
+		info, _ := os.Lstat(filepath.Join(w.opts.Push.MountPath, filepath.FromSlash(key)))
+		stable := (info == nil) == !p.exists && (info == nil || (info.Size() == p.size && info.ModTime().Equal(p.modified)))
+		if !stable && now.Sub(p.first) < watchMaxWait {
+			w.mark(key, info, now)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// push pushes the files ready to be pushed, if any. It only returns an
+// error if ctx is done.
+func (w *watcher) push(ctx context.Context, client storage.Storage, stats *WatchStats, now time.Time) error {
+	if now.Before(w.retryAt) {
+		return nil
+	}
+
+	var keys []string
+	merge := mergePush
+	if w.full {
+		// Directories are watched before pushing, so that nothing changed
+		// during the push is missed. Ignore files may have changed.
+		clear(w.matchers)
+		w.watchTree(filepath.Join(w.opts.Push.MountPath, w.opts.Push.Prefix), false)
+	} else {
+		keys = w.ready(now)
+		if len(keys) == 0 {
+			return nil
+		}
+		merge = w.mergeChanged(keys)
+	}
+
+	opts := w.opts.Push
+	opts.Keys = nil
+	pushed, counts, err := runPush(ctx, client, opts, merge)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if w.full && err == nil {
+		w.remote = counts.selected
+	}
+
+	stats.Pushes++
+	stats.LastPush = time.Now()
+	stats.LastError = ""
+	if pushed != nil {
+		stats.Uploaded += pushed.Uploaded
+		stats.Deleted += pushed.Deleted
+		stats.Failed += pushed.Failed
+	}
+	if err == nil && pushed.Failed > 0 {
+		err = fmt.Errorf("%d files failed", pushed.Failed)
+	}
+	if err != nil {
+		stats.LastError = err.Error()
+		w.obs.Warning(fmt.Errorf("push failed, trying again in %s: %w", w.delay(), err))
+		w.retryAt = time.Now().Add(w.backoff)
+	} else {
+		w.backoff = 0
+	}
+	if pushed == nil {
+		return nil
+	}
+
+	// Files pushed are no longer pending, failed files are tried again.
+	// Changes made during the push are still queued as events.
+	if w.full {
+		clear(w.pending)
+		w.full = false
+	}
+	for _, key := range keys {
+		delete(w.pending, key)
+	}
+	for _, key := range pushed.FailedKeys {
+		info, _ := os.Lstat(filepath.Join(opts.MountPath, filepath.FromSlash(key)))
+		w.mark(key, info, now)
+	}
+	return nil
+}
+
+// delay doubles the backoff of failed pushes and returns it
+func (w *watcher) delay() time.Duration {
+	w.backoff = min(max(2*w.backoff, w.opts.Debounce, time.Second), watchMaxBackoff)
+	return w.backoff
+}
+
+// errDirNotEmpty stops walking a directory whose marker is checked once an
+// entry is found in it
+var errDirNotEmpty = errors.New("directory not empty")
+
+// mergeChanged returns the merge of a push of the changed keys. Each key is
+// walked and looked up on its own instead of merging the whole prefix with
+// a listing. When empty directories are kept, the markers of the
+// directories above the changed keys are checked too, since adding or
+// removing a file changes whether its directory is empty. Delete limits
+// are checked against the remote files counted by the last full push.
+func (w *watcher) mergeChanged(keys []string) pushMerge {
+	return func(ctx context.Context, client storage.Storage, opts PushOptions, warn func(error), upload func(file localFile, remote *storage.Object) error, remove func(obj storage.Object) error) (pushCounts, error) {
+		counts := pushCounts{selected: w.remote}
+		if hasLocalFiles(opts.MountPath, opts.Prefix) {
+			counts.local = 1
+		}
+		for _, key := range changedKeys(keys, opts) {
+			if err := mergeKey(ctx, client, opts, key, warn, upload, remove, &counts); err != nil {
+				return counts, err
+			}
+		}
+		return counts, nil
+	}
+}
+
+// changedKeys returns the keys to push for the changed keys, with the
+// markers of the directories holding them when empty directories are kept
+func changedKeys(keys []string, opts PushOptions) []string {
+	if !opts.KeepEmptyDirs {
+		return keys
+	}
+
+	rootKey := filepath.ToSlash(filepath.Clean(opts.Prefix))
+	if rootKey == "." {
+		rootKey = ""
+	}
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		seen[key] = true
+		for dir := pathpkg.Dir(strings.TrimSuffix(key, "/")); dir != "." && dir != "/"; dir = pathpkg.Dir(dir) {
+			if rootKey != "" && dir != rootKey && !strings.HasPrefix(dir, rootKey+"/") {
+				break
+			}
+			seen[dir+"/"] = true
+		}
+	}
+	all := make([]string, 0, len(seen))
+	for key := range seen {
+		all = append(all, key)
+	}
+	sort.Strings(all)
+	return all
+}
+
+// mergeKey pushes the local files found at a changed key, or deletes its
+// remote object when there are none and deleting. upload and remove may be
+// nil to only count the deletions.
+func mergeKey(ctx context.Context, client storage.Storage, opts PushOptions, key string, warn func(error), upload func(file localFile, remote *storage.Object) error, remove func(obj storage.Object) error, counts *pushCounts) error {
+	// The walk applies the filters, .nvignore files and symlink policy of a
+	// full push. A directory marker is only pushed if nothing is found below
+	// it, a file that became a directory pushes the files under it.
+	marker := strings.HasSuffix(key, "/")
+	filter := ignore.NewFilter(opts.Prefix, opts.IncludeGlobs, opts.ExcludeGlobs)
+	matcher := ignore.New()
+	skippedLinks := make(map[string]bool)
+	var found []localFile
+	err := walkLocal(opts.MountPath, key, matcher, filter, walkOptions{links: opts.Symlinks, emptyDirs: opts.KeepEmptyDirs, warn: warn, skipped: skippedLinks}, func(fileKey string, path string, info os.FileInfo) error {
+		if marker && fileKey != key {
+			return errDirNotEmpty
+		}
+		found = append(found, localFile{key: fileKey, path: path, info: info})
+		return nil
+	})
+	if errors.Is(err, errDirNotEmpty) {
+		found = nil
+	} else if err != nil {
+		return fmt.Errorf("failed to walk directory: %w", err)
+	}
+
+	if upload != nil {
+		for _, file := range found {
+			remote, err := statRemote(ctx, client, file.key)
+			if err != nil {
+				return err
+			}
+			if err := upload(file, remote); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Excluded and ignored files are never deleted, skipped links are left
+	// alone
+	if !opts.Delete || (len(found) > 0 && found[0].key == key) || skippedLinks[key] {
+		return nil
+	}
+	name, isDir := strings.CutSuffix(key, "/")
+	if !filter.Selected(name, isDir) || matcher.Match(name, isDir) {
+		return nil
+	}
+	remote, err := statRemote(ctx, client, key)
+	if err != nil || remote == nil {
+		return err
+	}
+	counts.deletions++
+	if remove == nil {
+		return nil
+	}
+	return remove(*remote)
+}
+
+// statRemote returns the remote object stored under key, nil if there is
+// none
+func statRemote(ctx context.Context, client storage.Storage, key string) (*storage.Object, error) {
+	obj, err := client.Stat(ctx, key)
+	if storage.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat remote object: %w", err)
+	}
+	return obj, nil
+}
+
+// hasLocalFiles reports whether the prefix directory has any entry besides
+// the workspace metadata, the source of a push is empty otherwise
+func hasLocalFiles(mountPath string, prefix string) bool {
+	dir, err := os.Open(filepath.Join(mountPath, prefix))
+	if err != nil {
+		return false
+	}
+	defer dir.Close()
+
+	for {
+		names, err := dir.Readdirnames(64)
+		for _, name := range names {
+			if !isInternalPath(pathpkg.Join(filepath.ToSlash(prefix), name)) {
+				return true
+			}
+		}
+		if err != nil {
+			return false
+		}
+	}
+}
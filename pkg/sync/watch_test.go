@@ -0,0 +1,168 @@
+package sync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/vngcloud/aiplatform-util/pkg/storage"
+)
+
+// countingStorage records the listings, lookups and uploads made through it
+type countingStorage struct {
+	storage.Storage
+	mu     sync.Mutex
+	listed int
+	stated []string
+	put    []string
+}
+
+func (s *countingStorage) ListEach(ctx context.Context, prefix string, recursive bool, startAfter string, fn func(storage.Object) error) error {
+	s.mu.Lock()
+	s.listed++
+	s.mu.Unlock()
+	return s.Storage.ListEach(ctx, prefix, recursive, startAfter, fn)
+}
+
+func (s *countingStorage) Stat(ctx context.Context, key string) (*storage.Object, error) {
+	s.mu.Lock()
+	s.stated = append(s.stated, key)
+	s.mu.Unlock()
+	return s.Storage.Stat(ctx, key)
+}
+
+func (s *countingStorage) Put(ctx context.Context, localPath string, key string) (*storage.Object, error) {
+	s.mu.Lock()
+	s.put = append(s.put, key)
+	s.mu.Unlock()
+	return s.Storage.Put(ctx, localPath, key)
+}
+
+func TestWatchPushesOnlyChangedFiles(t *testing.T) {
+	mountPath := t.TempDir()
+	remote := storage.NewMemory()
+	for _, key := range []string{"a.txt", "b.txt", "c.txt"} {
+		writeFile(t, mountPath, key, key, past)
+		remote.PutBytes(key, []byte(key), past)
+	}
+	writeFile(t, mountPath, ".nvignore", "*.log\n", past)
+
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsw.Close()
+
+	ctx := context.Background()
+	client := &countingStorage{Storage: remote}
+	w := newWatcher(fsw, WatchOptions{Push: PushOptions{MountPath: mountPath, Delete: true, Parallel: 2, ExcludeGlobs: []string{"tmp"}}})
+	stats := &WatchStats{}
+	if err := w.push(ctx, client, stats, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if client.listed == 0 {
+		t.Fatal("first push did not list the bucket")
+	}
+
+	// Changes to ignored and excluded files are not marked
+	writeFile(t, mountPath, "a.txt", "alpha, edited", time.Now())
+	writeFile(t, mountPath, "debug.log", "debug", time.Now())
+	writeFile(t, mountPath, "tmp/scratch", "scratch", time.Now())
+	if err := os.Remove(filepath.Join(mountPath, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range []fsnotify.Event{
+		{Name: filepath.Join(mountPath, "a.txt"), Op: fsnotify.Write},
+		{Name: filepath.Join(mountPath, "debug.log"), Op: fsnotify.Create},
+		{Name: filepath.Join(mountPath, "tmp"), Op: fsnotify.Create},
+		{Name: filepath.Join(mountPath, "b.txt"), Op: fsnotify.Remove},
+	} {
+		w.handle(event)
+	}
+	pending := make([]string, 0, len(w.pending))
+	for key := range w.pending {
+		pending = append(pending, key)
+	}
+	sort.Strings(pending)
+	if want := []string{"a.txt", "b.txt"}; !slices.Equal(pending, want) {
+		t.Fatalf("pending = %v, want %v", pending, want)
+	}
+
+	// Only the changed files are looked up, without listing the bucket
+	client = &countingStorage{Storage: remote}
+	if err := w.push(ctx, client, stats, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if stats.LastError != "" {
+		t.Fatalf("push failed: %s", stats.LastError)
+	}
+	if client.listed != 0 {
+		t.Errorf("pushing changes listed the bucket %d times", client.listed)
+	}
+	sort.Strings(client.stated)
+	client.stated = slices.Compact(client.stated)
+	if want := []string{"a.txt", "b.txt"}; !slices.Equal(client.stated, want) {
+		t.Errorf("looked up %v, want %v", client.stated, want)
+	}
+	if want := []string{"a.txt"}; !slices.Equal(client.put, want) {
+		t.Errorf("uploaded %v, want %v", client.put, want)
+	}
+	if data, _ := remote.GetBytes("a.txt"); string(data) != "alpha, edited" {
+		t.Errorf("remote a.txt = %q, want the edited content", data)
+	}
+	if _, err := remote.GetBytes("b.txt"); err == nil {
+		t.Error("the removed file was not deleted")
+	}
+	if _, err := remote.GetBytes("c.txt"); err != nil {
+		t.Errorf("an unchanged file was deleted: %v", err)
+	}
+	if len(w.pending) != 0 {
+		t.Errorf("%d files still pending after the push", len(w.pending))
+	}
+}
+
+func TestWatchChecksDeleteLimitsAgainstLastFullPush(t *testing.T) {
+	mountPath := t.TempDir()
+	remote := storage.NewMemory()
+	for _, key := range []string{"a.txt", "b.txt", "c.txt", "d.txt"} {
+		writeFile(t, mountPath, key, key, past)
+		remote.PutBytes(key, []byte(key), past)
+	}
+
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fsw.Close()
+
+	ctx := context.Background()
+	w := newWatcher(fsw, WatchOptions{Push: PushOptions{MountPath: mountPath, Delete: true, Parallel: 2, Limits: DeleteLimits{MaxDeletePercent: 40}}})
+	stats := &WatchStats{}
+	if err := w.push(ctx, remote, stats, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 2 of the 4 remote files is over the limit
+	for _, key := range []string{"a.txt", "b.txt"} {
+		path := filepath.Join(mountPath, key)
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+		w.handle(fsnotify.Event{Name: path, Op: fsnotify.Remove})
+	}
+	if err := w.push(ctx, remote, stats, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if stats.LastError == "" || stats.Deleted != 0 {
+		t.Errorf("deleted %d files over --max-delete-percent, last error %q", stats.Deleted, stats.LastError)
+	}
+	if len(w.pending) != 2 {
+		t.Errorf("%d files pending after the refused push, want 2", len(w.pending))
+	}
+}